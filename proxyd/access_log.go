@@ -0,0 +1,398 @@
+package proxyd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+const (
+	ContextKeyAccessLog    = "access_log"
+	ContextKeyForwardStats = "forward_stats"
+
+	AccessLogOutputStdout = "stdout"
+
+	redactedValue = "[REDACTED]"
+)
+
+// AccessLogger writes one structured record per HTTP request as JSON lines
+type AccessLogger struct {
+	mtx        sync.Mutex
+	w          io.WriteCloser
+	sampleRate float64
+	redact     map[string]bool
+}
+
+func NewAccessLogger(cfg AccessLogConfig) (*AccessLogger, error) {
+	if cfg.SampleRate < 0 || cfg.SampleRate > 1 {
+		return nil, errors.New("access_log.sample_rate must be between 0 and 1")
+	}
+
+	var w io.WriteCloser
+	switch cfg.Output {
+	case "", AccessLogOutputStdout:
+		w = nopWriteCloser{os.Stdout}
+	default:
+		f, err := newRotatingFile(cfg.Output, int64(cfg.MaxSizeMB)*1024*1024, cfg.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+
+	sampleRate := cfg.SampleRate
+	if sampleRate == 0 {
+		sampleRate = 1
+	}
+
+	redact := make(map[string]bool, len(cfg.RedactFields))
+	for _, field := range cfg.RedactFields {
+		redact[field] = true
+	}
+
+	return &AccessLogger{
+		w:          w,
+		sampleRate: sampleRate,
+		redact:     redact,
+	}, nil
+}
+
+// NewRecord returns a new record for the request, or nil if the request is not sampled
+func (l *AccessLogger) NewRecord(ctx context.Context) *AccessLogRecord {
+	if l == nil {
+		return nil
+	}
+	if l.sampleRate < 1 && rand.Float64() >= l.sampleRate {
+		return nil
+	}
+	return &AccessLogRecord{
+		start:     time.Now(),
+		reqID:     GetReqID(ctx),
+		auth:      GetAuthCtx(ctx),
+		remoteIP:  stripXFF(GetXForwardedFor(ctx)),
+		userAgent: GetUserAgent(ctx),
+		origin:    GetOrigin(ctx),
+	}
+}
+
+func (l *AccessLogger) Write(rec *AccessLogRecord) {
+	if l == nil || rec == nil {
+		return
+	}
+
+	line, err := json.Marshal(rec.toMap(l.redact))
+	if err != nil {
+		log.Error("error marshalling access log record", "req_id", rec.reqID, "err", err)
+		return
+	}
+	line = append(line, '\n')
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if _, err := l.w.Write(line); err != nil {
+		log.Error("error writing access log record", "req_id", rec.reqID, "err", err)
+	}
+}
+
+func (l *AccessLogger) Close() error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.w.Close()
+}
+
+// AccessLogRecord accumulates the details of a request while it is served
+type AccessLogRecord struct {
+	mtx sync.Mutex
+
+	start     time.Time
+	reqID     string
+	auth      string
+	remoteIP  string
+	userAgent string
+	origin    string
+
+	isBatch      bool
+	httpStatus   int
+	responseSize int
+	errorCode    int
+	calls        []*accessLogCall
+}
+
+// accessLogCall holds the details of a single JSON-RPC call
+type accessLogCall struct {
+	method          string
+	backendGroup    string
+	backend         string
+	cacheHit        bool
+	attempts        int
+	upstreamLatency time.Duration
+	errorCode       int
+}
+
+func withAccessLogRecord(ctx context.Context, rec *AccessLogRecord) context.Context {
+	if rec == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, ContextKeyAccessLog, rec) // nolint:staticcheck
+}
+
+func getAccessLogRecord(ctx context.Context) *AccessLogRecord {
+	rec, ok := ctx.Value(ContextKeyAccessLog).(*AccessLogRecord)
+	if !ok {
+		return nil
+	}
+	return rec
+}
+
+func (r *AccessLogRecord) initCalls(n int, isBatch bool) {
+	if r == nil {
+		return
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.isBatch = isBatch
+	r.calls = make([]*accessLogCall, n)
+	for i := range r.calls {
+		r.calls[i] = &accessLogCall{}
+	}
+}
+
+func (r *AccessLogRecord) call(i int, f func(c *accessLogCall)) {
+	if r == nil {
+		return
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if i < len(r.calls) {
+		f(r.calls[i])
+	}
+}
+
+func (r *AccessLogRecord) setMethod(i int, method string) {
+	r.call(i, func(c *accessLogCall) { c.method = method })
+}
+
+func (r *AccessLogRecord) setBackendGroup(i int, group string) {
+	r.call(i, func(c *accessLogCall) { c.backendGroup = group })
+}
+
+func (r *AccessLogRecord) setCacheHit(i int) {
+	r.call(i, func(c *accessLogCall) { c.cacheHit = true })
+}
+
+func (r *AccessLogRecord) setForward(i int, servedBy string, stats *forwardStats) {
+	r.call(i, func(c *accessLogCall) {
+		c.backend = strings.TrimPrefix(servedBy, c.backendGroup+"/")
+		c.attempts, c.upstreamLatency = stats.get()
+	})
+}
+
+func (r *AccessLogRecord) setResponses(responses []*RPCRes) {
+	for i, res := range responses {
+		if res != nil && res.IsError() {
+			code := res.Error.Code
+			r.call(i, func(c *accessLogCall) { c.errorCode = code })
+		}
+	}
+}
+
+func (r *AccessLogRecord) setResult(httpStatus int, responseSize int, errorCode int) {
+	if r == nil {
+		return
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.httpStatus = httpStatus
+	r.responseSize = responseSize
+	r.errorCode = errorCode
+}
+
+func (r *AccessLogRecord) toMap(redact map[string]bool) map[string]interface{} {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	m := map[string]interface{}{
+		"timestamp":        r.start.UTC().Format(time.RFC3339Nano),
+		"req_id":           r.reqID,
+		"auth":             r.auth,
+		"remote_ip":        r.remoteIP,
+		"user_agent":       r.userAgent,
+		"origin":           r.origin,
+		"batch":            r.isBatch,
+		"batch_size":       len(r.calls),
+		"http_status":      r.httpStatus,
+		"response_size":    r.responseSize,
+		"total_latency_ms": durationToMs(time.Since(r.start)),
+	}
+	if r.errorCode != 0 {
+		m["error_code"] = r.errorCode
+	}
+
+	if !r.isBatch && len(r.calls) == 1 {
+		// single requests are flattened into the record
+		for k, v := range r.calls[0].toMap() {
+			if _, exists := m[k]; !exists || k == "error_code" {
+				m[k] = v
+			}
+		}
+	} else if len(r.calls) > 0 {
+		calls := make([]map[string]interface{}, len(r.calls))
+		for i, c := range r.calls {
+			calls[i] = redactMap(c.toMap(), redact)
+		}
+		m["calls"] = calls
+	}
+
+	return redactMap(m, redact)
+}
+
+func (c *accessLogCall) toMap() map[string]interface{} {
+	retries := 0
+	if c.attempts > 1 {
+		retries = c.attempts - 1
+	}
+	m := map[string]interface{}{
+		"method":              c.method,
+		"backend_group":       c.backendGroup,
+		"backend":             c.backend,
+		"cache_hit":           c.cacheHit,
+		"retries":             retries,
+		"upstream_latency_ms": durationToMs(c.upstreamLatency),
+	}
+	if c.errorCode != 0 {
+		m["error_code"] = c.errorCode
+	}
+	return m
+}
+
+func redactMap(m map[string]interface{}, redact map[string]bool) map[string]interface{} {
+	for k := range m {
+		if redact[k] {
+			m[k] = redactedValue
+		}
+	}
+	return m
+}
+
+func durationToMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// forwardStats collects the upstream attempts made to serve a forwarded batch,
+// across retries and backends
+type forwardStats struct {
+	mtx             sync.Mutex
+	attempts        int
+	upstreamLatency time.Duration
+}
+
+func withForwardStats(ctx context.Context, stats *forwardStats) context.Context {
+	return context.WithValue(ctx, ContextKeyForwardStats, stats) // nolint:staticcheck
+}
+
+// RecordForwardAttempt records an upstream attempt in the forward stats of the context, if any
+func RecordForwardAttempt(ctx context.Context, latency time.Duration) {
+	stats, ok := ctx.Value(ContextKeyForwardStats).(*forwardStats)
+	if !ok {
+		return
+	}
+	stats.mtx.Lock()
+	defer stats.mtx.Unlock()
+	stats.attempts++
+	stats.upstreamLatency += latency
+}
+
+func (s *forwardStats) get() (int, time.Duration) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.attempts, s.upstreamLatency
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// rotatingFile is a file writer that rotates the file once it reaches maxSize,
+// keeping up to maxBackups rotated files named <path>.1, <path>.2, ...
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	f          *os.File
+	size       int64
+}
+
+func newRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	rf := &rotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return wrapErr(err, "error opening access log file")
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return wrapErr(err, "error opening access log file")
+	}
+	rf.f = f
+	rf.size = info.Size()
+	return nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			// the current file is kept, and the rotation is retried once maxSize more bytes are written
+			log.Warn("error rotating file", "path", rf.path, "err", err)
+			rf.size = 0
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// rotate moves the current file aside and opens a new one. The current file stays open until
+// the new one is, so that the writer always has a file to write to
+func (rf *rotatingFile) rotate() error {
+	if rf.maxBackups > 0 {
+		for i := rf.maxBackups - 1; i > 0; i-- {
+			_ = os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+		}
+		if err := os.Rename(rf.path, rf.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(rf.path); err != nil {
+		return err
+	}
+	old := rf.f
+	if err := rf.open(); err != nil {
+		return err
+	}
+	return old.Close()
+}
+
+func (rf *rotatingFile) Close() error {
+	return rf.f.Close()
+}
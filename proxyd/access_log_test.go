@@ -0,0 +1,75 @@
+package proxyd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	rf, err := newRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+
+	for _, line := range []string{"aaaaaaaa\n", "bbbbbbbb\n", "cccccccc\n", "dddddddd\n"} {
+		_, err := rf.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	require.NoError(t, rf.Close())
+
+	for name, expected := range map[string]string{
+		path:        "dddddddd\n",
+		path + ".1": "cccccccc\n",
+		path + ".2": "bbbbbbbb\n",
+	} {
+		data, err := os.ReadFile(name)
+		require.NoError(t, err)
+		require.Equal(t, expected, string(data))
+	}
+	_, err = os.Stat(path + ".3")
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestRotatingFileFailedRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	// the file can't be renamed over a non-empty directory
+	require.NoError(t, os.MkdirAll(filepath.Join(path+".1", "dir"), 0o755))
+	rf, err := newRotatingFile(path, 10, 1)
+	require.NoError(t, err)
+
+	for _, line := range []string{"aaaaaaaa\n", "bbbbbbbb\n"} {
+		_, err := rf.Write([]byte(line))
+		require.NoError(t, err)
+	}
+
+	// the rotation succeeds once the backup path is free again
+	require.NoError(t, os.RemoveAll(path+".1"))
+	_, err = rf.Write([]byte("cccccccc\n"))
+	require.NoError(t, err)
+	require.NoError(t, rf.Close())
+
+	for name, expected := range map[string]string{
+		path:        "cccccccc\n",
+		path + ".1": "aaaaaaaa\nbbbbbbbb\n",
+	} {
+		data, err := os.ReadFile(name)
+		require.NoError(t, err)
+		require.Equal(t, expected, string(data))
+	}
+}
+
+func TestAccessLogRecordRedaction(t *testing.T) {
+	rec := &AccessLogRecord{reqID: "abc", remoteIP: "1.2.3.4"}
+	rec.initCalls(2, true)
+	rec.setMethod(0, "eth_chainId")
+	rec.setBackendGroup(0, "main")
+
+	m := rec.toMap(map[string]bool{"remote_ip": true, "method": true})
+	require.Equal(t, "abc", m["req_id"])
+	require.Equal(t, redactedValue, m["remote_ip"])
+	calls := m["calls"].([]map[string]interface{})
+	require.Equal(t, redactedValue, calls[0]["method"])
+	require.Equal(t, "main", calls[0]["backend_group"])
+}
@@ -0,0 +1,312 @@
+package proxyd
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	ContextKeyPriorityClass   = "priority_class"
+	ContextKeyInternalRequest = "internal_request"
+
+	DefaultAdmissionWeight   = 1
+	DefaultAdmissionMaxQueue = 1000
+	DefaultAdmissionMaxWait  = 5 * time.Second
+
+	AdmissionResultAdmitted   = "admitted"
+	AdmissionResultQueueFull  = "queue_full"
+	AdmissionResultOverloaded = "overloaded"
+	AdmissionResultEvicted    = "evicted"
+	AdmissionResultTimeout    = "timeout"
+	AdmissionResultCanceled   = "canceled"
+)
+
+// RPCSemaphore limits the concurrent requests to the backends, see max_concurrent_rpcs
+type RPCSemaphore interface {
+	Acquire(ctx context.Context, n int64) error
+	Release(n int64)
+}
+
+// AdmissionController is a RPCSemaphore queueing the requests over its capacity by priority class.
+// Each class has a bounded queue where requests wait up to a deadline, and the queues are dequeued
+// by weighted round robin. Once max_queued requests are waiting, the newest requests of the classes
+// with a lower weight are shed first to make room for the higher priority ones
+type AdmissionController struct {
+	capacity     int64
+	maxQueued    int
+	defaultClass *priorityClass
+	methods      map[string]string
+	auth         map[string]string
+
+	mtx      sync.Mutex
+	inFlight int64
+	queued   int
+	// classes are sorted by increasing weight, i.e. in shedding order
+	classes       []*priorityClass
+	classesByName map[string]*priorityClass
+}
+
+type priorityClass struct {
+	name     string
+	weight   int
+	maxQueue int
+	maxWait  time.Duration
+
+	// current is the smooth weighted round robin counter of the class
+	current int
+	waiters *list.List
+}
+
+type admissionWaiter struct {
+	n        int64
+	class    *priorityClass
+	enqueued time.Time
+	elem     *list.Element
+	// ready receives nil once admitted, or an error if shed
+	ready chan error
+}
+
+var errAdmissionDefaultClass = errors.New("admission default_class must be one of the classes")
+
+// NewAdmissionController creates an admission controller admitting up to capacity concurrent requests
+func NewAdmissionController(capacity int64, cfg AdmissionConfig) (*AdmissionController, error) {
+	ac := &AdmissionController{
+		capacity:      capacity,
+		maxQueued:     cfg.MaxQueued,
+		methods:       cfg.Methods,
+		auth:          cfg.Auth,
+		classesByName: make(map[string]*priorityClass, len(cfg.Classes)),
+	}
+	for name, classCfg := range cfg.Classes {
+		class := &priorityClass{
+			name:     name,
+			weight:   classCfg.Weight,
+			maxQueue: classCfg.MaxQueue,
+			maxWait:  time.Duration(classCfg.MaxWait),
+			waiters:  list.New(),
+		}
+		if class.weight == 0 {
+			class.weight = DefaultAdmissionWeight
+		}
+		if class.maxQueue == 0 {
+			class.maxQueue = DefaultAdmissionMaxQueue
+		}
+		if class.maxWait == 0 {
+			class.maxWait = DefaultAdmissionMaxWait
+		}
+		if class.weight < 0 || class.maxQueue < 0 || class.maxWait < 0 {
+			return nil, fmt.Errorf("admission class %s: weight, max_queue and max_wait must not be negative", name)
+		}
+		ac.classes = append(ac.classes, class)
+		ac.classesByName[name] = class
+		RecordAdmissionQueueDepth(name, 0)
+	}
+	sort.Slice(ac.classes, func(i, j int) bool {
+		if ac.classes[i].weight != ac.classes[j].weight {
+			return ac.classes[i].weight < ac.classes[j].weight
+		}
+		return ac.classes[i].name < ac.classes[j].name
+	})
+
+	ac.defaultClass = ac.classesByName[cfg.DefaultClass]
+	if ac.defaultClass == nil {
+		return nil, errAdmissionDefaultClass
+	}
+	return ac, nil
+}
+
+// Classify returns the priority class of a call: the one of the routing rule it matched if any,
+// else the one of its method, else the one of its auth alias, else the default class
+func (ac *AdmissionController) Classify(ctx context.Context, method string, rule *RoutingRule) string {
+	if ac == nil {
+		return ""
+	}
+	if rule != nil && rule.Priority != "" {
+		return rule.Priority
+	}
+	if class, ok := ac.methods[method]; ok {
+		return class
+	}
+	if class, ok := ac.auth[GetAuthCtx(ctx)]; ok {
+		return class
+	}
+	return ac.defaultClass.name
+}
+
+func withPriorityClass(ctx context.Context, class string) context.Context {
+	if class == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, ContextKeyPriorityClass, class) // nolint:staticcheck
+}
+
+// withInternalRequest marks the requests of proxyd itself, such as the ones of the consensus poller
+func withInternalRequest(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ContextKeyInternalRequest, true) // nolint:staticcheck
+}
+
+func isInternalRequest(ctx context.Context) bool {
+	internal, _ := ctx.Value(ContextKeyInternalRequest).(bool)
+	return internal
+}
+
+func (ac *AdmissionController) classFor(ctx context.Context) *priorityClass {
+	name, _ := ctx.Value(ContextKeyPriorityClass).(string)
+	if class, ok := ac.classesByName[name]; ok {
+		return class
+	}
+	return ac.defaultClass
+}
+
+// Acquire admits a request of the priority class of the context, waiting in the queue of the class
+// if the controller is at capacity. It returns ErrRequestShed if the request is shed.
+// Internal requests are admitted right away, even over capacity, as the consensus of the
+// backend groups must keep being updated under load
+func (ac *AdmissionController) Acquire(ctx context.Context, n int64) error {
+	if isInternalRequest(ctx) {
+		ac.mtx.Lock()
+		ac.inFlight += n
+		ac.mtx.Unlock()
+		return nil
+	}
+	class := ac.classFor(ctx)
+
+	ac.mtx.Lock()
+	if ac.queued == 0 && ac.inFlight+n <= ac.capacity {
+		ac.inFlight += n
+		ac.mtx.Unlock()
+		RecordAdmission(class.name, AdmissionResultAdmitted, 0)
+		return nil
+	}
+	if class.waiters.Len() >= class.maxQueue {
+		ac.mtx.Unlock()
+		RecordAdmission(class.name, AdmissionResultQueueFull, 0)
+		return ErrRequestShed
+	}
+	if ac.maxQueued > 0 && ac.queued >= ac.maxQueued && !ac.evictLocked(class) {
+		ac.mtx.Unlock()
+		RecordAdmission(class.name, AdmissionResultOverloaded, 0)
+		return ErrRequestShed
+	}
+	w := &admissionWaiter{
+		n:        n,
+		class:    class,
+		enqueued: time.Now(),
+		ready:    make(chan error, 1),
+	}
+	w.elem = class.waiters.PushBack(w)
+	ac.queued++
+	RecordAdmissionQueueDepth(class.name, class.waiters.Len())
+	ac.mtx.Unlock()
+
+	timer := time.NewTimer(class.maxWait)
+	defer timer.Stop()
+
+	var result string
+	var err error
+	select {
+	case readyErr := <-w.ready:
+		return readyErr
+	case <-timer.C:
+		result, err = AdmissionResultTimeout, ErrRequestShed
+	case <-ctx.Done():
+		result, err = AdmissionResultCanceled, ctx.Err()
+	}
+
+	ac.mtx.Lock()
+	if w.elem != nil {
+		ac.removeLocked(w)
+		// the head of the queue may fit now
+		ac.dispatchLocked()
+		ac.mtx.Unlock()
+		RecordAdmission(class.name, result, time.Since(w.enqueued))
+		return err
+	}
+	ac.mtx.Unlock()
+
+	// the request was admitted or shed meanwhile
+	if readyErr := <-w.ready; readyErr != nil {
+		return readyErr
+	}
+	ac.Release(n)
+	return err
+}
+
+// Release releases n admitted requests, and admits the waiting ones
+func (ac *AdmissionController) Release(n int64) {
+	ac.mtx.Lock()
+	defer ac.mtx.Unlock()
+	ac.inFlight -= n
+	if ac.inFlight < 0 {
+		panic("admission: released more than held")
+	}
+	ac.dispatchLocked()
+}
+
+// dispatchLocked admits waiters while there is capacity, picking their class by smooth weighted round robin
+func (ac *AdmissionController) dispatchLocked() {
+	for ac.queued > 0 {
+		var next *priorityClass
+		total := 0
+		for _, class := range ac.classes {
+			if class.waiters.Len() == 0 {
+				continue
+			}
+			total += class.weight
+			// ties go to the higher weight, as the classes are sorted by increasing weight
+			if next == nil || class.current+class.weight >= next.current+next.weight {
+				next = class
+			}
+		}
+
+		w := next.waiters.Front().Value.(*admissionWaiter)
+		if ac.inFlight+w.n > ac.capacity {
+			return
+		}
+		for _, class := range ac.classes {
+			if class.waiters.Len() > 0 {
+				class.current += class.weight
+			}
+		}
+		next.current -= total
+
+		ac.removeLocked(w)
+		ac.inFlight += w.n
+		RecordAdmission(next.name, AdmissionResultAdmitted, time.Since(w.enqueued))
+		w.ready <- nil
+	}
+}
+
+// evictLocked sheds the newest waiter of the lowest priority class below class, if any
+func (ac *AdmissionController) evictLocked(class *priorityClass) bool {
+	for _, lower := range ac.classes {
+		if lower.weight >= class.weight {
+			return false
+		}
+		if lower.waiters.Len() == 0 {
+			continue
+		}
+		w := lower.waiters.Back().Value.(*admissionWaiter)
+		ac.removeLocked(w)
+		RecordAdmission(lower.name, AdmissionResultEvicted, time.Since(w.enqueued))
+		w.ready <- ErrRequestShed
+		return true
+	}
+	return false
+}
+
+func (ac *AdmissionController) removeLocked(w *admissionWaiter) {
+	w.class.waiters.Remove(w.elem)
+	w.elem = nil
+	ac.queued--
+	if w.class.waiters.Len() == 0 {
+		// idle classes don't accumulate credit
+		w.class.current = 0
+	}
+	RecordAdmissionQueueDepth(w.class.name, w.class.waiters.Len())
+}
@@ -0,0 +1,161 @@
+package proxyd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAdmissionController(t *testing.T, maxQueued int) *AdmissionController {
+	ac, err := NewAdmissionController(1, AdmissionConfig{
+		DefaultClass: "standard",
+		Classes: map[string]*PriorityClassConfig{
+			"critical": {Weight: 3},
+			"standard": {Weight: 2, MaxQueue: 1, MaxWait: TOMLDuration(20 * time.Millisecond)},
+			"bulk":     {Weight: 1},
+		},
+		Methods:   map[string]string{"eth_getLogs": "bulk"},
+		Auth:      map[string]string{"partner": "critical"},
+		MaxQueued: maxQueued,
+	})
+	require.NoError(t, err)
+	return ac
+}
+
+// enqueue acquires in the background, and waits for the request to be queued
+func enqueue(t *testing.T, ac *AdmissionController, ctx context.Context, class string) chan error {
+	waiters := ac.classesByName[class].waiters
+	ac.mtx.Lock()
+	queued := waiters.Len()
+	ac.mtx.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- ac.Acquire(withPriorityClass(ctx, class), 1)
+	}()
+	require.Eventually(t, func() bool {
+		ac.mtx.Lock()
+		defer ac.mtx.Unlock()
+		return waiters.Len() > queued
+	}, time.Second, time.Millisecond)
+	return done
+}
+
+func TestAdmissionWeightedDequeuing(t *testing.T) {
+	ac := newTestAdmissionController(t, 0)
+	ctx := context.Background()
+	require.NoError(t, ac.Acquire(ctx, 1))
+
+	admitted := make(chan string, 8)
+	for i := 0; i < 4; i++ {
+		for _, class := range []string{"bulk", "critical"} {
+			done := enqueue(t, ac, ctx, class)
+			go func(class string) {
+				if err := <-done; err != nil {
+					class = err.Error()
+				}
+				admitted <- class
+			}(class)
+		}
+	}
+
+	var order []string
+	for i := 0; i < 8; i++ {
+		ac.Release(1)
+		order = append(order, <-admitted)
+	}
+	require.Equal(t, []string{
+		"critical", "critical", "bulk", "critical",
+		"critical", "bulk", "bulk", "bulk",
+	}, order)
+
+	ac.Release(1)
+	require.Zero(t, ac.inFlight)
+}
+
+func TestAdmissionShedding(t *testing.T) {
+	ac := newTestAdmissionController(t, 2)
+	ctx := context.Background()
+	require.NoError(t, ac.Acquire(ctx, 1))
+
+	// the lowest priority requests are shed first
+	bulk1 := enqueue(t, ac, ctx, "bulk")
+	bulk2 := enqueue(t, ac, ctx, "bulk")
+	critical := enqueue(t, ac, ctx, "critical")
+	require.ErrorIs(t, <-bulk2, ErrRequestShed)
+	require.ErrorIs(t, ac.Acquire(withPriorityClass(ctx, "bulk"), 1), ErrRequestShed)
+
+	// the queue of a class is bounded
+	standard := enqueue(t, ac, ctx, "standard")
+	require.ErrorIs(t, <-bulk1, ErrRequestShed)
+	require.ErrorIs(t, ac.Acquire(ctx, 1), ErrRequestShed)
+
+	// requests are shed once they waited for max_wait
+	require.ErrorIs(t, <-standard, ErrRequestShed)
+
+	ac.Release(1)
+	require.NoError(t, <-critical)
+
+	// canceled requests leave the queue
+	cctx, cancel := context.WithCancel(ctx)
+	canceled := enqueue(t, ac, cctx, "critical")
+	cancel()
+	require.ErrorIs(t, <-canceled, context.Canceled)
+	require.Zero(t, ac.queued)
+
+	ac.Release(1)
+	require.Zero(t, ac.inFlight)
+}
+
+func TestAdmissionInternalRequests(t *testing.T) {
+	ac := newTestAdmissionController(t, 1)
+	ctx := context.Background()
+	require.NoError(t, ac.Acquire(ctx, 1))
+	standard := enqueue(t, ac, ctx, "standard")
+
+	// internal requests skip the full queues, over capacity
+	internal := withInternalRequest(ctx)
+	require.NoError(t, ac.Acquire(internal, 1))
+	require.Equal(t, int64(2), ac.inFlight)
+
+	// the queued request waits for the capacity freed by the internal request too
+	ac.Release(1)
+	require.Equal(t, 1, ac.queued)
+	ac.Release(1)
+	require.NoError(t, <-standard)
+	ac.Release(1)
+	require.Zero(t, ac.inFlight)
+
+	// the calls of the consensus poller go through a saturated controller
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":67,"result":"0x5"}`))
+	}))
+	defer server.Close()
+	be := NewBackend("node", server.URL, "", ac)
+	require.NoError(t, ac.Acquire(ctx, 1))
+	enqueue(t, ac, ctx, "standard")
+	res := new(RPCRes)
+	require.NoError(t, be.ForwardRPC(ctx, res, "67", "net_peerCount"))
+	require.Equal(t, "0x5", res.Result)
+}
+
+func TestAdmissionClassify(t *testing.T) {
+	ac := newTestAdmissionController(t, 0)
+	ctx := context.WithValue(context.Background(), ContextKeyAuth, "partner") // nolint:staticcheck
+
+	require.Equal(t, "critical", ac.Classify(ctx, "eth_call", nil))
+	require.Equal(t, "bulk", ac.Classify(ctx, "eth_getLogs", nil))
+	require.Equal(t, "standard", ac.Classify(context.Background(), "eth_call", nil))
+	require.Equal(t, "standard", ac.Classify(ctx, "eth_getLogs", &RoutingRule{Priority: "standard"}))
+	require.Equal(t, "bulk", ac.Classify(ctx, "eth_getLogs", &RoutingRule{}))
+
+	var none *AdmissionController
+	require.Empty(t, none.Classify(ctx, "eth_call", nil))
+
+	_, err := NewAdmissionController(1, AdmissionConfig{DefaultClass: "missing"})
+	require.ErrorIs(t, err, errAdmissionDefaultClass)
+}
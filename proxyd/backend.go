@@ -11,6 +11,7 @@ import (
 	"math"
 	"math/rand"
 	"net/http"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -24,7 +25,8 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/xaionaro-go/weightedshuffle"
-	"golang.org/x/sync/semaphore"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 const (
@@ -111,8 +113,40 @@ var (
 		HTTPErrorCode: 500,
 	}
 
+	ErrRejectedByRule = &RPCErr{
+		Code:          JSONRPCErrorInternal - 22,
+		Message:       "request rejected",
+		HTTPErrorCode: 403,
+	}
+
+	ErrUnsupportedContentEncoding = &RPCErr{
+		Code:          JSONRPCErrorInternal - 23,
+		Message:       "unsupported content encoding",
+		HTTPErrorCode: 415,
+	}
+
+	ErrQuotaExceeded = &RPCErr{
+		Code:          JSONRPCErrorInternal - 24,
+		Message:       "quota exceeded",
+		HTTPErrorCode: 429,
+	}
+
+	ErrRetryBudgetExhausted = &RPCErr{
+		Code:          JSONRPCErrorInternal - 25,
+		Message:       "retry budget exhausted",
+		HTTPErrorCode: 503,
+	}
+
+	ErrRequestShed = &RPCErr{
+		Code:          JSONRPCErrorInternal - 26,
+		Message:       "server is overloaded, request shed",
+		HTTPErrorCode: 503,
+	}
+
 	ErrBackendUnexpectedJSONRPC = errors.New("backend returned an unexpected JSON-RPC response")
 
+	ErrBackendRetryableRPCError = errors.New("backend returned a retryable JSON-RPC error")
+
 	ErrConsensusGetReceiptsCantBeBatched = errors.New("consensus_getReceipts cannot be batched")
 	ErrConsensusGetReceiptsInvalidTarget = errors.New("unsupported consensus_receipts_target")
 )
@@ -163,6 +197,12 @@ type Backend struct {
 	networkErrorsSlidingWindow   *sw.AvgSlidingWindow
 
 	weight int
+
+	retryableErrors *RetryableErrorClassifier
+
+	concurrencyLimiter *ConcurrencyLimiter
+	rateLimiter        *BackendRateLimiter
+	costModel          *CostModel
 }
 
 type BackendOpt func(b *Backend)
@@ -273,6 +313,30 @@ func WithMaxErrorRateThreshold(maxErrorRateThreshold float64) BackendOpt {
 	}
 }
 
+func WithRetryableErrors(classifier *RetryableErrorClassifier) BackendOpt {
+	return func(b *Backend) {
+		b.retryableErrors = classifier
+	}
+}
+
+func WithConcurrencyLimiter(limiter *ConcurrencyLimiter) BackendOpt {
+	return func(b *Backend) {
+		b.concurrencyLimiter = limiter
+	}
+}
+
+func WithRateLimiter(limiter *BackendRateLimiter) BackendOpt {
+	return func(b *Backend) {
+		b.rateLimiter = limiter
+	}
+}
+
+func WithCostModel(costModel *CostModel) BackendOpt {
+	return func(b *Backend) {
+		b.costModel = costModel
+	}
+}
+
 func WithConsensusReceiptTarget(receiptsTarget string) BackendOpt {
 	return func(b *Backend) {
 		b.receiptsTarget = receiptsTarget
@@ -309,7 +373,7 @@ func NewBackend(
 	name string,
 	rpcURL string,
 	wsURL string,
-	rpcSemaphore *semaphore.Weighted,
+	rpcSemaphore RPCSemaphore,
 	opts ...BackendOpt,
 ) *Backend {
 	backend := &Backend{
@@ -349,10 +413,20 @@ func (b *Backend) Override(opts ...BackendOpt) {
 }
 
 func (b *Backend) Forward(ctx context.Context, reqs []*RPCReq, isBatch bool) ([]*RPCRes, error) {
+	// a backend at its concurrency limit is skipped rather than queued
+	if !b.concurrencyLimiter.Acquire() {
+		return nil, ErrBackendOverCapacity
+	}
+	defer b.concurrencyLimiter.Release()
+
 	var lastError error
 	// <= to account for the first attempt not technically being
 	// a retry
 	for i := 0; i <= b.maxRetries; i++ {
+		// every attempt counts towards the max_rps shared with the other proxyd instances
+		if !b.rateLimiter.Take(ctx) {
+			return nil, ErrBackendOverCapacity
+		}
 		RecordBatchRPCForward(ctx, b.Name, reqs, RPCRequestSourceHTTP)
 		metricLabelMethod := reqs[0].Method
 		if isBatch {
@@ -366,7 +440,19 @@ func (b *Backend) Forward(ctx context.Context, reqs []*RPCReq, isBatch bool) ([]
 			),
 		)
 
-		res, err := b.doForward(ctx, reqs, isBatch)
+		actx, span := startSpan(ctx, "Backend.doForward",
+			AttrBackend.String(b.Name),
+			AttrAttempt.Int(i),
+			AttrBatchSize.Int(len(reqs)),
+			methodAttr(reqs, isBatch),
+		)
+		start := time.Now()
+		res, err := b.doForward(actx, reqs, isBatch)
+		RecordForwardAttempt(ctx, time.Since(start))
+		if err != ErrRequestShed {
+			b.concurrencyLimiter.Observe(time.Since(start), isBackendFailure(err))
+		}
+		endSpan(span, err)
 		switch err {
 		case nil: // do nothing
 		case ErrBackendResponseTooLarge:
@@ -377,6 +463,19 @@ func (b *Backend) Forward(ctx context.Context, reqs []*RPCReq, isBatch bool) ([]
 				"max", b.maxResponseSize,
 			)
 			RecordBatchRPCError(ctx, b.Name, reqs, err)
+		case ErrRequestShed:
+			log.Warn(
+				"request shed by admission control",
+				"name", b.Name,
+				"req_id", GetReqID(ctx),
+				"priority_class", ctx.Value(ContextKeyPriorityClass),
+			)
+			RecordBatchRPCError(ctx, b.Name, reqs, err)
+			return nil, err
+		case ErrResponseStreamInterrupted:
+			// the response was partially written to the client, it can't be retried
+			RecordBatchRPCError(ctx, b.Name, reqs, err)
+			return nil, err
 		case ErrConsensusGetReceiptsCantBeBatched:
 			log.Warn(
 				"Received unsupported batch request for consensus_getReceipts",
@@ -404,26 +503,57 @@ func (b *Backend) Forward(ctx context.Context, reqs []*RPCReq, isBatch bool) ([]
 			)
 		default:
 			lastError = err
+			timer.ObserveDuration()
+			RecordBatchRPCError(ctx, b.Name, reqs, err)
+			if i < b.maxRetries && !allowRetry(ctx) {
+				log.Warn(
+					"backend request failed, retry budget exhausted",
+					"name", b.Name,
+					"req_id", GetReqID(ctx),
+					"err", err,
+				)
+				return nil, ErrRetryBudgetExhausted
+			}
 			log.Warn(
 				"backend request failed, trying again",
 				"name", b.Name,
 				"req_id", GetReqID(ctx),
 				"err", err,
 			)
-			timer.ObserveDuration()
-			RecordBatchRPCError(ctx, b.Name, reqs, err)
 			sleepContext(ctx, calcBackoff(i))
 			continue
 		}
 		timer.ObserveDuration()
 
 		MaybeRecordErrorsInRPCRes(ctx, b.Name, reqs, res)
+		if err == nil && b.hasRetryableError(reqs, res) {
+			// the responses are returned along with the error, to be served if no other backend can answer
+			return res, ErrBackendRetryableRPCError
+		}
 		return res, err
 	}
 
 	return nil, wrapErr(lastError, "permanent error forwarding request")
 }
 
+// hasRetryableError classifies the JSON-RPC errors of the responses, and returns true if any of them
+// is retryable on another backend
+func (b *Backend) hasRetryableError(reqs []*RPCReq, res []*RPCRes) bool {
+	if b.retryableErrors == nil {
+		return false
+	}
+	retryable := false
+	for i, r := range res {
+		if !r.IsError() {
+			continue
+		}
+		rule, ok := b.retryableErrors.Classify(r.Error)
+		RecordRPCErrorClassification(b.Name, reqs[i].Method, rule, ok)
+		retryable = retryable || ok
+	}
+	return retryable
+}
+
 func (b *Backend) ProxyWS(clientConn *websocket.Conn, methodWhitelist *StringSet) (*WSProxier, error) {
 	backendConn, _, err := b.dialer.Dial(b.wsURL, nil) // nolint:bodyclose
 	if err != nil {
@@ -448,7 +578,8 @@ func (b *Backend) ForwardRPC(ctx context.Context, res *RPCRes, id string, method
 		ID:      []byte(id),
 	}
 
-	slicedRes, err := b.doForward(ctx, []*RPCReq{&rpcReq}, false)
+	// the calls of the consensus poller are never queued or shed by the admission controller
+	slicedRes, err := b.doForward(withInternalRequest(ctx), []*RPCReq{&rpcReq}, false)
 	if err != nil {
 		return err
 	}
@@ -553,12 +684,19 @@ func (b *Backend) doForward(ctx context.Context, rpcReqs []*RPCReq, isBatch bool
 	httpReq.Header.Set("content-type", "application/json")
 	httpReq.Header.Set("X-Forwarded-For", xForwardedFor)
 
+	// propagate the trace context to the backend
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(httpReq.Header))
+
 	for name, value := range b.headers {
 		httpReq.Header.Set(name, value)
 	}
 
 	start := time.Now()
 	httpRes, err := b.client.DoLimited(httpReq)
+	if errors.Is(err, ErrRequestShed) {
+		// shed by admission control before reaching the backend
+		return nil, ErrRequestShed
+	}
 	if err != nil {
 		b.networkErrorsSlidingWindow.Incr()
 		RecordBackendNetworkErrorRateSlidingWindow(b, b.ErrorRate())
@@ -581,18 +719,33 @@ func (b *Backend) doForward(ctx context.Context, rpcReqs []*RPCReq, isBatch bool
 	if httpRes.StatusCode != 200 && httpRes.StatusCode != 400 {
 		b.networkErrorsSlidingWindow.Incr()
 		RecordBackendNetworkErrorRateSlidingWindow(b, b.ErrorRate())
-		return nil, fmt.Errorf("response code %d", httpRes.StatusCode)
+		return nil, &backendStatusError{code: httpRes.StatusCode}
 	}
+	// the provider bills the requests it answered, whether or not the response can be used
+	b.costModel.Charge(rpcReqs)
 
 	defer httpRes.Body.Close()
-	resB, err := io.ReadAll(LimitReader(httpRes.Body, b.maxResponseSize))
+	var resB []byte
+	if stream := streamFor(ctx, rpcReqs, isBatch); stream != nil && httpRes.StatusCode == 200 {
+		resB, err = stream.copy(ctx, httpRes, b.maxResponseSize)
+		if stream.Committed() {
+			if err != nil {
+				return nil, err
+			}
+			b.recordLatency(start)
+			// the result was written to the client already
+			return []*RPCRes{{JSONRPC: JSONRPCVersion, ID: rpcReqs[0].ID}}, nil
+		}
+	} else {
+		resB, err = io.ReadAll(LimitReader(httpRes.Body, b.maxResponseSize))
+	}
 	if errors.Is(err, ErrLimitReaderOverLimit) {
 		return nil, ErrBackendResponseTooLarge
 	}
 	if err != nil {
 		b.networkErrorsSlidingWindow.Incr()
 		RecordBackendNetworkErrorRateSlidingWindow(b, b.ErrorRate())
-		return nil, wrapErr(err, "error reading response body")
+		return nil, wrapErr(&backendTransportError{err: err}, "error reading response body")
 	}
 
 	var rpcRes []*RPCRes
@@ -631,10 +784,7 @@ func (b *Backend) doForward(ctx context.Context, rpcReqs []*RPCReq, isBatch bool
 			res.Error.HTTPErrorCode = httpRes.StatusCode
 		}
 	}
-	duration := time.Since(start)
-	b.latencySlidingWindow.Add(float64(duration))
-	RecordBackendNetworkLatencyAverageSlidingWindow(b, time.Duration(b.latencySlidingWindow.Avg()))
-	RecordBackendNetworkErrorRateSlidingWindow(b, b.ErrorRate())
+	b.recordLatency(start)
 
 	// enrich the response with the actual request method
 	for _, res := range rpcRes {
@@ -652,6 +802,13 @@ func (b *Backend) doForward(ctx context.Context, rpcReqs []*RPCReq, isBatch bool
 	return rpcRes, nil
 }
 
+func (b *Backend) recordLatency(start time.Time) {
+	duration := time.Since(start)
+	b.latencySlidingWindow.Add(float64(duration))
+	RecordBackendNetworkLatencyAverageSlidingWindow(b, time.Duration(b.latencySlidingWindow.Avg()))
+	RecordBackendNetworkErrorRateSlidingWindow(b, b.ErrorRate())
+}
+
 // IsHealthy checks if the backend is able to serve traffic, based on dynamic parameters
 func (b *Backend) IsHealthy() bool {
 	errorRate := b.ErrorRate()
@@ -710,9 +867,24 @@ type BackendGroup struct {
 	WeightedRouting  bool
 	Consensus        *ConsensusPoller
 	FallbackBackends map[string]bool
+
+	// backendsMux guards Backends and FallbackBackends, which change at runtime when discovery is enabled
+	backendsMux sync.RWMutex
+	discovery   *BackendDiscovery
+
+	retryBudget *RetryBudget
+}
+
+// GetBackends returns the current members of the group
+func (bg *BackendGroup) GetBackends() []*Backend {
+	bg.backendsMux.RLock()
+	defer bg.backendsMux.RUnlock()
+	return bg.Backends
 }
 
 func (bg *BackendGroup) Fallbacks() []*Backend {
+	bg.backendsMux.RLock()
+	defer bg.backendsMux.RUnlock()
 	fallbacks := []*Backend{}
 	for _, a := range bg.Backends {
 		if fallback, ok := bg.FallbackBackends[a.Name]; ok && fallback {
@@ -723,6 +895,8 @@ func (bg *BackendGroup) Fallbacks() []*Backend {
 }
 
 func (bg *BackendGroup) Primaries() []*Backend {
+	bg.backendsMux.RLock()
+	defer bg.backendsMux.RUnlock()
 	primaries := []*Backend{}
 	for _, a := range bg.Backends {
 		fallback, ok := bg.FallbackBackends[a.Name]
@@ -733,14 +907,88 @@ func (bg *BackendGroup) Primaries() []*Backend {
 	return primaries
 }
 
-// NOTE: BackendGroup Forward contains the log for balancing with consensus aware
+// IsFallback returns whether a member of the group is a fallback
+func (bg *BackendGroup) IsFallback(be *Backend) bool {
+	bg.backendsMux.RLock()
+	defer bg.backendsMux.RUnlock()
+	return bg.FallbackBackends[be.Name]
+}
+
+// AddBackend adds a backend to the group at runtime. It returns false if the group
+// already has a backend with the same name.
+func (bg *BackendGroup) AddBackend(be *Backend, fallback bool) bool {
+	bg.backendsMux.Lock()
+	defer bg.backendsMux.Unlock()
+	for _, existing := range bg.Backends {
+		if existing.Name == be.Name {
+			return false
+		}
+	}
+
+	// Backends is copied on write, so that callers of GetBackends can keep iterating the previous members
+	backends := make([]*Backend, 0, len(bg.Backends)+1)
+	backends = append(backends, bg.Backends...)
+	bg.Backends = append(backends, be)
+	fallbacks := make(map[string]bool, len(bg.FallbackBackends)+1)
+	for name, fb := range bg.FallbackBackends {
+		fallbacks[name] = fb
+	}
+	fallbacks[be.Name] = fallback
+	bg.FallbackBackends = fallbacks
+	return true
+}
+
+// RemoveBackend removes a backend from the group at runtime, and returns it.
+// Requests already forwarded to the backend are left to complete.
+func (bg *BackendGroup) RemoveBackend(name string) *Backend {
+	bg.backendsMux.Lock()
+	defer bg.backendsMux.Unlock()
+	var removed *Backend
+	backends := make([]*Backend, 0, len(bg.Backends))
+	for _, be := range bg.Backends {
+		if be.Name == name {
+			removed = be
+			continue
+		}
+		backends = append(backends, be)
+	}
+	if removed == nil {
+		return nil
+	}
+	bg.Backends = backends
+	fallbacks := make(map[string]bool, len(bg.FallbackBackends))
+	for n, fb := range bg.FallbackBackends {
+		if n != name {
+			fallbacks[n] = fb
+		}
+	}
+	bg.FallbackBackends = fallbacks
+	return removed
+}
+
 func (bg *BackendGroup) Forward(ctx context.Context, rpcReqs []*RPCReq, isBatch bool) ([]*RPCRes, string, error) {
 	if len(rpcReqs) == 0 {
 		return nil, "", nil
 	}
 
-	backends := bg.orderedBackendsForRequest()
+	ctx, span := startSpan(ctx, "BackendGroup.Forward",
+		AttrBackendGroup.String(bg.Name),
+		AttrBatchSize.Int(len(rpcReqs)),
+		methodAttr(rpcReqs, isBatch),
+	)
+	res, servedBy, err := bg.forward(ctx, rpcReqs, isBatch)
+	span.SetAttributes(AttrBackend.String(servedBy))
+	endSpan(span, err)
+	return res, servedBy, err
+}
+
+// NOTE: BackendGroup forward contains the log for balancing with consensus aware
+func (bg *BackendGroup) forward(ctx context.Context, rpcReqs []*RPCReq, isBatch bool) ([]*RPCRes, string, error) {
+
+	bg.retryBudget.Deposit()
+	ctx = withRetryBudget(ctx, bg.retryBudget)
 
+	var lagging []*Backend
 	overriddenResponses := make([]*indexedReqRes, 0)
 	rewrittenReqs := make([]*RPCReq, 0, len(rpcReqs))
 
@@ -749,6 +997,7 @@ func (bg *BackendGroup) Forward(ctx context.Context, rpcReqs []*RPCReq, isBatch
 		// serving traffic from any backend that agrees in the consensus group
 
 		// We also rewrite block tags to enforce compliance with consensus
+		_, rspan := startSpan(ctx, "BackendGroup.RewriteTags", AttrBackendGroup.String(bg.Name))
 		rctx := RewriteContext{
 			latest:        bg.Consensus.GetLatestBlockNumber(),
 			safe:          bg.Consensus.GetSafeBlockNumber(),
@@ -756,6 +1005,12 @@ func (bg *BackendGroup) Forward(ctx context.Context, rpcReqs []*RPCReq, isBatch
 			maxBlockRange: bg.Consensus.maxBlockRange,
 		}
 
+		// lagging backends can serve the call if they have the highest block it reads,
+		// the head of the chain is only served by the consensus group
+		if block, ok := requestsBlockNumber(rctx, rpcReqs); ok {
+			lagging = bg.Consensus.GetLaggingBackends(block)
+		}
+
 		for i, req := range rpcReqs {
 			res := RPCRes{JSONRPC: JSONRPCVersion, ID: req.ID}
 			result, err := RewriteTags(rctx, req, &res)
@@ -785,11 +1040,25 @@ func (bg *BackendGroup) Forward(ctx context.Context, rpcReqs []*RPCReq, isBatch
 				rewrittenReqs = append(rewrittenReqs, req)
 			}
 		}
+		rspan.SetAttributes(
+			AttrRewritten.Int(len(rewrittenReqs)),
+			AttrOverridden.Int(len(overriddenResponses)),
+		)
+		rspan.End()
 		rpcReqs = rewrittenReqs
 	}
 
+	backends := bg.orderedBackendsForRequest(lagging)
+
 	rpcRequestsTotal.Inc()
 
+	// the last retryable error responses are served if no backend can answer
+	var retryableRes []*RPCRes
+	var retryableServedBy string
+	// attempted is set once a backend was called, trying another backend is then a retry
+	attempted := false
+	budgetExhausted := false
+
 	for _, back := range backends {
 		res := make([]*RPCRes, 0)
 		var err error
@@ -797,6 +1066,11 @@ func (bg *BackendGroup) Forward(ctx context.Context, rpcReqs []*RPCReq, isBatch
 		servedBy := fmt.Sprintf("%s/%s", bg.Name, back.Name)
 
 		if len(rpcReqs) > 0 {
+			if attempted && !bg.retryBudget.Withdraw() {
+				budgetExhausted = true
+				break
+			}
+			getResponseStream(ctx).setServedBy(servedBy)
 			res, err = back.Forward(ctx, rpcReqs, isBatch)
 			if errors.Is(err, ErrConsensusGetReceiptsCantBeBatched) ||
 				errors.Is(err, ErrConsensusGetReceiptsInvalidTarget) ||
@@ -806,6 +1080,16 @@ func (bg *BackendGroup) Forward(ctx context.Context, rpcReqs []*RPCReq, isBatch
 			if errors.Is(err, ErrBackendResponseTooLarge) {
 				return nil, servedBy, err
 			}
+			if errors.Is(err, ErrRequestShed) {
+				return nil, "", err
+			}
+			if errors.Is(err, ErrResponseStreamInterrupted) {
+				return nil, servedBy, err
+			}
+			if errors.Is(err, ErrRetryBudgetExhausted) {
+				budgetExhausted = true
+				break
+			}
 			if errors.Is(err, ErrBackendOffline) {
 				log.Warn(
 					"skipping offline backend",
@@ -824,6 +1108,17 @@ func (bg *BackendGroup) Forward(ctx context.Context, rpcReqs []*RPCReq, isBatch
 				)
 				continue
 			}
+			attempted = true
+			if errors.Is(err, ErrBackendRetryableRPCError) {
+				log.Warn(
+					"backend returned a retryable error, trying the next backend",
+					"name", back.Name,
+					"auth", GetAuthCtx(ctx),
+					"req_id", GetReqID(ctx),
+				)
+				retryableRes, retryableServedBy = res, servedBy
+				continue
+			}
 			if err != nil {
 				log.Error(
 					"error forwarding request to backend",
@@ -836,25 +1131,45 @@ func (bg *BackendGroup) Forward(ctx context.Context, rpcReqs []*RPCReq, isBatch
 			}
 		}
 
-		// re-apply overridden responses
-		for _, ov := range overriddenResponses {
-			if len(res) > 0 {
-				// insert ov.res at position ov.index
-				res = append(res[:ov.index], append([]*RPCRes{ov.res}, res[ov.index:]...)...)
-			} else {
-				res = append(res, ov.res)
-			}
+		if len(rpcReqs) > 0 && slices.Contains(lagging, back) {
+			RecordConsensusLaggingRequest(bg, back)
 		}
 
-		return res, servedBy, nil
+		return applyOverriddenResponses(res, overriddenResponses), servedBy, nil
+	}
+
+	if retryableRes != nil {
+		return applyOverriddenResponses(retryableRes, overriddenResponses), retryableServedBy, nil
+	}
+	if budgetExhausted {
+		log.Warn(
+			"retry budget exhausted, failing the request",
+			"backend_group", bg.Name,
+			"auth", GetAuthCtx(ctx),
+			"req_id", GetReqID(ctx),
+		)
+		return nil, "", ErrRetryBudgetExhausted
 	}
 
 	RecordUnserviceableRequest(ctx, RPCRequestSourceHTTP)
 	return nil, "", ErrNoBackends
 }
 
+// applyOverriddenResponses re-applies the responses overridden by the rewrite at their position in the batch
+func applyOverriddenResponses(res []*RPCRes, overriddenResponses []*indexedReqRes) []*RPCRes {
+	for _, ov := range overriddenResponses {
+		if len(res) > 0 {
+			// insert ov.res at position ov.index
+			res = append(res[:ov.index], append([]*RPCRes{ov.res}, res[ov.index:]...)...)
+		} else {
+			res = append(res, ov.res)
+		}
+	}
+	return res
+}
+
 func (bg *BackendGroup) ProxyWS(ctx context.Context, clientConn *websocket.Conn, methodWhitelist *StringSet) (*WSProxier, error) {
-	for _, back := range bg.Backends {
+	for _, back := range bg.GetBackends() {
 		proxier, err := back.ProxyWS(clientConn, methodWhitelist)
 		if errors.Is(err, ErrBackendOffline) {
 			log.Warn(
@@ -898,21 +1213,24 @@ func weightedShuffle(backends []*Backend) {
 	weightedshuffle.ShuffleInplace(backends, weight, nil)
 }
 
-func (bg *BackendGroup) orderedBackendsForRequest() []*Backend {
+func (bg *BackendGroup) orderedBackendsForRequest(lagging []*Backend) []*Backend {
 	if bg.Consensus != nil {
-		return bg.loadBalancedConsensusGroup()
+		return orderByCost(bg.loadBalancedConsensusGroup(lagging))
 	} else if bg.WeightedRouting {
-		result := make([]*Backend, len(bg.Backends))
-		copy(result, bg.Backends)
+		backends := bg.GetBackends()
+		result := make([]*Backend, len(backends))
+		copy(result, backends)
 		weightedShuffle(result)
-		return result
+		return orderByCost(result)
 	} else {
-		return bg.Backends
+		return orderByCost(bg.GetBackends())
 	}
 }
 
-func (bg *BackendGroup) loadBalancedConsensusGroup() []*Backend {
-	cg := bg.Consensus.GetConsensusGroup()
+// loadBalancedConsensusGroup balances the consensus group along with the lagging backends
+// able to serve the request
+func (bg *BackendGroup) loadBalancedConsensusGroup(lagging []*Backend) []*Backend {
+	cg := append(bg.Consensus.GetConsensusGroup(), lagging...)
 
 	backendsHealthy := make([]*Backend, 0, len(cg))
 	backendsDegraded := make([]*Backend, 0, len(cg))
@@ -950,6 +1268,9 @@ func (bg *BackendGroup) loadBalancedConsensusGroup() []*Backend {
 }
 
 func (bg *BackendGroup) Shutdown() {
+	if bg.discovery != nil {
+		bg.discovery.Shutdown()
+	}
 	if bg.Consensus != nil {
 		bg.Consensus.Shutdown()
 	}
@@ -968,6 +1289,7 @@ type WSProxier struct {
 	backendConn     *websocket.Conn
 	backendConnMu   sync.Mutex
 	methodWhitelist *StringSet
+	validator       *RequestValidator
 	readTimeout     time.Duration
 	writeTimeout    time.Duration
 }
@@ -1021,7 +1343,7 @@ func (w *WSProxier) clientPump(ctx context.Context, errC chan error) {
 
 		// Don't bother sending invalid requests to the backend,
 		// just handle them here.
-		req, err := w.prepareClientMsg(msg)
+		req, msg, err := w.prepareClientMsg(msg)
 		if err != nil {
 			var id json.RawMessage
 			method := MethodUnknown
@@ -1141,17 +1463,27 @@ func (w *WSProxier) close() {
 	activeBackendWsConnsGauge.WithLabelValues(w.backend.Name).Dec()
 }
 
-func (w *WSProxier) prepareClientMsg(msg []byte) (*RPCReq, error) {
+// prepareClientMsg returns the parsed request and the message to forward to the backend
+func (w *WSProxier) prepareClientMsg(msg []byte) (*RPCReq, []byte, error) {
 	req, err := ParseRPCReq(msg)
 	if err != nil {
-		return nil, err
+		return nil, msg, err
 	}
 
 	if !w.methodWhitelist.Has(req.Method) {
-		return req, ErrMethodNotWhitelisted
+		return req, msg, ErrMethodNotWhitelisted
+	}
+
+	params := req.Params
+	if err := w.validator.Validate(req); err != nil {
+		return req, msg, err
+	}
+	// the validator may have set the gas of the call
+	if !bytes.Equal(params, req.Params) {
+		msg = mustMarshalJSON(req)
 	}
 
-	return req, nil
+	return req, msg, nil
 }
 
 func (w *WSProxier) parseBackendMsg(msg []byte) (*RPCRes, error) {
@@ -1212,17 +1544,23 @@ func sleepContext(ctx context.Context, duration time.Duration) {
 
 type LimitedHTTPClient struct {
 	http.Client
-	sem         *semaphore.Weighted
+	sem         RPCSemaphore
 	backendName string
 }
 
 func (c *LimitedHTTPClient) DoLimited(req *http.Request) (*http.Response, error) {
-	if err := c.sem.Acquire(req.Context(), 1); err != nil {
-		tooManyRequestErrorsTotal.WithLabelValues(c.backendName).Inc()
-		return nil, wrapErr(err, "too many requests")
+	if !isMirrorRequest(req.Context()) {
+		if err := c.sem.Acquire(req.Context(), 1); err != nil {
+			tooManyRequestErrorsTotal.WithLabelValues(c.backendName).Inc()
+			return nil, wrapErr(err, "too many requests")
+		}
+		defer c.sem.Release(1)
+	}
+	res, err := c.Do(req)
+	if err != nil {
+		return nil, &backendTransportError{err: err}
 	}
-	defer c.sem.Release(1)
-	return c.Do(req)
+	return res, nil
 }
 
 func RecordBatchRPCError(ctx context.Context, backendName string, reqs []*RPCReq, err error) {
@@ -0,0 +1,175 @@
+package proxyd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/redis/go-redis/v9"
+)
+
+const DefaultSharedRateLimitBatchSize = 10
+
+// SharedRateLimits creates the rate limiters sharing the max_rps of the backends across the proxyd fleet
+type SharedRateLimits struct {
+	rdb       redis.UniversalClient
+	prefix    string
+	batchSize int
+}
+
+// NewSharedRateLimits returns nil if the shared rate limits are disabled
+func NewSharedRateLimits(rdb redis.UniversalClient, namespace string, cfg SharedRateLimitConfig) *SharedRateLimits {
+	if !cfg.Enabled {
+		return nil
+	}
+	prefix := "backend_rate_limit"
+	if namespace != "" {
+		prefix = namespace + ":backend_rate_limit"
+	}
+	batchSize := cfg.BatchSize
+	if batchSize == 0 {
+		batchSize = DefaultSharedRateLimitBatchSize
+	}
+	return &SharedRateLimits{
+		rdb:       rdb,
+		prefix:    prefix,
+		batchSize: batchSize,
+	}
+}
+
+// Limiter returns the rate limiter of a backend, or nil if the backend has no max_rps
+func (s *SharedRateLimits) Limiter(backend string, maxRPS int) *BackendRateLimiter {
+	if s == nil || maxRPS <= 0 {
+		return nil
+	}
+	return &BackendRateLimiter{
+		rdb:       s.rdb,
+		prefix:    s.prefix,
+		backend:   backend,
+		maxRPS:    maxRPS,
+		batchSize: min(s.batchSize, maxRPS),
+		now:       time.Now,
+	}
+}
+
+// BackendRateLimiter limits the requests to a backend to max_rps across the proxyd fleet.
+// Every instance counts its requests in the same Redis key for each second. To save a Redis round trip
+// per request, the tokens are reserved from the shared window in batches, and served locally until
+// the batch is used up or the second ends. A single reservation is in flight at a time, the requests
+// running out of tokens meanwhile wait for it. If Redis is unavailable, requests are let through.
+// A nil limiter doesn't limit the requests
+type BackendRateLimiter struct {
+	rdb       redis.UniversalClient
+	prefix    string
+	backend   string
+	maxRPS    int
+	batchSize int
+	now       func() time.Time
+
+	mtx       sync.Mutex
+	window    int64
+	tokens    int
+	exhausted bool
+	// refill is the reservation in flight, if any
+	refill *rateLimitRefill
+}
+
+type rateLimitRefill struct {
+	window int64
+	// done is closed once the reserved tokens are added
+	done chan struct{}
+}
+
+// Take consumes a token, and returns false if the backend is over its shared max_rps,
+// or if the context is done while waiting for a reservation
+func (l *BackendRateLimiter) Take(ctx context.Context) bool {
+	if l == nil {
+		return true
+	}
+	for {
+		l.mtx.Lock()
+		window := l.now().Unix()
+		if window != l.window {
+			l.window = window
+			l.tokens = 0
+			l.exhausted = false
+		}
+		if l.tokens > 0 {
+			l.tokens--
+			l.mtx.Unlock()
+			return true
+		}
+		if l.exhausted {
+			l.mtx.Unlock()
+			RecordBackendRateLimited(l.backend)
+			return false
+		}
+
+		refill := l.refill
+		if refill == nil {
+			refill = &rateLimitRefill{window: window, done: make(chan struct{})}
+			l.refill = refill
+			l.mtx.Unlock()
+			if !l.reserve(ctx, refill) {
+				return false
+			}
+			continue
+		}
+		l.mtx.Unlock()
+
+		select {
+		case <-refill.done:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// reserve takes a batch of tokens from the shared window, without holding the lock during the
+// Redis round trip. It returns false if the reservation was aborted by the context
+func (l *BackendRateLimiter) reserve(ctx context.Context, refill *rateLimitRefill) bool {
+	key := l.key(refill.window)
+	var incr *redis.IntCmd
+	_, err := l.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.IncrBy(ctx, key, int64(l.batchSize))
+		pipe.PExpire(ctx, key, 2*time.Second)
+		return nil
+	})
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.refill = nil
+	defer close(refill.done)
+
+	if err != nil && ctx.Err() != nil {
+		// the request went away, it isn't a Redis failure to fail open on.
+		// The waiting requests reserve their tokens with another refill
+		return false
+	}
+	if refill.window != l.window {
+		// the tokens of a past second can't be served anymore
+		return true
+	}
+	if err != nil {
+		// fail open rather than making Redis a single point of failure
+		log.Warn("error reserving shared backend rate limit", "backend", l.backend, "err", err)
+		RecordRedisError("BackendRateLimitReserve")
+		l.tokens = l.batchSize
+		return true
+	}
+
+	before := incr.Val() - int64(l.batchSize)
+	granted := min(int64(l.batchSize), int64(l.maxRPS)-before)
+	if granted < int64(l.batchSize) {
+		l.exhausted = true
+	}
+	l.tokens = int(max(granted, 0))
+	return true
+}
+
+// key hash tags the backend, so that its windows share a Redis Cluster slot
+func (l *BackendRateLimiter) key(window int64) string {
+	return fmt.Sprintf("%s:{%s}:%d", l.prefix, l.backend, window)
+}
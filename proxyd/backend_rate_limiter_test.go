@@ -0,0 +1,165 @@
+package proxyd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackendRateLimiter(t *testing.T) {
+	redisServer, err := miniredis.Run()
+	require.NoError(t, err)
+	defer redisServer.Close()
+
+	redisClient := redis.NewClient(&redis.Options{
+		Addr: fmt.Sprintf("127.0.0.1:%s", redisServer.Port()),
+	})
+
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	clock := func() time.Time { return now }
+
+	shared := NewSharedRateLimits(redisClient, "proxyd", SharedRateLimitConfig{Enabled: true})
+	require.Nil(t, shared.Limiter("node", 0))
+
+	// two proxyd instances share the max_rps of the backend
+	a := shared.Limiter("node", 25)
+	b := shared.Limiter("node", 25)
+	a.now, b.now = clock, clock
+
+	take := func(l *BackendRateLimiter, n int) int {
+		taken := 0
+		for i := 0; i < n; i++ {
+			if l.Take(ctx) {
+				taken++
+			}
+		}
+		return taken
+	}
+	require.Equal(t, 10, take(a, 10))
+	require.Equal(t, 10, take(b, 10))
+	require.Equal(t, 5, take(a, 10))
+	require.Equal(t, 0, take(b, 10))
+
+	// tokens are reserved in batches
+	val, err := redisServer.Get(a.key(now.Unix()))
+	require.NoError(t, err)
+	require.Equal(t, "40", val)
+	require.Equal(t, "proxyd:backend_rate_limit:{node}:1700000000", a.key(now.Unix()))
+
+	// the budget is renewed every second
+	now = now.Add(time.Second)
+	require.Equal(t, 10, take(b, 10))
+
+	// requests are let through when Redis is down
+	redisServer.Close()
+	now = now.Add(time.Second)
+	require.Equal(t, 30, take(a, 30))
+
+	var none *BackendRateLimiter
+	require.True(t, none.Take(ctx))
+	require.Nil(t, NewSharedRateLimits(redisClient, "", SharedRateLimitConfig{}))
+}
+
+// blockingPipelineHook holds the pipelines until released, or until their context is done
+type blockingPipelineHook struct {
+	pipelines atomic.Int64
+	release   chan struct{}
+}
+
+func (h *blockingPipelineHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (h *blockingPipelineHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return next
+}
+
+func (h *blockingPipelineHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		h.pipelines.Add(1)
+		select {
+		case <-h.release:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		return next(ctx, cmds)
+	}
+}
+
+func TestBackendRateLimiterRefill(t *testing.T) {
+	redisServer, err := miniredis.Run()
+	require.NoError(t, err)
+	defer redisServer.Close()
+
+	redisClient := redis.NewClient(&redis.Options{
+		Addr: fmt.Sprintf("127.0.0.1:%s", redisServer.Port()),
+	})
+	hook := &blockingPipelineHook{release: make(chan struct{})}
+	redisClient.AddHook(hook)
+
+	shared := NewSharedRateLimits(redisClient, "proxyd", SharedRateLimitConfig{Enabled: true})
+	l := shared.Limiter("node", 100)
+	now := time.Unix(1700000000, 0)
+	l.now = func() time.Time { return now }
+
+	// a single reservation is in flight for the concurrent requests
+	ctx := context.Background()
+	var taken atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if l.Take(ctx) {
+				taken.Add(1)
+			}
+		}()
+	}
+	require.Eventually(t, func() bool { return hook.pipelines.Load() == 1 }, time.Second, time.Millisecond)
+
+	// the lock isn't held during the round trip, waiters give up with their context
+	tctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	require.False(t, l.Take(tctx))
+
+	close(hook.release)
+	wg.Wait()
+	require.Equal(t, int64(5), taken.Load())
+	require.Equal(t, int64(1), hook.pipelines.Load())
+	require.Equal(t, 5, l.tokens)
+
+	// a reservation aborted by its request doesn't grant the tokens of a Redis failure
+	now = now.Add(time.Second)
+	hook.release = make(chan struct{})
+	cctx, cancelReservation := context.WithCancel(ctx)
+	aborted := make(chan bool)
+	go func() {
+		aborted <- l.Take(cctx)
+	}()
+	require.Eventually(t, func() bool { return hook.pipelines.Load() == 2 }, time.Second, time.Millisecond)
+	cancelReservation()
+	require.False(t, <-aborted)
+	l.mtx.Lock()
+	require.Zero(t, l.tokens)
+	require.Nil(t, l.refill)
+	l.mtx.Unlock()
+	val, err := redisServer.Get(l.key(now.Unix()))
+	require.Error(t, err)
+	require.Empty(t, val)
+
+	// the next request reserves the tokens
+	close(hook.release)
+	require.True(t, l.Take(ctx))
+	require.Equal(t, 9, l.tokens)
+}
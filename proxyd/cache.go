@@ -45,12 +45,12 @@ func (c *cache) Put(ctx context.Context, key string, value string) error {
 }
 
 type redisCache struct {
-	rdb    *redis.Client
+	rdb    redis.UniversalClient
 	prefix string
 	ttl    time.Duration
 }
 
-func newRedisCache(rdb *redis.Client, prefix string, ttl time.Duration) *redisCache {
+func newRedisCache(rdb redis.UniversalClient, prefix string, ttl time.Duration) *redisCache {
 	return &redisCache{rdb, prefix, ttl}
 }
 
@@ -117,6 +117,7 @@ func (c *cacheWithCompression) Put(ctx context.Context, key string, value string
 type RPCCache interface {
 	GetRPC(ctx context.Context, req *RPCReq) (*RPCRes, error)
 	PutRPC(ctx context.Context, req *RPCReq, res *RPCRes) error
+	IsCacheable(method string) bool
 }
 
 type rpcCache struct {
@@ -170,7 +171,10 @@ func (c *rpcCache) GetRPC(ctx context.Context, req *RPCReq) (*RPCRes, error) {
 	if handler == nil {
 		return nil, nil
 	}
+	ctx, span := startSpan(ctx, "RPCCache.GetRPC", AttrMethod.String(req.Method))
 	res, err := handler.GetRPCMethod(ctx, req)
+	span.SetAttributes(AttrCacheHit.Bool(res != nil))
+	endSpan(span, err)
 	if err != nil {
 		RecordCacheError(req.Method)
 		return nil, err
@@ -183,6 +187,10 @@ func (c *rpcCache) GetRPC(ctx context.Context, req *RPCReq) (*RPCRes, error) {
 	return res, nil
 }
 
+func (c *rpcCache) IsCacheable(method string) bool {
+	return c.handlers[method] != nil
+}
+
 func (c *rpcCache) PutRPC(ctx context.Context, req *RPCReq, res *RPCRes) error {
 	handler := c.handlers[req.Method]
 	if handler == nil {
@@ -0,0 +1,192 @@
+package proxyd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+var defaultCaptureRedactHeaders = []string{"Authorization", "Cookie", "X-Api-Key"}
+
+// CapturedRequest is a single HTTP request recorded by the traffic capture,
+// written as one JSON line in the capture file
+type CapturedRequest struct {
+	Timestamp  time.Time         `json:"timestamp"`
+	ReqID      string            `json:"req_id"`
+	Auth       string            `json:"auth"`
+	Headers    map[string]string `json:"headers"`
+	Body       string            `json:"body"`
+	StatusCode int               `json:"status_code"`
+	Response   string            `json:"response,omitempty"`
+	DurationMs float64           `json:"duration_ms"`
+}
+
+// TrafficCapture records sampled requests so they can be replayed with tools/replay
+type TrafficCapture struct {
+	mtx              sync.Mutex
+	w                *rotatingFile
+	sampleRate       float64
+	redactHeaders    map[string]bool
+	includeResponses bool
+}
+
+func NewTrafficCapture(cfg CaptureConfig) (*TrafficCapture, error) {
+	if cfg.Output == "" {
+		return nil, errors.New("capture.output must be set")
+	}
+	if cfg.SampleRate < 0 || cfg.SampleRate > 1 {
+		return nil, errors.New("capture.sample_rate must be between 0 and 1")
+	}
+
+	f, err := newRotatingFile(cfg.Output, int64(cfg.MaxSizeMB)*1024*1024, cfg.MaxBackups)
+	if err != nil {
+		return nil, err
+	}
+
+	sampleRate := cfg.SampleRate
+	if sampleRate == 0 {
+		sampleRate = 1
+	}
+
+	redactHeaders := cfg.RedactHeaders
+	if redactHeaders == nil {
+		redactHeaders = defaultCaptureRedactHeaders
+	}
+	redact := make(map[string]bool, len(redactHeaders))
+	for _, h := range redactHeaders {
+		redact[http.CanonicalHeaderKey(h)] = true
+	}
+
+	return &TrafficCapture{
+		w:                f,
+		sampleRate:       sampleRate,
+		redactHeaders:    redact,
+		includeResponses: cfg.IncludeResponses,
+	}, nil
+}
+
+// start begins the capture of a request, it returns nil if the request is not sampled
+func (c *TrafficCapture) start(r *http.Request, reqID string, auth string) *activeCapture {
+	if c == nil {
+		return nil
+	}
+	if c.sampleRate < 1 && rand.Float64() >= c.sampleRate {
+		return nil
+	}
+
+	headers := make(map[string]string, len(r.Header))
+	for name, values := range r.Header {
+		if c.redactHeaders[name] {
+			headers[name] = redactedValue
+			continue
+		}
+		headers[name] = strings.Join(values, ", ")
+	}
+
+	return &activeCapture{
+		start:           time.Now(),
+		includeResponse: c.includeResponses,
+		req: &CapturedRequest{
+			ReqID:   reqID,
+			Auth:    auth,
+			Headers: headers,
+		},
+	}
+}
+
+// finish writes the captured request, requests whose body was never read are dropped
+func (c *TrafficCapture) finish(ac *activeCapture) {
+	if c == nil || ac == nil || ac.req.Body == "" {
+		return
+	}
+
+	ac.req.Timestamp = ac.start.UTC()
+	ac.req.DurationMs = durationToMs(time.Since(ac.start))
+	ac.req.StatusCode = http.StatusOK
+	if ac.w != nil {
+		if ac.w.statusCode != 0 {
+			ac.req.StatusCode = ac.w.statusCode
+		}
+		if ac.includeResponse {
+			response := ac.w.buf.Bytes()
+			if encoding := ac.w.Header().Get("Content-Encoding"); encoding != "" {
+				decoded, err := decompress(encoding, response)
+				if err != nil {
+					log.Warn("error decompressing captured response", "req_id", ac.req.ReqID, "err", err)
+				}
+				response = decoded
+			}
+			ac.req.Response = strings.TrimSpace(string(response))
+		}
+	}
+
+	line, err := json.Marshal(ac.req)
+	if err != nil {
+		log.Error("error marshalling captured request", "req_id", ac.req.ReqID, "err", err)
+		return
+	}
+	line = append(line, '\n')
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if _, err := c.w.Write(line); err != nil {
+		log.Error("error writing captured request", "req_id", ac.req.ReqID, "err", err)
+	}
+}
+
+func (c *TrafficCapture) Close() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.w.Close()
+}
+
+type activeCapture struct {
+	start           time.Time
+	includeResponse bool
+	req             *CapturedRequest
+	w               *captureWriter
+}
+
+// Wrap returns a response writer that records the status code and, if enabled, the response body
+func (ac *activeCapture) Wrap(w http.ResponseWriter) http.ResponseWriter {
+	if ac == nil {
+		return w
+	}
+	ac.w = &captureWriter{ResponseWriter: w, recordBody: ac.includeResponse}
+	return ac.w
+}
+
+func (ac *activeCapture) SetBody(body []byte) {
+	if ac == nil {
+		return
+	}
+	ac.req.Body = string(body)
+}
+
+type captureWriter struct {
+	http.ResponseWriter
+	statusCode int
+	recordBody bool
+	buf        bytes.Buffer
+}
+
+func (w *captureWriter) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *captureWriter) Write(p []byte) (int, error) {
+	if w.recordBody {
+		w.buf.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
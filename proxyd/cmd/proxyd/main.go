@@ -30,16 +30,28 @@ func main() {
 	// Otherwise the final critical log won't show what the parsing error was.
 	proxyd.SetLogLevel(slog.LevelInfo)
 
+	if len(os.Args) == 3 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(validate(os.Args[2]))
+		case "print-config":
+			os.Exit(printConfig(os.Args[2]))
+		}
+	}
+
 	log.Info("starting proxyd", "version", GitVersion, "commit", GitCommit, "date", GitDate)
 
 	if len(os.Args) < 2 {
 		log.Crit("must specify a config file on the command line")
 	}
 
-	config := new(proxyd.Config)
-	if _, err := toml.DecodeFile(os.Args[1], config); err != nil {
+	config, md, err := proxyd.LoadConfig(os.Args[1])
+	if err != nil {
 		log.Crit("error reading config file", "err", err)
 	}
+	for _, key := range proxyd.UnknownKeys(md) {
+		log.Warn("unknown config key, it will be ignored", "key", key)
+	}
 
 	// update log level from config
 	logLevel, err := LevelFromString(config.Server.LogLevel)
@@ -74,6 +86,44 @@ func main() {
 	shutdown()
 }
 
+// validate runs all the offline config checks, and returns the exit code of the command
+func validate(path string) int {
+	config, md, err := proxyd.LoadConfig(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading config file: %v\n", err)
+		return 1
+	}
+	errs := proxyd.ValidateConfig(config, md)
+	if len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		}
+		fmt.Fprintf(os.Stderr, "%d config errors found\n", len(errs))
+		return 1
+	}
+	fmt.Printf("%s: config is valid\n", path)
+	return 0
+}
+
+// printConfig prints the effective config, with defaults applied and secrets redacted
+func printConfig(path string) int {
+	config, _, err := proxyd.LoadConfig(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading config file: %v\n", err)
+		return 1
+	}
+	effective, err := proxyd.EffectiveConfig(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error computing effective config: %v\n", err)
+		return 1
+	}
+	if err := toml.NewEncoder(os.Stdout).Encode(effective); err != nil {
+		fmt.Fprintf(os.Stderr, "error printing config: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
 // LevelFromString returns the appropriate Level from a string name.
 // Useful for parsing command line args and configuration files.
 // It also converts strings to lowercase.
@@ -0,0 +1,186 @@
+package proxyd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	ContextKeyResponseEncoding = "response_encoding"
+
+	EncodingGzip = "gzip"
+	EncodingZstd = "zstd"
+
+	CompressionDirectionRequest  = "request"
+	CompressionDirectionResponse = "response"
+
+	defaultCompressionMinSize = 1024
+)
+
+var (
+	gzipWriterPool = sync.Pool{
+		New: func() interface{} {
+			return gzip.NewWriter(nil)
+		},
+	}
+
+	// zstd encoders are safe for concurrent use with EncodeAll
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+)
+
+// responseEncoding is the content encoding negotiated with the client
+type responseEncoding struct {
+	encoding string
+	minSize  int
+}
+
+func withResponseEncoding(ctx context.Context, encoding string, minSize int) context.Context {
+	if encoding == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, ContextKeyResponseEncoding, &responseEncoding{ // nolint:staticcheck
+		encoding: encoding,
+		minSize:  minSize,
+	})
+}
+
+func getResponseEncoding(ctx context.Context) *responseEncoding {
+	enc, ok := ctx.Value(ContextKeyResponseEncoding).(*responseEncoding)
+	if !ok {
+		return nil
+	}
+	return enc
+}
+
+// negotiateEncoding picks the encoding of the response from the Accept-Encoding header.
+// zstd is preferred over gzip when the client accepts both with the same quality.
+// "*" only applies to the encodings the header doesn't name, gzip first.
+func negotiateEncoding(acceptEncoding string) string {
+	qualities := make(map[string]float64, 2)
+	wildcard, hasWildcard := 0.0, false
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "*" && name != EncodingGzip && name != EncodingZstd {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if name == "*" {
+			wildcard, hasWildcard = q, true
+		} else {
+			qualities[name] = q
+		}
+	}
+	if hasWildcard {
+		for _, name := range []string{EncodingGzip, EncodingZstd} {
+			if _, ok := qualities[name]; !ok {
+				qualities[name] = wildcard
+				break
+			}
+		}
+	}
+
+	best := ""
+	bestQ := 0.0
+	for _, name := range []string{EncodingGzip, EncodingZstd} {
+		q := qualities[name]
+		if q <= 0 {
+			continue
+		}
+		if q > bestQ || (q == bestQ && name == EncodingZstd) {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+func compress(encoding string, data []byte) ([]byte, error) {
+	switch encoding {
+	case EncodingGzip:
+		var buf bytes.Buffer
+		gw := gzipWriterPool.Get().(*gzip.Writer)
+		defer gzipWriterPool.Put(gw)
+		gw.Reset(&buf)
+		if _, err := gw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := gw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case EncodingZstd:
+		return zstdEncoder.EncodeAll(data, make([]byte, 0, len(data)/4)), nil
+	default:
+		return nil, fmt.Errorf("unsupported encoding: %s", encoding)
+	}
+}
+
+// newCompressWriter returns a writer compressing to w with the encoding, for responses written as they are read
+func newCompressWriter(encoding string, w io.Writer) (io.WriteCloser, error) {
+	switch encoding {
+	case EncodingGzip:
+		gw := gzipWriterPool.Get().(*gzip.Writer)
+		gw.Reset(w)
+		return &pooledGzipWriter{gw}, nil
+	case EncodingZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	default:
+		return nil, fmt.Errorf("unsupported encoding: %s", encoding)
+	}
+}
+
+// pooledGzipWriter returns the gzip writer to its pool once closed
+type pooledGzipWriter struct {
+	*gzip.Writer
+}
+
+func (w *pooledGzipWriter) Close() error {
+	err := w.Writer.Close()
+	gzipWriterPool.Put(w.Writer)
+	return err
+}
+
+func decompress(encoding string, data []byte) ([]byte, error) {
+	switch encoding {
+	case EncodingGzip:
+		gr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		return io.ReadAll(gr)
+	case EncodingZstd:
+		return zstdDecoder.DecodeAll(data, nil)
+	default:
+		return nil, fmt.Errorf("unsupported encoding: %s", encoding)
+	}
+}
+
+// countingReader counts the bytes read from the underlying reader
+type countingReader struct {
+	io.Reader
+	N int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.N += n
+	return n, err
+}
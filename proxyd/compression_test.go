@@ -0,0 +1,48 @@
+package proxyd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		expected       string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"br", ""},
+		{"gzip", EncodingGzip},
+		{"GZIP", EncodingGzip},
+		{"deflate, gzip;q=0.5", EncodingGzip},
+		{"gzip, zstd", EncodingZstd},
+		{"zstd;q=0.5, gzip", EncodingGzip},
+		{"zstd;q=0, gzip;q=0", ""},
+		{"gzip;q=invalid", ""},
+		{"*", EncodingGzip},
+		{"*;q=0", ""},
+		{"gzip;q=0, *", EncodingZstd},
+		{"gzip;q=0, zstd;q=0, *", ""},
+		{"zstd;q=0, *", EncodingGzip},
+		{"gzip;q=0.5, *", EncodingZstd},
+		{"zstd, *;q=0", EncodingZstd},
+	}
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			require.Equal(t, tt.expected, negotiateEncoding(tt.acceptEncoding))
+		})
+	}
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	data := []byte(`{"jsonrpc":"2.0","result":"0x0000000000000000000000000000000000000000","id":1}`)
+	for _, encoding := range []string{EncodingGzip, EncodingZstd} {
+		compressed, err := compress(encoding, data)
+		require.NoError(t, err)
+		decompressed, err := decompress(encoding, compressed)
+		require.NoError(t, err)
+		require.Equal(t, data, decompressed)
+	}
+}
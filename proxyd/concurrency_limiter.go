@@ -0,0 +1,196 @@
+package proxyd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	ConcurrencyLimitAIMD     = "aimd"
+	ConcurrencyLimitGradient = "gradient"
+
+	DefaultConcurrencyInitialLimit = 20
+	DefaultConcurrencyMinLimit     = 1
+	DefaultConcurrencyMaxLimit     = 1000
+	DefaultConcurrencyTolerance    = 2.0
+	DefaultConcurrencyBackoffRatio = 0.9
+
+	// concurrencyBaselineDrift is how fast the baseline latency follows slower requests,
+	// so that it adapts to a backend becoming durably slower
+	concurrencyBaselineDrift = 0.01
+	// concurrencyGradientSmoothing weighs the new limit computed by the gradient algorithm
+	concurrencyGradientSmoothing = 0.2
+)
+
+// ConcurrencyLimiter adapts the number of requests in flight to a backend to its latency and errors.
+// The limit grows while the latency stays within tolerance of its baseline, i.e. of the latency of
+// the backend when it isn't loaded, and is cut when the latency rises or requests fail:
+//   - aimd adds 1 to the limit for every limit successful requests, and multiplies it by
+//     backoff_ratio on a slow or failed request
+//   - gradient moves the limit towards limit * tolerance * baseline / latency, plus a queue of
+//     sqrt(limit) requests to probe for more capacity
+//
+// A nil limiter doesn't limit the requests
+type ConcurrencyLimiter struct {
+	backend      string
+	algorithm    string
+	minLimit     float64
+	maxLimit     float64
+	tolerance    float64
+	backoffRatio float64
+
+	mtx      sync.Mutex
+	limit    float64
+	inFlight int
+	baseline time.Duration
+}
+
+var (
+	errConcurrencyLimitAlgorithm = errors.New("concurrency_limit algorithm must be aimd or gradient")
+	errConcurrencyLimitBounds    = errors.New("concurrency_limit must have 0 < min_limit <= initial_limit <= max_limit")
+	errConcurrencyLimitTolerance = errors.New("concurrency_limit tolerance must be at least 1")
+	errConcurrencyLimitBackoff   = errors.New("concurrency_limit backoff_ratio must be between 0 and 1")
+)
+
+// NewConcurrencyLimiter creates a limiter starting at its initial limit, or returns nil if the config is nil
+func NewConcurrencyLimiter(backend string, cfg *ConcurrencyLimitConfig) (*ConcurrencyLimiter, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	l := &ConcurrencyLimiter{
+		backend:      backend,
+		algorithm:    cfg.Algorithm,
+		minLimit:     float64(cfg.MinLimit),
+		maxLimit:     float64(cfg.MaxLimit),
+		tolerance:    cfg.Tolerance,
+		backoffRatio: cfg.BackoffRatio,
+		limit:        float64(cfg.InitialLimit),
+	}
+	if l.algorithm == "" {
+		l.algorithm = ConcurrencyLimitAIMD
+	}
+	if l.minLimit == 0 {
+		l.minLimit = DefaultConcurrencyMinLimit
+	}
+	if l.maxLimit == 0 {
+		l.maxLimit = DefaultConcurrencyMaxLimit
+	}
+	if l.limit == 0 {
+		l.limit = math.Max(l.minLimit, math.Min(DefaultConcurrencyInitialLimit, l.maxLimit))
+	}
+	if l.tolerance == 0 {
+		l.tolerance = DefaultConcurrencyTolerance
+	}
+	if l.backoffRatio == 0 {
+		l.backoffRatio = DefaultConcurrencyBackoffRatio
+	}
+
+	switch {
+	case l.algorithm != ConcurrencyLimitAIMD && l.algorithm != ConcurrencyLimitGradient:
+		return nil, fmt.Errorf("%w, got %q", errConcurrencyLimitAlgorithm, l.algorithm)
+	case l.minLimit <= 0 || l.minLimit > l.limit || l.limit > l.maxLimit:
+		return nil, errConcurrencyLimitBounds
+	case l.tolerance < 1:
+		return nil, errConcurrencyLimitTolerance
+	case l.backoffRatio <= 0 || l.backoffRatio >= 1:
+		return nil, errConcurrencyLimitBackoff
+	}
+
+	return l, nil
+}
+
+// Acquire takes a slot for a request, and returns false if the backend is at its limit
+func (l *ConcurrencyLimiter) Acquire() bool {
+	if l == nil {
+		return true
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if float64(l.inFlight) >= math.Floor(l.limit) {
+		RecordBackendConcurrencyLimited(l.backend)
+		return false
+	}
+	l.inFlight++
+	RecordBackendConcurrency(l.backend, l.limit, l.inFlight)
+	return true
+}
+
+// Release frees the slot of a request
+func (l *ConcurrencyLimiter) Release() {
+	if l == nil {
+		return
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.inFlight--
+	RecordBackendConcurrency(l.backend, l.limit, l.inFlight)
+}
+
+// Observe adapts the limit to the latency of a request to the backend, or to its failure
+func (l *ConcurrencyLimiter) Observe(latency time.Duration, failed bool) {
+	if l == nil {
+		return
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if !failed {
+		if l.baseline == 0 || latency < l.baseline {
+			l.baseline = latency
+		} else {
+			l.baseline += time.Duration(float64(latency-l.baseline) * concurrencyBaselineDrift)
+		}
+	}
+	// the limit only grows when it is used, so that it doesn't grow unbounded while the backend is idle
+	saturated := float64(l.inFlight)*2 >= l.limit
+
+	switch l.algorithm {
+	case ConcurrencyLimitAIMD:
+		if failed || float64(latency) > l.tolerance*float64(l.baseline) {
+			l.limit *= l.backoffRatio
+		} else if saturated {
+			l.limit += 1 / l.limit
+		}
+	case ConcurrencyLimitGradient:
+		newLimit := l.limit / 2
+		if !failed && latency > 0 {
+			gradient := math.Max(0.5, math.Min(1, l.tolerance*float64(l.baseline)/float64(latency)))
+			newLimit = l.limit*gradient + math.Sqrt(l.limit)
+		}
+		if newLimit > l.limit && !saturated {
+			return
+		}
+		l.limit = l.limit*(1-concurrencyGradientSmoothing) + newLimit*concurrencyGradientSmoothing
+	}
+	l.limit = math.Max(l.minLimit, math.Min(l.maxLimit, l.limit))
+	RecordBackendConcurrency(l.backend, l.limit, l.inFlight)
+}
+
+// Limit returns the current limit
+func (l *ConcurrencyLimiter) Limit() int {
+	if l == nil {
+		return math.MaxInt
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return int(l.limit)
+}
+
+// isBackendFailure returns whether a forwarding error is a sign of an overloaded backend: a transport error,
+// such as a timeout, or a 5xx response code. Invalid requests or responses, the client going away and
+// the backend being skipped over capacity don't say anything about its load
+func isBackendFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var transportErr *backendTransportError
+	if errors.As(err, &transportErr) {
+		return true
+	}
+	var statusErr *backendStatusError
+	return errors.As(err, &statusErr) && statusErr.code >= 500
+}
@@ -0,0 +1,130 @@
+package proxyd
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrencyLimiterAIMD(t *testing.T) {
+	l, err := NewConcurrencyLimiter("node", &ConcurrencyLimitConfig{InitialLimit: 2, MaxLimit: 3})
+	require.NoError(t, err)
+
+	require.True(t, l.Acquire())
+	require.True(t, l.Acquire())
+	require.False(t, l.Acquire())
+
+	// the limit grows by about 1 every limit requests within tolerance of the baseline
+	l.Observe(100*time.Millisecond, false)
+	l.Observe(150*time.Millisecond, false)
+	l.Observe(120*time.Millisecond, false)
+	require.Equal(t, 3, l.Limit())
+	require.True(t, l.Acquire())
+
+	// and is capped
+	for i := 0; i < 10; i++ {
+		l.Observe(100*time.Millisecond, false)
+	}
+	require.Equal(t, 3, l.Limit())
+
+	// slow or failed requests cut it
+	l.Observe(time.Second, false)
+	require.Equal(t, 2, l.Limit())
+	l.Observe(100*time.Millisecond, true)
+	require.Equal(t, 2, l.Limit())
+	for i := 0; i < 10; i++ {
+		l.Observe(time.Second, true)
+	}
+	require.Equal(t, DefaultConcurrencyMinLimit, l.Limit())
+
+	// it only grows when it is used
+	l.Release()
+	l.Release()
+	l.Release()
+	for i := 0; i < 10; i++ {
+		l.Observe(100*time.Millisecond, false)
+	}
+	require.Equal(t, DefaultConcurrencyMinLimit, l.Limit())
+}
+
+func TestConcurrencyLimiterGradient(t *testing.T) {
+	l, err := NewConcurrencyLimiter("node", &ConcurrencyLimitConfig{Algorithm: ConcurrencyLimitGradient, InitialLimit: 16})
+	require.NoError(t, err)
+	for i := 0; i < 16; i++ {
+		require.True(t, l.Acquire())
+	}
+
+	// latencies within tolerance grow the limit by its queue size
+	l.Observe(100*time.Millisecond, false)
+	l.Observe(200*time.Millisecond, false)
+	require.Greater(t, l.Limit(), 16)
+
+	// latencies above it shrink the limit towards limit * tolerance * baseline / latency
+	for i := 0; i < 20; i++ {
+		l.Observe(time.Second, false)
+	}
+	require.Less(t, l.Limit(), 16)
+	limit := l.Limit()
+	for i := 0; i < 5; i++ {
+		l.Observe(100*time.Millisecond, true)
+	}
+	require.Less(t, l.Limit(), limit)
+}
+
+func TestNewConcurrencyLimiter(t *testing.T) {
+	l, err := NewConcurrencyLimiter("node", nil)
+	require.NoError(t, err)
+	require.Nil(t, l)
+	require.True(t, l.Acquire())
+	l.Observe(time.Second, true)
+	l.Release()
+
+	l, err = NewConcurrencyLimiter("node", &ConcurrencyLimitConfig{MaxLimit: 5})
+	require.NoError(t, err)
+	require.Equal(t, 5, l.Limit())
+
+	for _, tt := range []struct {
+		cfg ConcurrencyLimitConfig
+		err error
+	}{
+		{ConcurrencyLimitConfig{Algorithm: "vegas"}, errConcurrencyLimitAlgorithm},
+		{ConcurrencyLimitConfig{InitialLimit: 10, MaxLimit: 5}, errConcurrencyLimitBounds},
+		{ConcurrencyLimitConfig{MinLimit: -1}, errConcurrencyLimitBounds},
+		{ConcurrencyLimitConfig{Tolerance: 0.5}, errConcurrencyLimitTolerance},
+		{ConcurrencyLimitConfig{BackoffRatio: 1.5}, errConcurrencyLimitBackoff},
+	} {
+		_, err := NewConcurrencyLimiter("node", &tt.cfg)
+		require.ErrorIs(t, err, tt.err)
+	}
+}
+
+func TestIsBackendFailure(t *testing.T) {
+	refused := &url.Error{Op: "Post", URL: "http://node", Err: errors.New("connection refused")}
+	timeout := &url.Error{Op: "Post", URL: "http://node", Err: context.DeadlineExceeded}
+	canceled := &url.Error{Op: "Post", URL: "http://node", Err: context.Canceled}
+
+	for name, tc := range map[string]struct {
+		err     error
+		failure bool
+	}{
+		"success":             {nil, false},
+		"connection refused":  {wrapErr(&backendTransportError{err: refused}, "error in backend request"), true},
+		"timeout":             {wrapErr(&backendTransportError{err: timeout}, "error in backend request"), true},
+		"truncated response":  {wrapErr(&backendTransportError{err: errors.New("unexpected EOF")}, "error reading response body"), true},
+		"5xx response":        {&backendStatusError{code: 502}, true},
+		"4xx response":        {&backendStatusError{code: 404}, false},
+		"client canceled":     {wrapErr(&backendTransportError{err: canceled}, "error in backend request"), false},
+		"queued past timeout": {wrapErr(wrapErr(context.DeadlineExceeded, "too many requests"), "error in backend request"), false},
+		"over capacity":       {ErrBackendOverCapacity, false},
+		"invalid response":    {ErrBackendBadResponse, false},
+		"response too large":  {ErrBackendResponseTooLarge, false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.failure, isBackendFailure(tc.err))
+		})
+	}
+}
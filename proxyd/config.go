@@ -21,12 +21,46 @@ type ServerConfig struct {
 	TimeoutSeconds int `toml:"timeout_seconds"`
 
 	MaxUpstreamBatchSize int `toml:"max_upstream_batch_size"`
+	// MaxUpstreamBatchParallelism is the number of upstream batches of a request, across its backend
+	// groups, forwarded concurrently. Default 4, 1 forwards them one after another
+	MaxUpstreamBatchParallelism int `toml:"max_upstream_batch_parallelism"`
 
 	EnableRequestLog      bool `toml:"enable_request_log"`
 	MaxRequestBodyLogLen  int  `toml:"max_request_body_log_len"`
 	EnablePprof           bool `toml:"enable_pprof"`
 	EnableXServedByHeader bool `toml:"enable_served_by_header"`
 	AllowAllOrigins       bool `toml:"allow_all_origins"`
+
+	// EnableCompression compresses responses with gzip or zstd, as negotiated with Accept-Encoding
+	EnableCompression       bool `toml:"enable_compression"`
+	CompressionMinSizeBytes int  `toml:"compression_min_size_bytes"`
+
+	// EnableResponseStreaming copies the result of single requests from the backends to the clients
+	// as it is read, rather than buffering it, if the response is neither cached nor mirrored
+	EnableResponseStreaming bool `toml:"enable_response_streaming"`
+}
+
+// AdmissionConfig queues the backend requests over max_concurrent_rpcs by priority class.
+// Calls are assigned the class of the routing rule they match, else of their method, else of
+// their auth alias, else the default class
+type AdmissionConfig struct {
+	DefaultClass string                          `toml:"default_class"`
+	Classes      map[string]*PriorityClassConfig `toml:"classes"`
+	Methods      map[string]string               `toml:"methods"`
+	Auth         map[string]string               `toml:"auth"`
+	// MaxQueued is the number of waiting requests across the classes beyond which the lower
+	// priority ones are shed, unlimited if 0
+	MaxQueued int `toml:"max_queued"`
+}
+
+type PriorityClassConfig struct {
+	// Weight is the share of the admissions given to the class when the queues are busy, default 1.
+	// Classes with a lower weight are shed first
+	Weight int `toml:"weight"`
+	// MaxQueue is the number of requests waiting in the class, default 1000
+	MaxQueue int `toml:"max_queue"`
+	// MaxWait is the time after which a waiting request is shed, default 5s
+	MaxWait TOMLDuration `toml:"max_wait"`
 }
 
 type CacheConfig struct {
@@ -37,6 +71,13 @@ type CacheConfig struct {
 type RedisConfig struct {
 	URL       string `toml:"url"`
 	Namespace string `toml:"namespace"`
+
+	// Mode is "single" (default), "sentinel" or "cluster". In sentinel mode, the URL host is a sentinel
+	// and its credentials are the ones of the master. Addrs lists more sentinels or cluster nodes.
+	Mode             string   `toml:"mode"`
+	Addrs            []string `toml:"addrs"`
+	MasterName       string   `toml:"master_name"`
+	SentinelPassword string   `toml:"sentinel_password"`
 }
 
 type MetricsConfig struct {
@@ -45,6 +86,80 @@ type MetricsConfig struct {
 	Port    int    `toml:"port"`
 }
 
+type TracingConfig struct {
+	Enabled bool `toml:"enabled"`
+	// Exporter is either "otlp" (default) or "stdout"
+	Exporter string `toml:"exporter"`
+	// Endpoint of the OTLP/HTTP collector, default localhost:4318
+	Endpoint    string  `toml:"endpoint"`
+	Insecure    bool    `toml:"insecure"`
+	ServiceName string  `toml:"service_name"`
+	SampleRate  float64 `toml:"sample_rate"`
+}
+
+type AccessLogConfig struct {
+	Enabled bool `toml:"enabled"`
+	// Output is either "stdout" (default) or a file path
+	Output string `toml:"output"`
+	// MaxSizeMB rotates the output file once it reaches the given size, 0 disables rotation
+	MaxSizeMB  int     `toml:"max_size_mb"`
+	MaxBackups int     `toml:"max_backups"`
+	SampleRate float64 `toml:"sample_rate"`
+	// RedactFields replaces the value of the given record fields, e.g. "remote_ip"
+	RedactFields []string `toml:"redact_fields"`
+}
+
+type CaptureConfig struct {
+	Enabled bool `toml:"enabled"`
+	// Output is the path of the capture file
+	Output     string  `toml:"output"`
+	MaxSizeMB  int     `toml:"max_size_mb"`
+	MaxBackups int     `toml:"max_backups"`
+	SampleRate float64 `toml:"sample_rate"`
+	// RedactHeaders defaults to Authorization, Cookie and X-Api-Key
+	RedactHeaders    []string `toml:"redact_headers"`
+	IncludeResponses bool     `toml:"include_responses"`
+}
+
+type MirrorConfig struct {
+	// BackendGroup and Methods select the mirrored requests, at least one of them must be set
+	BackendGroup string   `toml:"backend_group"`
+	Methods      []string `toml:"methods"`
+	ShadowGroup  string   `toml:"shadow_group"`
+	SampleRate   float64  `toml:"sample_rate"`
+	// MaxInFlight bounds the concurrent shadow requests, further requests are dropped
+	MaxInFlight int          `toml:"max_in_flight"`
+	Timeout     TOMLDuration `toml:"timeout"`
+}
+
+type MeteringConfig struct {
+	Enabled       bool         `toml:"enabled"`
+	FlushInterval TOMLDuration `toml:"flush_interval"`
+	// SoftLimitRatio is the share of a quota after which warnings are emitted, default 0.8
+	SoftLimitRatio float64 `toml:"soft_limit_ratio"`
+	// DefaultQuota applies to the auth aliases without an entry in Quotas
+	DefaultQuota *QuotaConfig            `toml:"default_quota"`
+	Quotas       map[string]*QuotaConfig `toml:"quotas"`
+}
+
+// QuotaConfig limits the usage of a tenant, a zero value means no limit
+type QuotaConfig struct {
+	DailyRequests        int64 `toml:"daily_requests" json:"daily_requests,omitempty"`
+	MonthlyRequests      int64 `toml:"monthly_requests" json:"monthly_requests,omitempty"`
+	DailyResponseBytes   int64 `toml:"daily_response_bytes" json:"daily_response_bytes,omitempty"`
+	MonthlyResponseBytes int64 `toml:"monthly_response_bytes" json:"monthly_response_bytes,omitempty"`
+}
+
+type ReorgsConfig struct {
+	// HistorySize is the number of reorg events kept in memory, default 100
+	HistorySize    int               `toml:"history_size"`
+	WebhookURL     string            `toml:"webhook_url"`
+	WebhookHeaders map[string]string `toml:"webhook_headers"`
+	WebhookTimeout TOMLDuration      `toml:"webhook_timeout"`
+	// WebhookMinDepth skips the notification of shallower reorgs
+	WebhookMinDepth uint64 `toml:"webhook_min_depth"`
+}
+
 type RateLimitConfig struct {
 	UseRedis         bool                                `toml:"use_redis"`
 	BaseRate         int                                 `toml:"base_rate"`
@@ -74,6 +189,10 @@ func (t *TOMLDuration) UnmarshalText(b []byte) error {
 	return nil
 }
 
+func (t TOMLDuration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(t).String()), nil
+}
+
 type BackendOptions struct {
 	ResponseTimeoutSeconds      int          `toml:"response_timeout_seconds"`
 	MaxResponseSizeBytes        int64        `toml:"max_response_size_bytes"`
@@ -82,6 +201,25 @@ type BackendOptions struct {
 	MaxDegradedLatencyThreshold TOMLDuration `toml:"max_degraded_latency_threshold"`
 	MaxLatencyThreshold         TOMLDuration `toml:"max_latency_threshold"`
 	MaxErrorRateThreshold       float64      `toml:"max_error_rate_threshold"`
+	// RetryableErrors are the JSON-RPC errors failing over to the next backend of the group
+	RetryableErrors []RetryableErrorConfig `toml:"retryable_errors"`
+	// SharedRateLimit shares the max_rps of each backend across the proxyd instances through Redis
+	SharedRateLimit SharedRateLimitConfig `toml:"shared_rate_limit"`
+	// CostFlushInterval is how often the spend of the backends with a cost model is written to Redis
+	CostFlushInterval TOMLDuration `toml:"cost_flush_interval"`
+}
+
+type SharedRateLimitConfig struct {
+	Enabled bool `toml:"enabled"`
+	// BatchSize is the number of tokens reserved from Redis at once, default 10
+	BatchSize int `toml:"batch_size"`
+}
+
+// RetryableErrorConfig matches a JSON-RPC error by code, message pattern, or both
+type RetryableErrorConfig struct {
+	Name    string `toml:"name"`
+	Code    int    `toml:"code"`
+	Message string `toml:"message"`
 }
 
 type BackendConfig struct {
@@ -103,6 +241,41 @@ type BackendConfig struct {
 	ConsensusSkipPeerCountCheck bool   `toml:"consensus_skip_peer_count"`
 	ConsensusForcedCandidate    bool   `toml:"consensus_forced_candidate"`
 	ConsensusReceiptsTarget     string `toml:"consensus_receipts_target"`
+
+	// RetryableErrors are added to the backend options ones, e.g. for provider specific codes
+	RetryableErrors []RetryableErrorConfig `toml:"retryable_errors"`
+
+	ConcurrencyLimit *ConcurrencyLimitConfig `toml:"concurrency_limit"`
+
+	// Cost makes the backend a paid one, used after the free backends of its groups
+	Cost *CostConfig `toml:"cost"`
+}
+
+// CostConfig is the pricing of a paid backend, in any currency unit
+type CostConfig struct {
+	PricePerRequest float64 `toml:"price_per_request"`
+	// Methods override the price per request of some methods, e.g. the compute units
+	// of the method multiplied by the price per compute unit
+	Methods map[string]float64 `toml:"methods"`
+	// MonthlyBudget excludes the backend once its spend in the calendar month reaches it, 0 for no budget
+	MonthlyBudget float64 `toml:"monthly_budget"`
+	// DeprioritizeRatio is the ratio of the monthly budget spent after which the backend
+	// is used after the other paid backends, default 0.8
+	DeprioritizeRatio float64 `toml:"deprioritize_ratio"`
+}
+
+// ConcurrencyLimitConfig adapts the number of requests in flight to a backend to its latency and errors
+type ConcurrencyLimitConfig struct {
+	// Algorithm is "aimd" (default) or "gradient"
+	Algorithm    string `toml:"algorithm"`
+	InitialLimit int    `toml:"initial_limit"`
+	MinLimit     int    `toml:"min_limit"`
+	MaxLimit     int    `toml:"max_limit"`
+	// Tolerance is the latency, relative to the baseline latency of the backend, above which
+	// the limit is cut, default 2
+	Tolerance float64 `toml:"tolerance"`
+	// BackoffRatio multiplies the limit when it is cut by the aimd algorithm, default 0.9
+	BackoffRatio float64 `toml:"backoff_ratio"`
 }
 
 type BackendsConfig map[string]*BackendConfig
@@ -121,6 +294,12 @@ type BackendGroupConfig struct {
 	ConsensusMaxBlockLag        uint64       `toml:"consensus_max_block_lag"`
 	ConsensusMaxBlockRange      uint64       `toml:"consensus_max_block_range"`
 	ConsensusMinPeerCount       int          `toml:"consensus_min_peer_count"`
+	// ConsensusStrategy is "lowest_common" (default) or "quorum"
+	ConsensusStrategy string  `toml:"consensus_strategy"`
+	ConsensusQuorum   float64 `toml:"consensus_quorum"`
+	// ConsensusServeLagging lets healthy backends out of the consensus group serve
+	// requests for blocks they already have
+	ConsensusServeLagging bool `toml:"consensus_serve_lagging"`
 
 	ConsensusHA                  bool         `toml:"consensus_ha"`
 	ConsensusHAHeartbeatInterval TOMLDuration `toml:"consensus_ha_heartbeat_interval"`
@@ -128,10 +307,67 @@ type BackendGroupConfig struct {
 	ConsensusHARedis             RedisConfig  `toml:"consensus_ha_redis"`
 
 	Fallbacks []string `toml:"fallbacks"`
+	// FallbackMinPrimaries is the number of healthy primaries under which the group falls back, default 1
+	FallbackMinPrimaries int `toml:"fallback_min_primaries"`
+	// FallbackFailBackPeriod is how long the primaries must stay healthy before the group fails back
+	FallbackFailBackPeriod TOMLDuration `toml:"fallback_fail_back_period"`
+
+	Discovery *DiscoveryConfig `toml:"discovery"`
+
+	ConsensusSnapshot *ConsensusSnapshotConfig `toml:"consensus_snapshot"`
+
+	RetryBudget *RetryBudgetConfig `toml:"retry_budget"`
+}
+
+// RetryBudgetConfig limits the retries of a backend group to a ratio of its requests
+type RetryBudgetConfig struct {
+	// Ratio is the number of retries allowed per request, default 0.1
+	Ratio float64 `toml:"ratio"`
+	// MaxTokens is the number of retries available after a quiet period, default 10
+	MaxTokens float64 `toml:"max_tokens"`
+}
+
+// ConsensusSnapshotConfig saves the consensus state of a group, so that a restarted instance resumes from it
+type ConsensusSnapshotConfig struct {
+	// Type is "file" or "redis", the redis snapshot uses the [redis] config
+	Type string `toml:"type"`
+	// Path is the directory of the file snapshots, named after the group
+	Path     string       `toml:"path"`
+	Interval TOMLDuration `toml:"interval"`
+	// MaxAge is the age after which a snapshot is ignored, default 5m
+	MaxAge TOMLDuration `toml:"max_age"`
 }
 
 type BackendGroupsConfig map[string]*BackendGroupConfig
 
+// HasDiscovery returns whether any backend group discovers its backends at runtime
+func (c BackendGroupsConfig) HasDiscovery() bool {
+	for _, bg := range c {
+		if bg.Discovery != nil {
+			return true
+		}
+	}
+	return false
+}
+
+type DiscoveryConfig struct {
+	// Type is either "dns" or "file"
+	Type     string       `toml:"type"`
+	Interval TOMLDuration `toml:"interval"`
+
+	// SRVName is resolved by dns discovery, or Hostname is resolved to A/AAAA records when it is unset
+	SRVName    string `toml:"srv_name"`
+	Hostname   string `toml:"hostname"`
+	Port       int    `toml:"port"`
+	Nameserver string `toml:"nameserver"`
+
+	// Path of the JSON or TOML file listing the backends for file discovery
+	Path string `toml:"path"`
+
+	// Template of the discovered backends. rpc_url and ws_url can use the {host} and {port} placeholders.
+	Template BackendConfig `toml:"template"`
+}
+
 type MethodMappingsConfig map[string]string
 
 type BatchConfig struct {
@@ -149,12 +385,78 @@ type SenderRateLimitConfig struct {
 	AllowedChainIds []*big.Int `toml:"allowed_chain_ids"`
 }
 
+// RoutingRuleConfig is an ordered rule evaluated against every JSON-RPC call
+// before the method is mapped to a backend group. The first matching rule wins.
+type RoutingRuleConfig struct {
+	Name   string                 `toml:"name"`
+	Match  RoutingRuleMatchConfig `toml:"match"`
+	Action string                 `toml:"action"`
+
+	// BackendGroup is required for the route action, and optional for the
+	// rate_limit action where it routes the calls that are under the limit.
+	BackendGroup string `toml:"backend_group"`
+
+	// ErrorCode and ErrorMessage customize the error returned by the reject action.
+	ErrorCode    int    `toml:"error_code"`
+	ErrorMessage string `toml:"error_message"`
+
+	// Limit and Interval configure the rate_limit action, keyed by remote IP.
+	Limit    int          `toml:"limit"`
+	Interval TOMLDuration `toml:"interval"`
+
+	// Priority assigns the matching calls to an admission priority class.
+	Priority string `toml:"priority"`
+}
+
+// RoutingRuleMatchConfig holds the predicates of a routing rule.
+// All the non-empty predicates must match for the rule to apply.
+type RoutingRuleMatchConfig struct {
+	Auth                []string                 `toml:"auth"`
+	Origin              string                   `toml:"origin"`
+	UserAgent           string                   `toml:"user_agent"`
+	Methods             []string                 `toml:"methods"`
+	Params              []RoutingRuleParamConfig `toml:"params"`
+	MinRequestSizeBytes int                      `toml:"min_request_size_bytes"`
+	MaxRequestSizeBytes int                      `toml:"max_request_size_bytes"`
+}
+
+// RoutingRuleParamConfig matches a single value inside the request params.
+// Path is a dot-separated list of array indexes and object keys, e.g. "0.to".
+type RoutingRuleParamConfig struct {
+	Path    string   `toml:"path"`
+	Values  []string `toml:"values"`
+	Pattern string   `toml:"pattern"`
+}
+
+// MethodValidationConfig limits the params of the calls to a method. Zero values disable the limits
+type MethodValidationConfig struct {
+	// MaxParamsBytes limits the size of the params, e.g. of the raw transactions
+	MaxParamsBytes int `toml:"max_params_bytes"`
+	// MaxGas and MaxCalldataBytes limit the transaction object of eth_call, eth_estimateGas, debug_traceCall...
+	// The calls without gas are forwarded with MaxGas
+	MaxGas           uint64 `toml:"max_gas"`
+	MaxCalldataBytes int    `toml:"max_calldata_bytes"`
+	// RequireBlockRange, MaxAddresses and MaxTopics limit the filter object of eth_getLogs
+	RequireBlockRange bool `toml:"require_block_range"`
+	MaxAddresses      int  `toml:"max_addresses"`
+	MaxTopics         int  `toml:"max_topics"`
+	// AllowedTracers restricts the tracers of the debug_trace* methods, so that custom JS tracers can be rejected
+	AllowedTracers []string `toml:"allowed_tracers"`
+}
+
 type Config struct {
 	WSBackendGroup        string                `toml:"ws_backend_group"`
 	Server                ServerConfig          `toml:"server"`
+	Admission             AdmissionConfig       `toml:"admission"`
 	Cache                 CacheConfig           `toml:"cache"`
 	Redis                 RedisConfig           `toml:"redis"`
 	Metrics               MetricsConfig         `toml:"metrics"`
+	Tracing               TracingConfig         `toml:"tracing"`
+	AccessLog             AccessLogConfig       `toml:"access_log"`
+	Capture               CaptureConfig         `toml:"capture"`
+	Mirrors               []*MirrorConfig       `toml:"mirrors"`
+	Metering              MeteringConfig        `toml:"metering"`
+	Reorgs                ReorgsConfig          `toml:"reorgs"`
 	RateLimit             RateLimitConfig       `toml:"rate_limit"`
 	BackendOptions        BackendOptions        `toml:"backend"`
 	Backends              BackendsConfig        `toml:"backends"`
@@ -165,6 +467,9 @@ type Config struct {
 	WSMethodWhitelist     []string              `toml:"ws_method_whitelist"`
 	WhitelistErrorMessage string                `toml:"whitelist_error_message"`
 	SenderRateLimit       SenderRateLimitConfig `toml:"sender_rate_limit"`
+	RoutingRules          []*RoutingRuleConfig  `toml:"routing_rules"`
+	// Validation limits the params of the calls, by method
+	Validation map[string]*MethodValidationConfig `toml:"validation"`
 }
 
 func ReadFromEnvOrConfig(value string) (string, error) {
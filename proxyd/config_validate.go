@@ -0,0 +1,450 @@
+package proxyd
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+const redactedSecret = "[REDACTED]"
+
+// LoadConfig reads a TOML config file, along with the metadata needed to report unknown keys
+func LoadConfig(path string) (*Config, toml.MetaData, error) {
+	config := new(Config)
+	md, err := toml.DecodeFile(path, config)
+	if err != nil {
+		return nil, md, err
+	}
+	return config, md, nil
+}
+
+// UnknownKeys returns the keys of the config file that don't map to a config field
+func UnknownKeys(md toml.MetaData) []string {
+	undecoded := md.Undecoded()
+	keys := make([]string, 0, len(undecoded))
+	for _, key := range undecoded {
+		keys = append(keys, key.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ValidateConfig runs every check of Start that doesn't require a network connection,
+// and returns all the problems found rather than the first one
+func ValidateConfig(config *Config, md toml.MetaData) []error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+	checkEnv := func(field string, value string) string {
+		resolved, err := ReadFromEnvOrConfig(value)
+		if err != nil {
+			fail("%s: %v", field, err)
+		}
+		return resolved
+	}
+	checkRedis := func(field string, cfg RedisConfig) {
+		if cfg.URL == "" {
+			return
+		}
+		// creating the client doesn't connect to Redis
+		client, err := newUniversalRedisClient(cfg)
+		if err != nil {
+			fail("%s: %v", field, err)
+			return
+		}
+		client.Close()
+	}
+
+	for _, key := range UnknownKeys(md) {
+		fail("unknown config key: %s", key)
+	}
+
+	if len(config.Backends) == 0 && !config.BackendGroups.HasDiscovery() {
+		fail("must define at least one backend")
+	}
+	if len(config.BackendGroups) == 0 {
+		fail("must define at least one backend group")
+	}
+	if len(config.RPCMethodMappings) == 0 {
+		fail("must define at least one RPC method mapping")
+	}
+	for authKey := range config.Authentication {
+		if authKey == "none" {
+			fail("cannot use none as an auth key")
+		}
+	}
+
+	checkRedis("redis", config.Redis)
+	hasRedis := config.Redis.URL != ""
+	if config.RateLimit.UseRedis && !hasRedis {
+		fail("must specify a Redis URL if UseRedis is true in rate limit config")
+	}
+	if config.Metering.Enabled && !hasRedis {
+		fail("must specify a Redis URL if metering is enabled")
+	}
+	if config.BackendOptions.SharedRateLimit.Enabled && !hasRedis {
+		fail("must specify a Redis URL if shared_rate_limit is enabled")
+	}
+	if config.BackendOptions.SharedRateLimit.BatchSize < 0 {
+		fail("backend.shared_rate_limit.batch_size must not be negative")
+	}
+	if hasBackendCosts(config) && !hasRedis {
+		fail("must specify a Redis URL if a backend has a cost")
+	}
+	if config.BackendOptions.CostFlushInterval < 0 {
+		fail("backend.cost_flush_interval must not be negative")
+	}
+	if config.Server.MaxUpstreamBatchParallelism < 0 {
+		fail("server.max_upstream_batch_parallelism must not be negative")
+	}
+	if config.Reorgs.HistorySize < 0 {
+		fail("reorgs.history_size must not be negative")
+	}
+	if config.Reorgs.WebhookURL != "" {
+		if webhookURL := checkEnv("reorgs.webhook_url", config.Reorgs.WebhookURL); webhookURL != "" && !isHTTPURL(webhookURL) {
+			fail("%v", errReorgWebhookURL)
+		}
+		for _, header := range sortedKeys(config.Reorgs.WebhookHeaders) {
+			checkEnv("reorgs.webhook_headers."+header, config.Reorgs.WebhookHeaders[header])
+		}
+	}
+	if config.SenderRateLimit.Enabled {
+		if config.SenderRateLimit.Limit <= 0 {
+			fail("limit in sender_rate_limit must be > 0")
+		}
+		if time.Duration(config.SenderRateLimit.Interval) < time.Second {
+			fail("interval in sender_rate_limit must be >= 1s")
+		}
+	}
+	if config.Tracing.Enabled {
+		switch config.Tracing.Exporter {
+		case "", TracingExporterOTLP, TracingExporterStdout:
+		default:
+			fail("unknown tracing exporter: %s", config.Tracing.Exporter)
+		}
+	}
+
+	if _, err := NewRetryableErrorClassifier(config.BackendOptions.RetryableErrors); err != nil {
+		fail("backend.retryable_errors: %v", err)
+	}
+
+	for _, name := range sortedKeys(config.Backends) {
+		_, _, _, backendErrs := backendOptsFromConfig(name, config.Backends[name], config.BackendOptions, nil, nil)
+		for _, err := range backendErrs {
+			fail("backends.%s.%v", name, err)
+		}
+	}
+
+	for _, name := range sortedKeys(config.BackendGroups) {
+		bg := config.BackendGroups[name]
+		errs = append(errs, backendGroupConfigErrors(name, bg, config)...)
+		if bg.ConsensusHA {
+			checkRedis("backend_groups."+name+".consensus_ha_redis", bg.ConsensusHARedis)
+		}
+	}
+
+	if config.WSBackendGroup != "" && config.BackendGroups[config.WSBackendGroup] == nil {
+		fail("ws backend group %s does not exist", config.WSBackendGroup)
+	}
+	if config.WSBackendGroup == "" && config.Server.WSPort != 0 {
+		fail("a ws port was defined, but no ws group was defined")
+	}
+
+	for _, method := range sortedKeys(config.RPCMethodMappings) {
+		group := config.RPCMethodMappings[method]
+		if config.BackendGroups[group] == nil {
+			fail("method %s maps to undefined backend group %s", method, group)
+		}
+	}
+
+	if _, err := NewRoutingRules(config.RoutingRules, func(time.Duration, int, string) FrontendRateLimiter {
+		return NoopFrontendRateLimiter
+	}); err != nil {
+		fail("%v", err)
+	}
+	for _, rule := range config.RoutingRules {
+		if rule.BackendGroup != "" && config.BackendGroups[rule.BackendGroup] == nil {
+			fail("routing rule %s uses undefined backend group %s", rule.Name, rule.BackendGroup)
+		}
+		if rule.Priority != "" && config.Admission.Classes[rule.Priority] == nil {
+			fail("routing rule %s uses undefined priority class %s", rule.Name, rule.Priority)
+		}
+	}
+
+	if _, err := NewRequestValidator(config.Validation); err != nil {
+		fail("%v", err)
+	}
+
+	if len(config.Admission.Classes) > 0 {
+		if config.Server.MaxConcurrentRPCs == 0 {
+			fail("admission classes require server.max_concurrent_rpcs")
+		}
+		if _, err := NewAdmissionController(config.Server.MaxConcurrentRPCs, config.Admission); err != nil {
+			fail("%v", err)
+		}
+		for _, method := range sortedKeys(config.Admission.Methods) {
+			if class := config.Admission.Methods[method]; config.Admission.Classes[class] == nil {
+				fail("admission method %s uses undefined class %s", method, class)
+			}
+		}
+		for _, alias := range sortedKeys(config.Admission.Auth) {
+			if class := config.Admission.Auth[alias]; config.Admission.Classes[class] == nil {
+				fail("admission auth alias %s uses undefined class %s", alias, class)
+			}
+		}
+	} else if config.Admission.DefaultClass != "" || len(config.Admission.Methods) > 0 || len(config.Admission.Auth) > 0 {
+		fail("admission must define classes")
+	}
+
+	groups := make(map[string]*BackendGroup, len(config.BackendGroups))
+	for name := range config.BackendGroups {
+		groups[name] = &BackendGroup{Name: name}
+	}
+	if _, err := NewMirrors(config.Mirrors, groups); err != nil {
+		fail("%v", err)
+	}
+
+	for name, sampleRate := range map[string]float64{
+		"access_log.sample_rate": config.AccessLog.SampleRate,
+		"capture.sample_rate":    config.Capture.SampleRate,
+	} {
+		if sampleRate < 0 || sampleRate > 1 {
+			fail("%s must be between 0 and 1", name)
+		}
+	}
+	if config.Capture.Enabled && config.Capture.Output == "" {
+		fail("capture.output must be set")
+	}
+
+	return errs
+}
+
+// EffectiveConfig returns a copy of the config with the defaults applied by proxyd, and
+// with secrets such as auth keys, passwords, headers and URL credentials redacted.
+// Values read from environment variables are left as references.
+func EffectiveConfig(config *Config) (*Config, error) {
+	// round trip through TOML to get a deep copy
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(config); err != nil {
+		return nil, err
+	}
+	c := new(Config)
+	if _, err := toml.Decode(buf.String(), c); err != nil {
+		return nil, err
+	}
+
+	setDefault(&c.Server.MaxBodySizeBytes, int64(defaultBodySizeLimit))
+	setDefault(&c.Server.TimeoutSeconds, int(defaultRPCTimeout/time.Second))
+	setDefault(&c.Server.MaxUpstreamBatchSize, defaultMaxUpstreamBatchSize)
+	setDefault(&c.Server.MaxUpstreamBatchParallelism, defaultMaxUpstreamBatchParallelism)
+	setDefault(&c.Server.MaxRequestBodyLogLen, maxRequestBodyLogLen)
+	setDefault(&c.Server.LogLevel, "info")
+	if c.Server.EnableCompression {
+		setDefault(&c.Server.CompressionMinSizeBytes, defaultCompressionMinSize)
+	}
+	setDefault(&c.BatchConfig.MaxSize, DefaultMaxBatchRPCCallsLimit)
+	if c.BatchConfig.MaxSize > MaxBatchRPCCallsHardLimit {
+		c.BatchConfig.MaxSize = MaxBatchRPCCallsHardLimit
+	}
+	setDefault(&c.RateLimit.IPHeaderOverride, defaultRateLimitHeader)
+	for _, class := range c.Admission.Classes {
+		setDefault(&class.Weight, DefaultAdmissionWeight)
+		setDefault(&class.MaxQueue, DefaultAdmissionMaxQueue)
+		setDefault(&class.MaxWait, TOMLDuration(DefaultAdmissionMaxWait))
+	}
+	if c.Cache.Enabled {
+		setDefault(&c.Cache.TTL, TOMLDuration(defaultCacheTtl))
+	}
+
+	setDefault(&c.BackendOptions.ResponseTimeoutSeconds, 5)
+	setDefault(&c.BackendOptions.MaxLatencyThreshold, TOMLDuration(10*time.Second))
+	setDefault(&c.BackendOptions.MaxDegradedLatencyThreshold, TOMLDuration(5*time.Second))
+	setDefault(&c.BackendOptions.MaxErrorRateThreshold, 0.5)
+	if c.BackendOptions.SharedRateLimit.Enabled {
+		setDefault(&c.BackendOptions.SharedRateLimit.BatchSize, DefaultSharedRateLimitBatchSize)
+	}
+	if hasBackendCosts(c) {
+		setDefault(&c.BackendOptions.CostFlushInterval, TOMLDuration(defaultCostFlushInterval))
+	}
+
+	for _, b := range c.Backends {
+		setDefault(&b.ConsensusReceiptsTarget, ReceiptsTargetDebugGetRawReceipts)
+		if cl := b.ConcurrencyLimit; cl != nil {
+			setDefault(&cl.Algorithm, ConcurrencyLimitAIMD)
+			setDefault(&cl.MinLimit, DefaultConcurrencyMinLimit)
+			setDefault(&cl.MaxLimit, DefaultConcurrencyMaxLimit)
+			setDefault(&cl.InitialLimit, max(cl.MinLimit, min(DefaultConcurrencyInitialLimit, cl.MaxLimit)))
+			setDefault(&cl.Tolerance, DefaultConcurrencyTolerance)
+			setDefault(&cl.BackoffRatio, DefaultConcurrencyBackoffRatio)
+		}
+		if cost := b.Cost; cost != nil {
+			setDefault(&cost.DeprioritizeRatio, DefaultCostDeprioritizeRatio)
+		}
+		b.RPCURL = redactURL(b.RPCURL)
+		b.WSURL = redactURL(b.WSURL)
+		b.Password = redactSecret(b.Password)
+		for header, value := range b.Headers {
+			b.Headers[header] = redactSecret(value)
+		}
+	}
+
+	for _, bg := range c.BackendGroups {
+		if bg.ConsensusAware {
+			setDefault(&bg.ConsensusBanPeriod, TOMLDuration(5*time.Minute))
+			setDefault(&bg.ConsensusMaxUpdateThreshold, TOMLDuration(30*time.Second))
+			setDefault(&bg.ConsensusMaxBlockLag, 8)
+			setDefault(&bg.ConsensusMinPeerCount, 3)
+			setDefault(&bg.ConsensusPollerInterval, TOMLDuration(DefaultPollerInterval))
+			setDefault(&bg.ConsensusStrategy, ConsensusStrategyLowestCommon)
+			if bg.ConsensusStrategy == ConsensusStrategyQuorum {
+				setDefault(&bg.ConsensusQuorum, DefaultConsensusQuorum)
+			}
+		}
+		if len(bg.Fallbacks) > 0 {
+			setDefault(&bg.FallbackMinPrimaries, DefaultFallbackMinPrimaries)
+		}
+		if rb := bg.RetryBudget; rb != nil {
+			setDefault(&rb.Ratio, DefaultRetryBudgetRatio)
+			setDefault(&rb.MaxTokens, DefaultRetryBudgetMaxTokens)
+		}
+		if s := bg.ConsensusSnapshot; s != nil {
+			setDefault(&s.Interval, TOMLDuration(defaultConsensusSnapshotInterval))
+			setDefault(&s.MaxAge, TOMLDuration(defaultConsensusSnapshotMaxAge))
+		}
+		if bg.ConsensusHA {
+			setDefault(&bg.ConsensusHALockPeriod, TOMLDuration(30*time.Second))
+			setDefault(&bg.ConsensusHAHeartbeatInterval, TOMLDuration(2*time.Second))
+		}
+		redactRedisConfig(&bg.ConsensusHARedis)
+		if d := bg.Discovery; d != nil {
+			setDefault(&d.Interval, TOMLDuration(defaultDiscoveryInterval))
+			if d.Type == DiscoveryTypeDNS || d.Type == DiscoveryTypeFile {
+				setDefault(&d.Template.RPCURL, defaultDiscoveryRPCURL)
+			}
+			setDefault(&d.Template.ConsensusReceiptsTarget, ReceiptsTargetDebugGetRawReceipts)
+			d.Template.RPCURL = redactTemplateURL(d.Template.RPCURL)
+			d.Template.WSURL = redactTemplateURL(d.Template.WSURL)
+			d.Template.Password = redactSecret(d.Template.Password)
+			for header, value := range d.Template.Headers {
+				d.Template.Headers[header] = redactSecret(value)
+			}
+		}
+	}
+
+	if c.Tracing.Enabled {
+		setDefault(&c.Tracing.Exporter, TracingExporterOTLP)
+		setDefault(&c.Tracing.Endpoint, defaultTracingEndpoint)
+		setDefault(&c.Tracing.ServiceName, defaultTracingServiceName)
+		setDefault(&c.Tracing.SampleRate, 1)
+	}
+	if c.AccessLog.Enabled {
+		setDefault(&c.AccessLog.Output, AccessLogOutputStdout)
+		setDefault(&c.AccessLog.SampleRate, 1)
+	}
+	if c.Capture.Enabled {
+		setDefault(&c.Capture.SampleRate, 1)
+		if c.Capture.RedactHeaders == nil {
+			c.Capture.RedactHeaders = defaultCaptureRedactHeaders
+		}
+	}
+	for _, m := range c.Mirrors {
+		setDefault(&m.Timeout, TOMLDuration(defaultMirrorTimeout))
+		setDefault(&m.MaxInFlight, defaultMirrorMaxInFlight)
+	}
+	setDefault(&c.Reorgs.HistorySize, defaultReorgHistorySize)
+	if c.Reorgs.WebhookURL != "" {
+		setDefault(&c.Reorgs.WebhookTimeout, TOMLDuration(defaultReorgWebhookTimeout))
+		c.Reorgs.WebhookURL = redactURL(c.Reorgs.WebhookURL)
+		for header, value := range c.Reorgs.WebhookHeaders {
+			c.Reorgs.WebhookHeaders[header] = redactSecret(value)
+		}
+	}
+	if c.Metering.Enabled {
+		setDefault(&c.Metering.FlushInterval, TOMLDuration(defaultMeteringFlushInterval))
+		setDefault(&c.Metering.SoftLimitRatio, defaultMeteringSoftLimitRatio)
+	}
+
+	redactRedisConfig(&c.Redis)
+	if len(c.Authentication) > 0 {
+		auth := make(map[string]string, len(c.Authentication))
+		i := 0
+		for _, alias := range sortedValues(c.Authentication) {
+			i++
+			auth[fmt.Sprintf("%s_%d", redactedSecret, i)] = alias
+		}
+		c.Authentication = auth
+	}
+
+	return c, nil
+}
+
+func redactRedisConfig(cfg *RedisConfig) {
+	if cfg.URL != "" {
+		setDefault(&cfg.Mode, RedisModeSingle)
+	}
+	cfg.URL = redactURL(cfg.URL)
+	cfg.SentinelPassword = redactSecret(cfg.SentinelPassword)
+}
+
+func setDefault[T comparable](field *T, value T) {
+	var zero T
+	if *field == zero {
+		*field = value
+	}
+}
+
+// redactSecret redacts a literal value, environment variable references are kept
+func redactSecret(value string) string {
+	if value == "" || strings.HasPrefix(value, "$") {
+		return value
+	}
+	return redactedSecret
+}
+
+// redactURL keeps the scheme and host of a literal URL, since API keys are often part of the path or query
+func redactURL(value string) string {
+	if value == "" || strings.HasPrefix(value, "$") {
+		return value
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		return redactedSecret
+	}
+	redacted := u.Scheme + "://" + u.Host
+	if u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+		redacted += "/" + redactedSecret
+	}
+	return redacted
+}
+
+// redactTemplateURL redacts a discovery template URL, keeping its {host} and {port} placeholders
+func redactTemplateURL(value string) string {
+	// the placeholders aren't a valid host and port, they're swapped for ones that are while parsing
+	const host, port = "discovered.invalid", "65535"
+	redacted := redactURL(strings.NewReplacer("{host}", host, "{port}", port).Replace(value))
+	return strings.NewReplacer(host, "{host}", ":"+port, ":{port}").Replace(redacted)
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedValues(m map[string]string) []string {
+	values := make([]string, 0, len(m))
+	for _, v := range m {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	return values
+}
@@ -0,0 +1,110 @@
+package proxyd
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// FallbackMode is the mode of a backend group with fallback backends
+type FallbackMode string
+
+const (
+	// FallbackModePrimary serves traffic from the primaries
+	FallbackModePrimary FallbackMode = "primary"
+	// FallbackModeFallback serves traffic from the fallbacks, as there are not enough healthy primaries
+	FallbackModeFallback FallbackMode = "fallback"
+	// FallbackModeRecovering still serves traffic from the fallbacks, until the primaries have been healthy
+	// for the fail-back period
+	FallbackModeRecovering FallbackMode = "recovering"
+
+	DefaultFallbackMinPrimaries = 1
+)
+
+var fallbackModes = []FallbackMode{FallbackModePrimary, FallbackModeFallback, FallbackModeRecovering}
+
+// FallbackState decides when a backend group switches to its fallbacks, and when it switches back.
+// The group falls back when it has less than minPrimaries healthy primaries, and fails back once they
+// have been healthy for failBackPeriod, so that unstable primaries don't flap the traffic
+type FallbackState struct {
+	group          string
+	minPrimaries   int
+	failBackPeriod time.Duration
+
+	mtx          sync.Mutex
+	mode         FallbackMode
+	healthySince time.Time
+}
+
+func NewFallbackState(group string, minPrimaries int, failBackPeriod time.Duration) *FallbackState {
+	if minPrimaries <= 0 {
+		minPrimaries = DefaultFallbackMinPrimaries
+	}
+	fs := &FallbackState{
+		group:          group,
+		minPrimaries:   minPrimaries,
+		failBackPeriod: failBackPeriod,
+		mode:           FallbackModePrimary,
+	}
+	RecordFallbackMode(group, fs.mode)
+	return fs
+}
+
+// Mode returns the current mode
+func (fs *FallbackState) Mode() FallbackMode {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+	return fs.mode
+}
+
+// NeedsFallbacks returns whether the fallbacks may serve traffic soon, and should be kept up to date
+func (fs *FallbackState) NeedsFallbacks(healthyPrimaries int) bool {
+	return healthyPrimaries < fs.minPrimaries || fs.Mode() != FallbackModePrimary
+}
+
+// Update moves to the next mode given the number of healthy primaries, and returns it
+func (fs *FallbackState) Update(healthyPrimaries int, now time.Time) FallbackMode {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	healthy := healthyPrimaries >= fs.minPrimaries
+	switch fs.mode {
+	case FallbackModePrimary:
+		if !healthy {
+			fs.transition(FallbackModeFallback, healthyPrimaries)
+		}
+	case FallbackModeFallback:
+		if healthy {
+			fs.healthySince = now
+			fs.transition(FallbackModeRecovering, healthyPrimaries)
+		}
+	case FallbackModeRecovering:
+		if !healthy {
+			fs.transition(FallbackModeFallback, healthyPrimaries)
+		}
+	}
+	if fs.mode == FallbackModeRecovering && now.Sub(fs.healthySince) >= fs.failBackPeriod {
+		fs.transition(FallbackModePrimary, healthyPrimaries)
+	}
+	return fs.mode
+}
+
+func (fs *FallbackState) transition(to FallbackMode, healthyPrimaries int) {
+	from := fs.mode
+	fs.mode = to
+	RecordFallbackTransition(fs.group, from, to)
+
+	logFn := log.Info
+	if to == FallbackModeFallback {
+		logFn = log.Warn
+	}
+	logFn("backend group fallback mode changed",
+		"backend_group", fs.group,
+		"from", from,
+		"to", to,
+		"healthy_primaries", healthyPrimaries,
+		"min_primaries", fs.minPrimaries,
+		"fail_back_period", fs.failBackPeriod,
+	)
+}
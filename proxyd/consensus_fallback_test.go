@@ -0,0 +1,38 @@
+package proxyd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFallbackState(t *testing.T) {
+	now := time.Now()
+	fs := NewFallbackState("main", 2, time.Minute)
+	require.Equal(t, FallbackModePrimary, fs.Mode())
+	require.False(t, fs.NeedsFallbacks(2))
+	require.True(t, fs.NeedsFallbacks(1))
+
+	// falls back below the min primaries
+	require.Equal(t, FallbackModePrimary, fs.Update(2, now))
+	require.Equal(t, FallbackModeFallback, fs.Update(1, now))
+	require.True(t, fs.NeedsFallbacks(2))
+
+	// fails back once the primaries have been healthy for the period
+	require.Equal(t, FallbackModeRecovering, fs.Update(2, now))
+	require.Equal(t, FallbackModeRecovering, fs.Update(2, now.Add(30*time.Second)))
+
+	// flapping primaries restart the period
+	require.Equal(t, FallbackModeFallback, fs.Update(1, now.Add(40*time.Second)))
+	require.Equal(t, FallbackModeRecovering, fs.Update(2, now.Add(50*time.Second)))
+	require.Equal(t, FallbackModeRecovering, fs.Update(2, now.Add(time.Minute)))
+	require.Equal(t, FallbackModePrimary, fs.Update(2, now.Add(50*time.Second+time.Minute)))
+	require.False(t, fs.NeedsFallbacks(2))
+
+	// without a period, fails back as soon as the primaries are healthy
+	fs = NewFallbackState("main", 0, 0)
+	require.Equal(t, DefaultFallbackMinPrimaries, fs.minPrimaries)
+	require.Equal(t, FallbackModeFallback, fs.Update(0, now))
+	require.Equal(t, FallbackModePrimary, fs.Update(1, now))
+}
@@ -3,6 +3,8 @@ package proxyd
 import (
 	"context"
 	"fmt"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -20,20 +22,31 @@ const (
 type OnConsensusBroken func()
 
 // ConsensusPoller checks the consensus state for each member of a BackendGroup
-// resolves the highest common block for multiple nodes, and reconciles the consensus
-// in case of block hash divergence to minimize re-orgs
+// resolves the consensus block for multiple nodes with a ConsensusStrategy, and reconciles
+// the consensus in case of block hash divergence to minimize re-orgs
 type ConsensusPoller struct {
 	ctx        context.Context
 	cancelFunc context.CancelFunc
 	listeners  []OnConsensusBroken
 
 	backendGroup      *BackendGroup
+	backendStatesMux  sync.RWMutex
 	backendState      map[*Backend]*backendState
 	consensusGroupMux sync.Mutex
 	consensusGroup    []*Backend
 
 	tracker      ConsensusTracker
 	asyncHandler ConsensusAsyncHandler
+	strategy     ConsensusStrategy
+	reorgs       *ReorgTracker
+
+	// head is the last consensus block resolved by this poller
+	headMux sync.Mutex
+	head    ReorgHead
+
+	snapshotStore    ConsensusSnapshotStore
+	snapshotInterval time.Duration
+	snapshotMaxAge   time.Duration
 
 	minPeerCount       uint64
 	banPeriod          time.Duration
@@ -41,6 +54,13 @@ type ConsensusPoller struct {
 	maxBlockLag        uint64
 	maxBlockRange      uint64
 	interval           time.Duration
+
+	// serveLagging lets healthy backends outside the consensus group serve the blocks they have
+	serveLagging bool
+
+	fallback               *FallbackState
+	fallbackMinPrimaries   int
+	fallbackFailBackPeriod time.Duration
 }
 
 type backendState struct {
@@ -49,16 +69,27 @@ type backendState struct {
 	latestBlockNumber    hexutil.Uint64
 	latestBlockHash      string
 	safeBlockNumber      hexutil.Uint64
+	safeBlockHash        string
 	finalizedBlockNumber hexutil.Uint64
+	finalizedBlockHash   string
 
 	peerCount uint64
 	inSync    bool
 
+	// checkedBlocks holds the block fetched by tag to compare its hash with the consensus group,
+	// so that it's only fetched again when the height to check changes
+	checkedBlocks map[string]checkedBlock
+
 	lastUpdate time.Time
 
 	bannedUntil time.Time
 }
 
+type checkedBlock struct {
+	number hexutil.Uint64
+	hash   string
+}
+
 func (bs *backendState) IsBanned() bool {
 	return time.Now().Before(bs.bannedUntil)
 }
@@ -74,6 +105,39 @@ func (cp *ConsensusPoller) GetConsensusGroup() []*Backend {
 	return g
 }
 
+// GetLaggingBackends returns the healthy backends out of the consensus group that already finalized
+// the given block, i.e. they are in sync but lagging behind the consensus `latest` block.
+// Only finalized blocks are served, as the backends may be out of the group for being on a fork.
+// It returns nil unless serving lagging backends is enabled
+func (cp *ConsensusPoller) GetLaggingBackends(block hexutil.Uint64) []*Backend {
+	if !cp.serveLagging || block > cp.GetFinalizedBlockNumber() {
+		return nil
+	}
+
+	cg := cp.GetConsensusGroup()
+	lagging := make([]*Backend, 0)
+	for _, be := range cp.backendGroup.Primaries() {
+		if slices.Contains(cg, be) {
+			continue
+		}
+		bs := cp.getBackendState(be)
+		if bs.IsBanned() || !bs.inSync || !be.IsHealthy() {
+			continue
+		}
+		if !be.skipPeerCountCheck && bs.peerCount < cp.minPeerCount {
+			continue
+		}
+		if bs.lastUpdate.Add(cp.maxUpdateThreshold).Before(time.Now()) {
+			continue
+		}
+		if bs.finalizedBlockNumber < block {
+			continue
+		}
+		lagging = append(lagging, be)
+	}
+	return lagging
+}
+
 // GetLatestBlockNumber returns the `latest` agreed block number in a consensus
 func (ct *ConsensusPoller) GetLatestBlockNumber() hexutil.Uint64 {
 	return ct.tracker.GetLatestBlockNumber()
@@ -91,12 +155,19 @@ func (ct *ConsensusPoller) GetFinalizedBlockNumber() hexutil.Uint64 {
 
 func (cp *ConsensusPoller) Shutdown() {
 	cp.asyncHandler.Shutdown()
+	cp.cancelFunc()
+	if cp.snapshotStore != nil {
+		cp.saveSnapshot(context.Background())
+	}
 }
 
 // ConsensusAsyncHandler controls the asynchronous polling mechanism, interval and shutdown
 type ConsensusAsyncHandler interface {
 	Init()
 	Shutdown()
+	// AddBackend and RemoveBackend start and stop polling a backend added to or removed from the group at runtime
+	AddBackend(be *Backend)
+	RemoveBackend(be *Backend)
 }
 
 // NoopAsyncHandler allows fine control updating the consensus
@@ -106,19 +177,25 @@ func NewNoopAsyncHandler() ConsensusAsyncHandler {
 	log.Warn("using NewNoopAsyncHandler")
 	return &NoopAsyncHandler{}
 }
-func (ah *NoopAsyncHandler) Init()     {}
-func (ah *NoopAsyncHandler) Shutdown() {}
+func (ah *NoopAsyncHandler) Init()                     {}
+func (ah *NoopAsyncHandler) Shutdown()                 {}
+func (ah *NoopAsyncHandler) AddBackend(be *Backend)    {}
+func (ah *NoopAsyncHandler) RemoveBackend(be *Backend) {}
 
 // PollerAsyncHandler asynchronously updates each individual backend and the group consensus
 type PollerAsyncHandler struct {
 	ctx context.Context
 	cp  *ConsensusPoller
+
+	pollersMux sync.Mutex
+	pollers    map[*Backend]context.CancelFunc
 }
 
 func NewPollerAsyncHandler(ctx context.Context, cp *ConsensusPoller) ConsensusAsyncHandler {
 	return &PollerAsyncHandler{
-		ctx: ctx,
-		cp:  cp,
+		ctx:     ctx,
+		cp:      cp,
+		pollers: make(map[*Backend]context.CancelFunc),
 	}
 }
 func (ah *PollerAsyncHandler) Init() {
@@ -126,63 +203,87 @@ func (ah *PollerAsyncHandler) Init() {
 	log.Info("total number of primary candidates", "primaries", len(ah.cp.backendGroup.Primaries()))
 	log.Info("total number of fallback candidates", "fallbacks", len(ah.cp.backendGroup.Fallbacks()))
 
-	for _, be := range ah.cp.backendGroup.Primaries() {
-		go func(be *Backend) {
-			for {
-				timer := time.NewTimer(ah.cp.interval)
-				ah.cp.UpdateBackend(ah.ctx, be)
-				select {
-				case <-timer.C:
-				case <-ah.ctx.Done():
-					timer.Stop()
-					return
-				}
-			}
-		}(be)
+	for _, be := range ah.cp.backendGroup.GetBackends() {
+		ah.AddBackend(be)
 	}
 
-	for _, be := range ah.cp.backendGroup.Fallbacks() {
-		go func(be *Backend) {
-			for {
-				timer := time.NewTimer(ah.cp.interval)
+	// create the group consensus poller
+	go func() {
+		for {
+			timer := time.NewTimer(ah.cp.interval)
+			log.Info("updating backend group consensus")
+			ah.cp.UpdateBackendGroupConsensus(ah.ctx)
 
-				healthyCandidates := ah.cp.FilterCandidates(ah.cp.backendGroup.Primaries())
+			select {
+			case <-timer.C:
+			case <-ah.ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+	}()
+}
+func (ah *PollerAsyncHandler) Shutdown() {
+	ah.cp.cancelFunc()
+}
 
-				log.Info("number of healthy primary candidates", "healthy_candidates", len(healthyCandidates))
-				if len(healthyCandidates) == 0 {
-					log.Debug("zero healthy candidates, querying fallback backend",
-						"backend_name", be.Name)
-					ah.cp.UpdateBackend(ah.ctx, be)
-				}
+// AddBackend starts the poller of a backend, fallbacks are only polled when the group may use them
+func (ah *PollerAsyncHandler) AddBackend(be *Backend) {
+	ah.pollersMux.Lock()
+	defer ah.pollersMux.Unlock()
+	if _, ok := ah.pollers[be]; ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(ah.ctx)
+	ah.pollers[be] = cancel
 
+	if !ah.cp.backendGroup.IsFallback(be) {
+		go func() {
+			for {
+				timer := time.NewTimer(ah.cp.interval)
+				ah.cp.UpdateBackend(ctx, be)
 				select {
 				case <-timer.C:
-				case <-ah.ctx.Done():
+				case <-ctx.Done():
 					timer.Stop()
 					return
 				}
 			}
-		}(be)
+		}()
+		return
 	}
 
-	// create the group consensus poller
 	go func() {
 		for {
 			timer := time.NewTimer(ah.cp.interval)
-			log.Info("updating backend group consensus")
-			ah.cp.UpdateBackendGroupConsensus(ah.ctx)
+
+			healthyCandidates := ah.cp.FilterCandidates(ah.cp.backendGroup.Primaries())
+
+			log.Info("number of healthy primary candidates", "healthy_candidates", len(healthyCandidates))
+			if ah.cp.fallback.NeedsFallbacks(len(healthyCandidates)) {
+				log.Debug("not enough healthy candidates, querying fallback backend",
+					"backend_name", be.Name)
+				ah.cp.UpdateBackend(ctx, be)
+			}
 
 			select {
 			case <-timer.C:
-			case <-ah.ctx.Done():
+			case <-ctx.Done():
 				timer.Stop()
 				return
 			}
 		}
 	}()
 }
-func (ah *PollerAsyncHandler) Shutdown() {
-	ah.cp.cancelFunc()
+
+// RemoveBackend stops the poller of a backend
+func (ah *PollerAsyncHandler) RemoveBackend(be *Backend) {
+	ah.pollersMux.Lock()
+	defer ah.pollersMux.Unlock()
+	if cancel, ok := ah.pollers[be]; ok {
+		cancel()
+		delete(ah.pollers, be)
+	}
 }
 
 type ConsensusOpt func(cp *ConsensusPoller)
@@ -199,6 +300,36 @@ func WithAsyncHandler(asyncHandler ConsensusAsyncHandler) ConsensusOpt {
 	}
 }
 
+func WithStrategy(strategy ConsensusStrategy) ConsensusOpt {
+	return func(cp *ConsensusPoller) {
+		cp.strategy = strategy
+	}
+}
+
+func WithReorgTracker(reorgs *ReorgTracker) ConsensusOpt {
+	return func(cp *ConsensusPoller) {
+		cp.reorgs = reorgs
+	}
+}
+
+func WithSnapshotStore(store ConsensusSnapshotStore) ConsensusOpt {
+	return func(cp *ConsensusPoller) {
+		cp.snapshotStore = store
+	}
+}
+
+func WithSnapshotInterval(interval time.Duration) ConsensusOpt {
+	return func(cp *ConsensusPoller) {
+		cp.snapshotInterval = interval
+	}
+}
+
+func WithSnapshotMaxAge(maxAge time.Duration) ConsensusOpt {
+	return func(cp *ConsensusPoller) {
+		cp.snapshotMaxAge = maxAge
+	}
+}
+
 func WithListener(listener OnConsensusBroken) ConsensusOpt {
 	return func(cp *ConsensusPoller) {
 		cp.AddListener(listener)
@@ -243,6 +374,24 @@ func WithMinPeerCount(minPeerCount uint64) ConsensusOpt {
 	}
 }
 
+func WithServeLagging(serveLagging bool) ConsensusOpt {
+	return func(cp *ConsensusPoller) {
+		cp.serveLagging = serveLagging
+	}
+}
+
+func WithFallbackMinPrimaries(minPrimaries int) ConsensusOpt {
+	return func(cp *ConsensusPoller) {
+		cp.fallbackMinPrimaries = minPrimaries
+	}
+}
+
+func WithFallbackFailBackPeriod(failBackPeriod time.Duration) ConsensusOpt {
+	return func(cp *ConsensusPoller) {
+		cp.fallbackFailBackPeriod = failBackPeriod
+	}
+}
+
 func WithPollerInterval(interval time.Duration) ConsensusOpt {
 	return func(cp *ConsensusPoller) {
 		cp.interval = interval
@@ -252,7 +401,7 @@ func WithPollerInterval(interval time.Duration) ConsensusOpt {
 func NewConsensusPoller(bg *BackendGroup, opts ...ConsensusOpt) *ConsensusPoller {
 	ctx, cancelFunc := context.WithCancel(context.Background())
 
-	state := make(map[*Backend]*backendState, len(bg.Backends))
+	state := make(map[*Backend]*backendState, len(bg.GetBackends()))
 
 	cp := &ConsensusPoller{
 		ctx:          ctx,
@@ -265,6 +414,8 @@ func NewConsensusPoller(bg *BackendGroup, opts ...ConsensusOpt) *ConsensusPoller
 		maxBlockLag:        8, // 8*12 seconds = 96 seconds ~ 1.6 minutes
 		minPeerCount:       3,
 		interval:           DefaultPollerInterval,
+		snapshotInterval:   defaultConsensusSnapshotInterval,
+		snapshotMaxAge:     defaultConsensusSnapshotMaxAge,
 	}
 
 	for _, opt := range opts {
@@ -275,16 +426,76 @@ func NewConsensusPoller(bg *BackendGroup, opts ...ConsensusOpt) *ConsensusPoller
 		cp.tracker = NewInMemoryConsensusTracker()
 	}
 
+	if cp.strategy == nil {
+		cp.strategy = &LowestCommonStrategy{}
+	}
+	// the quorum walks back at most as far as a candidate may lag
+	if quorum, ok := cp.strategy.(*QuorumStrategy); ok && quorum.MaxDepth == 0 {
+		quorum.MaxDepth = cp.maxBlockLag
+	}
+
+	cp.fallback = NewFallbackState(bg.Name, cp.fallbackMinPrimaries, cp.fallbackFailBackPeriod)
+
 	if cp.asyncHandler == nil {
 		cp.asyncHandler = NewPollerAsyncHandler(ctx, cp)
 	}
 
 	cp.Reset()
+	if cp.snapshotStore != nil {
+		// restored before polling, so that the first consensus update starts from the snapshot
+		RecordConsensusSnapshotRestore(bg, cp.restoreSnapshot(ctx))
+		go cp.snapshotLoop()
+	}
 	cp.asyncHandler.Init()
 
 	return cp
 }
 
+// AddBackend starts tracking a backend added to the group at runtime.
+// The backend joins the consensus group once it is a candidate in the next consensus update.
+func (cp *ConsensusPoller) AddBackend(be *Backend) {
+	cp.backendStatesMux.Lock()
+	if _, ok := cp.backendState[be]; !ok {
+		cp.backendState[be] = &backendState{}
+	}
+	cp.backendStatesMux.Unlock()
+
+	RecordBackendGroupFallbacks(cp.backendGroup, be.Name, cp.backendGroup.IsFallback(be))
+	cp.asyncHandler.AddBackend(be)
+}
+
+// RemoveBackend stops tracking a backend removed from the group at runtime,
+// and drops it from the consensus group without waiting for the next consensus update
+func (cp *ConsensusPoller) RemoveBackend(be *Backend) {
+	cp.asyncHandler.RemoveBackend(be)
+
+	cp.consensusGroupMux.Lock()
+	group := make([]*Backend, 0, len(cp.consensusGroup))
+	for _, member := range cp.consensusGroup {
+		if member != be {
+			group = append(group, member)
+		}
+	}
+	cp.consensusGroup = group
+	cp.consensusGroupMux.Unlock()
+
+	cp.backendStatesMux.Lock()
+	delete(cp.backendState, be)
+	cp.backendStatesMux.Unlock()
+}
+
+// stateOf returns the live state of a backend. A backend removed while it was being
+// polled gets a detached state, so that late updates are discarded.
+func (cp *ConsensusPoller) stateOf(be *Backend) *backendState {
+	cp.backendStatesMux.RLock()
+	defer cp.backendStatesMux.RUnlock()
+	bs, ok := cp.backendState[be]
+	if !ok {
+		return &backendState{}
+	}
+	return bs
+}
+
 // UpdateBackend refreshes the consensus state of a single backend
 func (cp *ConsensusPoller) UpdateBackend(ctx context.Context, be *Backend) {
 	bs := cp.getBackendState(be)
@@ -322,12 +533,12 @@ func (cp *ConsensusPoller) UpdateBackend(ctx context.Context, be *Backend) {
 		log.Warn("error updating backend - latest block", "name", be.Name, "err", err)
 	}
 
-	safeBlockNumber, _, err := cp.fetchBlock(ctx, be, "safe")
+	safeBlockNumber, safeBlockHash, err := cp.fetchBlock(ctx, be, "safe")
 	if err != nil {
 		log.Warn("error updating backend - safe block", "name", be.Name, "err", err)
 	}
 
-	finalizedBlockNumber, _, err := cp.fetchBlock(ctx, be, "finalized")
+	finalizedBlockNumber, finalizedBlockHash, err := cp.fetchBlock(ctx, be, "finalized")
 	if err != nil {
 		log.Warn("error updating backend - finalized block", "name", be.Name, "err", err)
 	}
@@ -336,7 +547,8 @@ func (cp *ConsensusPoller) UpdateBackend(ctx context.Context, be *Backend) {
 
 	changed := cp.setBackendState(be, peerCount, inSync,
 		latestBlockNumber, latestBlockHash,
-		safeBlockNumber, finalizedBlockNumber)
+		safeBlockNumber, safeBlockHash,
+		finalizedBlockNumber, finalizedBlockHash)
 
 	RecordBackendLatestBlock(be, latestBlockNumber)
 	RecordBackendSafeBlock(be, safeBlockNumber)
@@ -350,7 +562,9 @@ func (cp *ConsensusPoller) UpdateBackend(ctx context.Context, be *Backend) {
 			"latestBlockNumber", latestBlockNumber,
 			"latestBlockHash", latestBlockHash,
 			"safeBlockNumber", safeBlockNumber,
+			"safeBlockHash", safeBlockHash,
 			"finalizedBlockNumber", finalizedBlockNumber,
+			"finalizedBlockHash", finalizedBlockHash,
 			"lastUpdate", bs.lastUpdate)
 	}
 
@@ -397,75 +611,21 @@ func (cp *ConsensusPoller) UpdateBackendGroupConsensus(ctx context.Context) {
 	// get the candidates for the consensus group
 	candidates := cp.getConsensusCandidates()
 
-	// update the lowest latest block number and hash
-	//        the lowest safe block number
-	//        the lowest finalized block number
-	var lowestLatestBlock hexutil.Uint64
-	var lowestLatestBlockHash string
-	var lowestFinalizedBlock hexutil.Uint64
-	var lowestSafeBlock hexutil.Uint64
-	for _, bs := range candidates {
-		if lowestLatestBlock == 0 || bs.latestBlockNumber < lowestLatestBlock {
-			lowestLatestBlock = bs.latestBlockNumber
-			lowestLatestBlockHash = bs.latestBlockHash
-		}
-		if lowestFinalizedBlock == 0 || bs.finalizedBlockNumber < lowestFinalizedBlock {
-			lowestFinalizedBlock = bs.finalizedBlockNumber
-		}
-		if lowestSafeBlock == 0 || bs.safeBlockNumber < lowestSafeBlock {
-			lowestSafeBlock = bs.safeBlockNumber
-		}
+	// resolve the consensus block and the candidates agreeing on it
+	result := cp.strategy.Resolve(ctx, cp.fetchBlock, candidates, currentConsensusBlockNumber)
+	if result == nil {
+		return
 	}
+	proposedBlock := result.BlockNumber
+	proposedBlockHash := result.BlockHash
+	broken := result.Broken
 
-	// find the proposed block among the candidates
-	// the proposed block needs have the same hash in the entire consensus group
-	proposedBlock := lowestLatestBlock
-	proposedBlockHash := lowestLatestBlockHash
-	hasConsensus := false
-	broken := false
+	// leave out the members following a different safe or finalized chain
+	members := cp.filterBlockTagConflicts(ctx, result.Members)
 
-	if lowestLatestBlock > currentConsensusBlockNumber {
-		log.Debug("validating consensus on block", "lowestLatestBlock", lowestLatestBlock)
-	}
-
-	// if there is a block to propose, check if it is the same in all backends
-	if proposedBlock > 0 {
-		for !hasConsensus {
-			allAgreed := true
-			for be := range candidates {
-				actualBlockNumber, actualBlockHash, err := cp.fetchBlock(ctx, be, proposedBlock.String())
-				if err != nil {
-					log.Warn("error updating backend", "name", be.Name, "err", err)
-					continue
-				}
-				if proposedBlockHash == "" {
-					proposedBlockHash = actualBlockHash
-				}
-				blocksDontMatch := (actualBlockNumber != proposedBlock) || (actualBlockHash != proposedBlockHash)
-				if blocksDontMatch {
-					if currentConsensusBlockNumber >= actualBlockNumber {
-						log.Warn("backend broke consensus",
-							"name", be.Name,
-							"actualBlockNumber", actualBlockNumber,
-							"actualBlockHash", actualBlockHash,
-							"proposedBlock", proposedBlock,
-							"proposedBlockHash", proposedBlockHash)
-						broken = true
-					}
-					allAgreed = false
-					break
-				}
-			}
-			if allAgreed {
-				hasConsensus = true
-			} else {
-				// walk one block behind and try again
-				proposedBlock -= 1
-				proposedBlockHash = ""
-				log.Debug("no consensus, now trying", "block:", proposedBlock)
-			}
-		}
-	}
+	// update the lowest safe block number
+	//        the lowest finalized block number
+	lowestSafeBlock, lowestFinalizedBlock := lowestBlockTags(members)
 
 	if broken {
 		// propagate event to other interested parts, such as cache invalidator
@@ -476,19 +636,25 @@ func (cp *ConsensusPoller) UpdateBackendGroupConsensus(ctx context.Context) {
 			"currentConsensusBlockNumber", currentConsensusBlockNumber,
 			"proposedBlock", proposedBlock,
 			"proposedBlockHash", proposedBlockHash)
+		cp.recordReorg(currentConsensusBlockNumber, proposedBlock, proposedBlockHash, result.Dissenters)
 	}
 
+	cp.headMux.Lock()
+	cp.head = ReorgHead{Number: proposedBlock, Hash: proposedBlockHash}
+	cp.headMux.Unlock()
+
 	// update tracker
 	cp.tracker.SetLatestBlockNumber(proposedBlock)
 	cp.tracker.SetSafeBlockNumber(lowestSafeBlock)
 	cp.tracker.SetFinalizedBlockNumber(lowestFinalizedBlock)
 
 	// update consensus group
-	group := make([]*Backend, 0, len(candidates))
-	consensusBackendsNames := make([]string, 0, len(candidates))
-	filteredBackendsNames := make([]string, 0, len(cp.backendGroup.Backends))
-	for _, be := range cp.backendGroup.Backends {
-		_, exist := candidates[be]
+	group := make([]*Backend, 0, len(members))
+	consensusBackendsNames := make([]string, 0, len(members))
+	backends := cp.backendGroup.GetBackends()
+	filteredBackendsNames := make([]string, 0, len(backends))
+	for _, be := range backends {
+		_, exist := members[be]
 		if exist {
 			group = append(group, be)
 			consensusBackendsNames = append(consensusBackendsNames, be.Name)
@@ -507,7 +673,7 @@ func (cp *ConsensusPoller) UpdateBackendGroupConsensus(ctx context.Context) {
 
 	RecordGroupConsensusCount(cp.backendGroup, len(group))
 	RecordGroupConsensusFilteredCount(cp.backendGroup, len(filteredBackendsNames))
-	RecordGroupTotalCount(cp.backendGroup, len(cp.backendGroup.Backends))
+	RecordGroupTotalCount(cp.backendGroup, len(backends))
 
 	log.Debug("group state",
 		"proposedBlock", proposedBlock,
@@ -515,9 +681,166 @@ func (cp *ConsensusPoller) UpdateBackendGroupConsensus(ctx context.Context) {
 		"filteredBackends", strings.Join(filteredBackendsNames, ", "))
 }
 
+// recordReorg records a consensus break as a reorg event from the current consensus block
+func (cp *ConsensusPoller) recordReorg(currentConsensusBlockNumber hexutil.Uint64,
+	proposedBlock hexutil.Uint64, proposedBlockHash string, dissenters []*Backend) {
+	if cp.reorgs == nil {
+		return
+	}
+
+	oldHead := ReorgHead{Number: currentConsensusBlockNumber}
+	cp.headMux.Lock()
+	// the hash is unknown when the current block was set by another instance
+	if cp.head.Number == currentConsensusBlockNumber {
+		oldHead.Hash = cp.head.Hash
+	}
+	cp.headMux.Unlock()
+
+	// blocks above the new head are replaced, a reorg replaces at least the old head
+	depth := uint64(1)
+	if currentConsensusBlockNumber > proposedBlock {
+		depth = uint64(currentConsensusBlockNumber - proposedBlock)
+	}
+
+	backends := make([]string, 0, len(dissenters))
+	for _, be := range dissenters {
+		backends = append(backends, be.Name)
+	}
+	sort.Strings(backends)
+
+	cp.reorgs.Record(&ReorgEvent{
+		BackendGroup: cp.backendGroup.Name,
+		Time:         time.Now(),
+		OldHead:      oldHead,
+		NewHead:      ReorgHead{Number: proposedBlock, Hash: proposedBlockHash},
+		Depth:        depth,
+		Backends:     backends,
+	})
+}
+
+// lowestBlockTags returns the lowest safe and finalized block numbers of the backends
+func lowestBlockTags(states map[*Backend]*backendState) (lowestSafeBlock hexutil.Uint64, lowestFinalizedBlock hexutil.Uint64) {
+	for _, bs := range states {
+		if lowestFinalizedBlock == 0 || bs.finalizedBlockNumber < lowestFinalizedBlock {
+			lowestFinalizedBlock = bs.finalizedBlockNumber
+		}
+		if lowestSafeBlock == 0 || bs.safeBlockNumber < lowestSafeBlock {
+			lowestSafeBlock = bs.safeBlockNumber
+		}
+	}
+	return
+}
+
+// filterBlockTagConflicts checks that the members agree on the hashes of the lowest finalized
+// and safe blocks of the group. The hash held by most members is the one of the group.
+// Members with a conflicting finalized hash are banned, since they follow a different
+// finalized chain, members with a conflicting safe hash are only left out of the group.
+func (cp *ConsensusPoller) filterBlockTagConflicts(ctx context.Context, members map[*Backend]*backendState) map[*Backend]*backendState {
+	filtered := make(map[*Backend]*backendState, len(members))
+	for be, bs := range members {
+		filtered[be] = bs
+	}
+
+	_, lowestFinalizedBlock := lowestBlockTags(filtered)
+	for be, hash := range cp.blockHashConflicts(ctx, filtered, "finalized", lowestFinalizedBlock) {
+		log.Error("CRITICAL: backend finalized block hash conflicts with the consensus group, banning it",
+			"backend_group", cp.backendGroup.Name,
+			"name", be.Name,
+			"finalizedBlockNumber", lowestFinalizedBlock,
+			"finalizedBlockHash", hash)
+		RecordConsensusFinalizedHashMismatch(cp.backendGroup, be)
+		cp.Ban(be)
+		if !be.forcedCandidate {
+			delete(filtered, be)
+		}
+	}
+
+	lowestSafeBlock, _ := lowestBlockTags(filtered)
+	for be, hash := range cp.blockHashConflicts(ctx, filtered, "safe", lowestSafeBlock) {
+		log.Warn("backend safe block hash conflicts with the consensus group",
+			"backend_group", cp.backendGroup.Name,
+			"name", be.Name,
+			"safeBlockNumber", lowestSafeBlock,
+			"safeBlockHash", hash)
+		if !be.forcedCandidate {
+			delete(filtered, be)
+		}
+	}
+
+	return filtered
+}
+
+// blockHashConflicts returns the members whose hash of the block at the given height differs from
+// the hash held by most members, with their hash. Members ahead of the height are asked for
+// the block once per height, members that can't be checked are given the benefit of the doubt.
+// There are no conflicts when no hash is held by a strict plurality of the members.
+func (cp *ConsensusPoller) blockHashConflicts(ctx context.Context, members map[*Backend]*backendState,
+	tag string, height hexutil.Uint64) map[*Backend]string {
+	conflicts := make(map[*Backend]string)
+	if height == 0 {
+		return conflicts
+	}
+
+	hashes := make(map[*Backend]string, len(members))
+	votes := make(map[string]int)
+	for be, bs := range members {
+		hash := bs.safeBlockHash
+		number := bs.safeBlockNumber
+		if tag == "finalized" {
+			hash = bs.finalizedBlockHash
+			number = bs.finalizedBlockNumber
+		}
+		if number != height {
+			checked, ok := cp.getCheckedBlockHash(be, tag, height)
+			if !ok {
+				actualBlockNumber, actualBlockHash, err := cp.fetchBlock(ctx, be, height.String())
+				if err != nil || actualBlockNumber != height {
+					log.Warn("error checking backend block hash", "name", be.Name, "block", height, "err", err)
+					continue
+				}
+				checked = actualBlockHash
+				cp.setCheckedBlockHash(be, tag, height, checked)
+			}
+			hash = checked
+		}
+		if hash == "" {
+			continue
+		}
+		hashes[be] = hash
+		votes[hash]++
+	}
+
+	var groupHash string
+	tied := false
+	for hash, count := range votes {
+		if count > votes[groupHash] {
+			groupHash = hash
+			tied = false
+		} else if count == votes[groupHash] {
+			tied = true
+		}
+	}
+
+	for be, hash := range hashes {
+		mismatch := !tied && hash != groupHash
+		RecordConsensusBackendBlockHashMismatch(be, tag, mismatch)
+		if mismatch {
+			conflicts[be] = hash
+		}
+	}
+	if tied {
+		log.Error("CRITICAL: no agreement on block hash in the consensus group",
+			"backend_group", cp.backendGroup.Name,
+			"tag", tag,
+			"block", height,
+			"hashes", len(votes))
+	}
+	return conflicts
+}
+
 // IsBanned checks if a specific backend is banned
 func (cp *ConsensusPoller) IsBanned(be *Backend) bool {
-	bs := cp.backendState[be]
+	bs := cp.stateOf(be)
 	defer bs.backendStateMux.Unlock()
 	bs.backendStateMux.Lock()
 	return bs.IsBanned()
@@ -529,7 +852,7 @@ func (cp *ConsensusPoller) Ban(be *Backend) {
 		return
 	}
 
-	bs := cp.backendState[be]
+	bs := cp.stateOf(be)
 	defer bs.backendStateMux.Unlock()
 	bs.backendStateMux.Lock()
 	bs.bannedUntil = time.Now().Add(cp.banPeriod)
@@ -542,7 +865,7 @@ func (cp *ConsensusPoller) Ban(be *Backend) {
 
 // Unban removes any bans from the backends
 func (cp *ConsensusPoller) Unban(be *Backend) {
-	bs := cp.backendState[be]
+	bs := cp.stateOf(be)
 	defer bs.backendStateMux.Unlock()
 	bs.backendStateMux.Lock()
 	bs.bannedUntil = time.Now().Add(-10 * time.Hour)
@@ -550,7 +873,9 @@ func (cp *ConsensusPoller) Unban(be *Backend) {
 
 // Reset reset all backend states
 func (cp *ConsensusPoller) Reset() {
-	for _, be := range cp.backendGroup.Backends {
+	cp.backendStatesMux.Lock()
+	defer cp.backendStatesMux.Unlock()
+	for _, be := range cp.backendGroup.GetBackends() {
 		cp.backendState[be] = &backendState{}
 	}
 }
@@ -620,7 +945,7 @@ func (cp *ConsensusPoller) isInSync(ctx context.Context, be *Backend) (result bo
 
 // getBackendState creates a copy of backend state so that the caller can use it without locking
 func (cp *ConsensusPoller) getBackendState(be *Backend) *backendState {
-	bs := cp.backendState[be]
+	bs := cp.stateOf(be)
 	defer bs.backendStateMux.Unlock()
 	bs.backendStateMux.Lock()
 
@@ -628,7 +953,9 @@ func (cp *ConsensusPoller) getBackendState(be *Backend) *backendState {
 		latestBlockNumber:    bs.latestBlockNumber,
 		latestBlockHash:      bs.latestBlockHash,
 		safeBlockNumber:      bs.safeBlockNumber,
+		safeBlockHash:        bs.safeBlockHash,
 		finalizedBlockNumber: bs.finalizedBlockNumber,
+		finalizedBlockHash:   bs.finalizedBlockHash,
 		peerCount:            bs.peerCount,
 		inSync:               bs.inSync,
 		lastUpdate:           bs.lastUpdate,
@@ -637,17 +964,39 @@ func (cp *ConsensusPoller) getBackendState(be *Backend) *backendState {
 }
 
 func (cp *ConsensusPoller) GetLastUpdate(be *Backend) time.Time {
-	bs := cp.backendState[be]
+	bs := cp.stateOf(be)
 	defer bs.backendStateMux.Unlock()
 	bs.backendStateMux.Lock()
 	return bs.lastUpdate
 }
 
+// getCheckedBlockHash returns the hash of the block at the height, if it was fetched by a previous check of the tag
+func (cp *ConsensusPoller) getCheckedBlockHash(be *Backend, tag string, height hexutil.Uint64) (string, bool) {
+	bs := cp.stateOf(be)
+	defer bs.backendStateMux.Unlock()
+	bs.backendStateMux.Lock()
+	checked, ok := bs.checkedBlocks[tag]
+	if !ok || checked.number != height {
+		return "", false
+	}
+	return checked.hash, true
+}
+
+func (cp *ConsensusPoller) setCheckedBlockHash(be *Backend, tag string, height hexutil.Uint64, hash string) {
+	bs := cp.stateOf(be)
+	defer bs.backendStateMux.Unlock()
+	bs.backendStateMux.Lock()
+	if bs.checkedBlocks == nil {
+		bs.checkedBlocks = make(map[string]checkedBlock, 2)
+	}
+	bs.checkedBlocks[tag] = checkedBlock{number: height, hash: hash}
+}
+
 func (cp *ConsensusPoller) setBackendState(be *Backend, peerCount uint64, inSync bool,
 	latestBlockNumber hexutil.Uint64, latestBlockHash string,
-	safeBlockNumber hexutil.Uint64,
-	finalizedBlockNumber hexutil.Uint64) bool {
-	bs := cp.backendState[be]
+	safeBlockNumber hexutil.Uint64, safeBlockHash string,
+	finalizedBlockNumber hexutil.Uint64, finalizedBlockHash string) bool {
+	bs := cp.stateOf(be)
 	bs.backendStateMux.Lock()
 	changed := bs.latestBlockHash != latestBlockHash
 	bs.peerCount = peerCount
@@ -655,24 +1004,40 @@ func (cp *ConsensusPoller) setBackendState(be *Backend, peerCount uint64, inSync
 	bs.latestBlockNumber = latestBlockNumber
 	bs.latestBlockHash = latestBlockHash
 	bs.finalizedBlockNumber = finalizedBlockNumber
+	bs.finalizedBlockHash = finalizedBlockHash
 	bs.safeBlockNumber = safeBlockNumber
+	bs.safeBlockHash = safeBlockHash
 	bs.lastUpdate = time.Now()
 	bs.backendStateMux.Unlock()
 	return changed
 }
 
 // getConsensusCandidates will search for candidates in the primary group,
-// if there are none it will search for candidates in he fallback group
+// or in the fallback group when the fallback state says so
 func (cp *ConsensusPoller) getConsensusCandidates() map[*Backend]*backendState {
 
 	healthyPrimaries := cp.FilterCandidates(cp.backendGroup.Primaries())
 
 	RecordHealthyCandidates(cp.backendGroup, len(healthyPrimaries))
-	if len(healthyPrimaries) > 0 {
+	fallbacks := cp.backendGroup.Fallbacks()
+	if len(fallbacks) == 0 {
 		return healthyPrimaries
 	}
+	if cp.fallback.Update(len(healthyPrimaries), time.Now()) == FallbackModePrimary {
+		return healthyPrimaries
+	}
+
+	// healthy primaries still beat no candidate at all
+	healthyFallbacks := cp.FilterCandidates(fallbacks)
+	if len(healthyFallbacks) == 0 {
+		return healthyPrimaries
+	}
+	return healthyFallbacks
+}
 
-	return cp.FilterCandidates(cp.backendGroup.Fallbacks())
+// GetFallbackMode returns whether the group is served by its primaries or its fallbacks
+func (cp *ConsensusPoller) GetFallbackMode() FallbackMode {
+	return cp.fallback.Mode()
 }
 
 // filterCandidates find out what backends are the candidates to be in the consensus group
@@ -687,7 +1052,7 @@ func (cp *ConsensusPoller) getConsensusCandidates() map[*Backend]*backendState {
 //   - not lagging latest block
 func (cp *ConsensusPoller) FilterCandidates(backends []*Backend) map[*Backend]*backendState {
 
-	candidates := make(map[*Backend]*backendState, len(cp.backendGroup.Backends))
+	candidates := make(map[*Backend]*backendState, len(backends))
 
 	for _, be := range backends {
 
@@ -0,0 +1,312 @@
+package proxyd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	ConsensusSnapshotTypeFile  = "file"
+	ConsensusSnapshotTypeRedis = "redis"
+
+	defaultConsensusSnapshotInterval = 10 * time.Second
+	defaultConsensusSnapshotMaxAge   = 5 * time.Minute
+	consensusSnapshotTimeout         = 5 * time.Second
+
+	ConsensusSnapshotRestored   = "restored"
+	ConsensusSnapshotMissing    = "missing"
+	ConsensusSnapshotStale      = "stale"
+	ConsensusSnapshotUnverified = "unverified"
+	ConsensusSnapshotError      = "error"
+)
+
+// ConsensusSnapshot is the state of a ConsensusPoller, saved so that a restarted instance
+// doesn't start from scratch
+type ConsensusSnapshot struct {
+	BackendGroup         string                           `json:"backend_group"`
+	Time                 time.Time                        `json:"time"`
+	LatestBlockNumber    hexutil.Uint64                   `json:"latest_block_number"`
+	LatestBlockHash      string                           `json:"latest_block_hash"`
+	SafeBlockNumber      hexutil.Uint64                   `json:"safe_block_number"`
+	FinalizedBlockNumber hexutil.Uint64                   `json:"finalized_block_number"`
+	Backends             map[string]*BackendStateSnapshot `json:"backends"`
+}
+
+// BackendStateSnapshot is the last known consensus state of a backend
+type BackendStateSnapshot struct {
+	LatestBlockNumber    hexutil.Uint64 `json:"latest_block_number"`
+	LatestBlockHash      string         `json:"latest_block_hash"`
+	SafeBlockNumber      hexutil.Uint64 `json:"safe_block_number"`
+	SafeBlockHash        string         `json:"safe_block_hash"`
+	FinalizedBlockNumber hexutil.Uint64 `json:"finalized_block_number"`
+	FinalizedBlockHash   string         `json:"finalized_block_hash"`
+	PeerCount            uint64         `json:"peer_count"`
+	InSync               bool           `json:"in_sync"`
+	LastUpdate           time.Time      `json:"last_update"`
+	BannedUntil          time.Time      `json:"banned_until"`
+}
+
+// ConsensusSnapshotStore saves and loads the snapshot of a backend group
+type ConsensusSnapshotStore interface {
+	// Load returns nil when there is no snapshot
+	Load(ctx context.Context) (*ConsensusSnapshot, error)
+	Save(ctx context.Context, snapshot *ConsensusSnapshot) error
+}
+
+func NewConsensusSnapshotStore(cfg *ConsensusSnapshotConfig, groupName string, redisClient redis.UniversalClient, namespace string) (ConsensusSnapshotStore, error) {
+	switch cfg.Type {
+	case ConsensusSnapshotTypeFile:
+		if cfg.Path == "" {
+			return nil, errors.New("file consensus snapshot must set path")
+		}
+		return &FileConsensusSnapshotStore{path: filepath.Join(cfg.Path, groupName+".json")}, nil
+	case ConsensusSnapshotTypeRedis:
+		if redisClient == nil {
+			return nil, errors.New("must specify a Redis URL for a redis consensus snapshot")
+		}
+		maxAge := time.Duration(cfg.MaxAge)
+		if maxAge == 0 {
+			maxAge = defaultConsensusSnapshotMaxAge
+		}
+		return &RedisConsensusSnapshotStore{
+			client: redisClient,
+			key:    fmt.Sprintf("%s:consensus_snapshot:%s", namespace, groupName),
+			ttl:    maxAge,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown consensus snapshot type: %s", cfg.Type)
+	}
+}
+
+// FileConsensusSnapshotStore keeps the snapshot in a JSON file, replaced atomically on save
+type FileConsensusSnapshotStore struct {
+	path string
+}
+
+func (s *FileConsensusSnapshotStore) Load(ctx context.Context) (*ConsensusSnapshot, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	snapshot := new(ConsensusSnapshot)
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", s.path, err)
+	}
+	return snapshot, nil
+}
+
+func (s *FileConsensusSnapshotStore) Save(ctx context.Context, snapshot *ConsensusSnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// RedisConsensusSnapshotStore keeps the snapshot in a Redis key expiring after the max age
+type RedisConsensusSnapshotStore struct {
+	client redis.UniversalClient
+	key    string
+	ttl    time.Duration
+}
+
+func (s *RedisConsensusSnapshotStore) Load(ctx context.Context) (*ConsensusSnapshot, error) {
+	data, err := s.client.Get(ctx, s.key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		RecordRedisError("ConsensusSnapshotLoad")
+		return nil, err
+	}
+	snapshot := new(ConsensusSnapshot)
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", s.key, err)
+	}
+	return snapshot, nil
+}
+
+func (s *RedisConsensusSnapshotStore) Save(ctx context.Context, snapshot *ConsensusSnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	if err := s.client.Set(ctx, s.key, data, s.ttl).Err(); err != nil {
+		RecordRedisError("ConsensusSnapshotSave")
+		return err
+	}
+	return nil
+}
+
+// Snapshot captures the current state of the poller
+func (cp *ConsensusPoller) Snapshot() *ConsensusSnapshot {
+	cp.headMux.Lock()
+	head := cp.head
+	cp.headMux.Unlock()
+
+	snapshot := &ConsensusSnapshot{
+		BackendGroup:         cp.backendGroup.Name,
+		Time:                 time.Now(),
+		LatestBlockNumber:    cp.GetLatestBlockNumber(),
+		SafeBlockNumber:      cp.GetSafeBlockNumber(),
+		FinalizedBlockNumber: cp.GetFinalizedBlockNumber(),
+		Backends:             make(map[string]*BackendStateSnapshot),
+	}
+	if head.Number == snapshot.LatestBlockNumber {
+		snapshot.LatestBlockHash = head.Hash
+	}
+	for _, be := range cp.backendGroup.GetBackends() {
+		bs := cp.getBackendState(be)
+		snapshot.Backends[be.Name] = &BackendStateSnapshot{
+			LatestBlockNumber:    bs.latestBlockNumber,
+			LatestBlockHash:      bs.latestBlockHash,
+			SafeBlockNumber:      bs.safeBlockNumber,
+			SafeBlockHash:        bs.safeBlockHash,
+			FinalizedBlockNumber: bs.finalizedBlockNumber,
+			FinalizedBlockHash:   bs.finalizedBlockHash,
+			PeerCount:            bs.peerCount,
+			InSync:               bs.inSync,
+			LastUpdate:           bs.lastUpdate,
+			BannedUntil:          bs.bannedUntil,
+		}
+	}
+	return snapshot
+}
+
+func (cp *ConsensusPoller) saveSnapshot(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, consensusSnapshotTimeout)
+	defer cancel()
+	err := cp.snapshotStore.Save(ctx, cp.Snapshot())
+	if err != nil {
+		log.Warn("error saving consensus snapshot", "backend_group", cp.backendGroup.Name, "err", err)
+	}
+	RecordConsensusSnapshotSave(cp.backendGroup, err == nil)
+}
+
+func (cp *ConsensusPoller) snapshotLoop() {
+	ticker := time.NewTicker(cp.snapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			cp.saveSnapshot(cp.ctx)
+		case <-cp.ctx.Done():
+			return
+		}
+	}
+}
+
+// restoreSnapshot loads the last snapshot of the group. Bans are always restored, since they
+// don't depend on the chain. The consensus heights and the backend states are only restored
+// when the snapshot is recent, and its latest block is still on the chain of most of the backends
+// that answer, so that a new instance never serves blocks reorged while it was down.
+func (cp *ConsensusPoller) restoreSnapshot(ctx context.Context) string {
+	ctx, cancel := context.WithTimeout(ctx, consensusSnapshotTimeout)
+	defer cancel()
+
+	snapshot, err := cp.snapshotStore.Load(ctx)
+	if err != nil {
+		log.Warn("error loading consensus snapshot", "backend_group", cp.backendGroup.Name, "err", err)
+		return ConsensusSnapshotError
+	}
+	if snapshot == nil {
+		return ConsensusSnapshotMissing
+	}
+
+	backends := make(map[string]*Backend)
+	for _, be := range cp.backendGroup.GetBackends() {
+		backends[be.Name] = be
+	}
+	for name, bss := range snapshot.Backends {
+		if be, ok := backends[name]; ok && time.Now().Before(bss.BannedUntil) {
+			bs := cp.stateOf(be)
+			bs.backendStateMux.Lock()
+			bs.bannedUntil = bss.BannedUntil
+			bs.backendStateMux.Unlock()
+		}
+	}
+
+	if age := time.Since(snapshot.Time); age > cp.snapshotMaxAge {
+		log.Info("ignoring stale consensus snapshot", "backend_group", cp.backendGroup.Name, "age", age)
+		return ConsensusSnapshotStale
+	}
+	if !cp.verifySnapshot(ctx, snapshot) {
+		log.Warn("consensus snapshot is not on the chain of the backends, ignoring it",
+			"backend_group", cp.backendGroup.Name,
+			"latestBlockNumber", snapshot.LatestBlockNumber,
+			"latestBlockHash", snapshot.LatestBlockHash)
+		return ConsensusSnapshotUnverified
+	}
+
+	for name, bss := range snapshot.Backends {
+		be, ok := backends[name]
+		if !ok {
+			continue
+		}
+		bs := cp.stateOf(be)
+		bs.backendStateMux.Lock()
+		bs.latestBlockNumber = bss.LatestBlockNumber
+		bs.latestBlockHash = bss.LatestBlockHash
+		bs.safeBlockNumber = bss.SafeBlockNumber
+		bs.safeBlockHash = bss.SafeBlockHash
+		bs.finalizedBlockNumber = bss.FinalizedBlockNumber
+		bs.finalizedBlockHash = bss.FinalizedBlockHash
+		bs.peerCount = bss.PeerCount
+		bs.inSync = bss.InSync
+		bs.lastUpdate = bss.LastUpdate
+		bs.backendStateMux.Unlock()
+	}
+
+	cp.tracker.SetLatestBlockNumber(snapshot.LatestBlockNumber)
+	cp.tracker.SetSafeBlockNumber(snapshot.SafeBlockNumber)
+	cp.tracker.SetFinalizedBlockNumber(snapshot.FinalizedBlockNumber)
+	cp.headMux.Lock()
+	cp.head = ReorgHead{Number: snapshot.LatestBlockNumber, Hash: snapshot.LatestBlockHash}
+	cp.headMux.Unlock()
+
+	log.Info("restored consensus snapshot",
+		"backend_group", cp.backendGroup.Name,
+		"age", time.Since(snapshot.Time),
+		"latestBlockNumber", snapshot.LatestBlockNumber)
+	return ConsensusSnapshotRestored
+}
+
+// verifySnapshot checks that most of the backends answering have the latest block of the snapshot
+func (cp *ConsensusPoller) verifySnapshot(ctx context.Context, snapshot *ConsensusSnapshot) bool {
+	if snapshot.LatestBlockNumber == 0 || snapshot.LatestBlockHash == "" {
+		return false
+	}
+	agree, disagree := 0, 0
+	for _, be := range cp.backendGroup.GetBackends() {
+		if cp.IsBanned(be) {
+			continue
+		}
+		number, hash, err := cp.fetchBlock(ctx, be, snapshot.LatestBlockNumber.String())
+		if err != nil {
+			log.Warn("error verifying consensus snapshot", "name", be.Name, "err", err)
+			continue
+		}
+		if number == snapshot.LatestBlockNumber && hash == snapshot.LatestBlockHash {
+			agree++
+		} else {
+			disagree++
+		}
+	}
+	return agree > disagree
+}
@@ -0,0 +1,66 @@
+package proxyd
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsensusSnapshotStores(t *testing.T) {
+	redisServer, err := miniredis.Run()
+	require.NoError(t, err)
+	defer redisServer.Close()
+	redisClient, err := NewRedisClient(RedisConfig{URL: fmt.Sprintf("redis://127.0.0.1:%s", redisServer.Port())})
+	require.NoError(t, err)
+	defer redisClient.Close()
+
+	snapshot := &ConsensusSnapshot{
+		BackendGroup:         "main",
+		Time:                 time.Now().Round(0).UTC(),
+		LatestBlockNumber:    0x101,
+		LatestBlockHash:      "hash_0x101",
+		SafeBlockNumber:      0xe1,
+		FinalizedBlockNumber: 0xc1,
+		Backends: map[string]*BackendStateSnapshot{
+			"node1": {
+				LatestBlockNumber: 0x101,
+				LatestBlockHash:   "hash_0x101",
+				PeerCount:         10,
+				InSync:            true,
+				BannedUntil:       time.Now().Add(time.Minute).Round(0).UTC(),
+			},
+		},
+	}
+
+	for _, cfg := range []*ConsensusSnapshotConfig{
+		{Type: ConsensusSnapshotTypeFile, Path: t.TempDir()},
+		{Type: ConsensusSnapshotTypeRedis, MaxAge: TOMLDuration(time.Minute)},
+	} {
+		t.Run(cfg.Type, func(t *testing.T) {
+			store, err := NewConsensusSnapshotStore(cfg, "main", redisClient, "ns")
+			require.NoError(t, err)
+			ctx := context.Background()
+
+			loaded, err := store.Load(ctx)
+			require.NoError(t, err)
+			require.Nil(t, loaded)
+
+			require.NoError(t, store.Save(ctx, snapshot))
+			loaded, err = store.Load(ctx)
+			require.NoError(t, err)
+			require.Equal(t, snapshot, loaded)
+		})
+	}
+
+	// redis snapshots expire after the max age
+	require.Equal(t, time.Minute, redisServer.TTL("ns:consensus_snapshot:main"))
+
+	_, err = NewConsensusSnapshotStore(&ConsensusSnapshotConfig{Type: ConsensusSnapshotTypeRedis}, "main", nil, "")
+	require.Error(t, err)
+	_, err = NewConsensusSnapshotStore(&ConsensusSnapshotConfig{Type: ConsensusSnapshotTypeFile}, "main", nil, "")
+	require.Error(t, err)
+}
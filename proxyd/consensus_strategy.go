@@ -0,0 +1,284 @@
+package proxyd
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+const (
+	ConsensusStrategyLowestCommon = "lowest_common"
+	ConsensusStrategyQuorum       = "quorum"
+
+	DefaultConsensusQuorum = 2.0 / 3.0
+	// DefaultConsensusQuorumMaxDepth is the default number of blocks walked back to find a quorum
+	DefaultConsensusQuorumMaxDepth = 8
+)
+
+// BlockFetcher returns the number and hash of a block of a backend
+type BlockFetcher func(ctx context.Context, be *Backend, block string) (hexutil.Uint64, string, error)
+
+// ConsensusResult is the block agreed by a consensus group
+type ConsensusResult struct {
+	BlockNumber hexutil.Uint64
+	BlockHash   string
+	// Members are the candidates that agree on the block
+	Members map[*Backend]*backendState
+	// Broken is set when a block at or below the current consensus is no longer agreed
+	Broken bool
+	// Dissenters are the candidates that broke the consensus
+	Dissenters []*Backend
+}
+
+// ConsensusStrategy resolves the consensus block and group among the candidates.
+// A nil result means that no consensus was resolved, and the previous one is kept.
+type ConsensusStrategy interface {
+	Resolve(ctx context.Context, fetch BlockFetcher, candidates map[*Backend]*backendState,
+		currentConsensusBlockNumber hexutil.Uint64) *ConsensusResult
+}
+
+func NewConsensusStrategy(name string, quorum float64) (ConsensusStrategy, error) {
+	switch name {
+	case "", ConsensusStrategyLowestCommon:
+		return &LowestCommonStrategy{}, nil
+	case ConsensusStrategyQuorum:
+		if quorum == 0 {
+			quorum = DefaultConsensusQuorum
+		}
+		// more than a half, so that two forks can never both reach a quorum
+		if quorum <= 0.5 || quorum > 1 {
+			return nil, fmt.Errorf("consensus quorum must be greater than 0.5 and at most 1, got %v", quorum)
+		}
+		return &QuorumStrategy{Quorum: quorum}, nil
+	default:
+		return nil, fmt.Errorf("unknown consensus strategy: %s", name)
+	}
+}
+
+// LowestCommonStrategy proposes the lowest latest block among the candidates, and walks
+// backwards until every candidate agrees on its hash. All the candidates are members.
+type LowestCommonStrategy struct{}
+
+func (s *LowestCommonStrategy) Resolve(ctx context.Context, fetch BlockFetcher, candidates map[*Backend]*backendState,
+	currentConsensusBlockNumber hexutil.Uint64) *ConsensusResult {
+	var lowestLatestBlock hexutil.Uint64
+	var lowestLatestBlockHash string
+	for _, bs := range candidates {
+		if lowestLatestBlock == 0 || bs.latestBlockNumber < lowestLatestBlock {
+			lowestLatestBlock = bs.latestBlockNumber
+			lowestLatestBlockHash = bs.latestBlockHash
+		}
+	}
+
+	// find the proposed block among the candidates
+	// the proposed block needs have the same hash in the entire consensus group
+	proposedBlock := lowestLatestBlock
+	proposedBlockHash := lowestLatestBlockHash
+	hasConsensus := false
+	broken := false
+	var dissenters []*Backend
+
+	if lowestLatestBlock > currentConsensusBlockNumber {
+		log.Debug("validating consensus on block", "lowestLatestBlock", lowestLatestBlock)
+	}
+
+	// if there is a block to propose, check if it is the same in all backends
+	if proposedBlock > 0 {
+		for !hasConsensus {
+			allAgreed := true
+			for be := range candidates {
+				actualBlockNumber, actualBlockHash, err := fetch(ctx, be, proposedBlock.String())
+				if err != nil {
+					log.Warn("error updating backend", "name", be.Name, "err", err)
+					continue
+				}
+				if proposedBlockHash == "" {
+					proposedBlockHash = actualBlockHash
+				}
+				blocksDontMatch := (actualBlockNumber != proposedBlock) || (actualBlockHash != proposedBlockHash)
+				if blocksDontMatch {
+					if currentConsensusBlockNumber >= actualBlockNumber {
+						log.Warn("backend broke consensus",
+							"name", be.Name,
+							"actualBlockNumber", actualBlockNumber,
+							"actualBlockHash", actualBlockHash,
+							"proposedBlock", proposedBlock,
+							"proposedBlockHash", proposedBlockHash)
+						broken = true
+						dissenters = append(dissenters, be)
+					}
+					allAgreed = false
+					break
+				}
+			}
+			if allAgreed {
+				hasConsensus = true
+			} else {
+				// walk one block behind and try again
+				proposedBlock -= 1
+				proposedBlockHash = ""
+				log.Debug("no consensus, now trying", "block:", proposedBlock)
+			}
+		}
+	}
+
+	return &ConsensusResult{
+		BlockNumber: proposedBlock,
+		BlockHash:   proposedBlockHash,
+		Members:     candidates,
+		Broken:      broken,
+		Dissenters:  dissenters,
+	}
+}
+
+// QuorumStrategy proposes the highest block reached by a quorum of the candidates, and walks
+// backwards until a quorum agrees on its hash. Members are the candidates agreeing with the quorum,
+// so that slow or forked candidates are left out of the group instead of holding it back.
+// The walk is bounded, no quorum is resolved when none forms within MaxDepth blocks.
+type QuorumStrategy struct {
+	// Quorum is the fraction of the candidates that must agree on a block
+	Quorum float64
+	// MaxDepth is the number of blocks walked back from the proposed block, default 8
+	MaxDepth uint64
+}
+
+func (s *QuorumStrategy) Resolve(ctx context.Context, fetch BlockFetcher, candidates map[*Backend]*backendState,
+	currentConsensusBlockNumber hexutil.Uint64) *ConsensusResult {
+	result := &ConsensusResult{Members: make(map[*Backend]*backendState)}
+	if len(candidates) == 0 {
+		return result
+	}
+
+	required := s.required(len(candidates))
+	heights := make([]hexutil.Uint64, 0, len(candidates))
+	for _, bs := range candidates {
+		heights = append(heights, bs.latestBlockNumber)
+	}
+	sort.Slice(heights, func(i, j int) bool { return heights[i] > heights[j] })
+	proposedBlock := heights[required-1]
+	highestProposedBlock := proposedBlock
+	maxDepth := s.MaxDepth
+	if maxDepth == 0 {
+		maxDepth = DefaultConsensusQuorumMaxDepth
+	}
+
+	for depth := uint64(0); proposedBlock > 0; depth++ {
+		if depth > maxDepth {
+			log.Warn("no consensus quorum within the max depth, keeping the current consensus",
+				"proposedBlock", highestProposedBlock,
+				"maxDepth", maxDepth,
+				"required", required,
+				"candidates", len(candidates))
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			log.Warn("consensus quorum interrupted, keeping the current consensus", "err", err)
+			return nil
+		}
+
+		votes := make(map[string][]*Backend)
+		var winner string
+		for be, bs := range candidates {
+			// candidates behind the proposed block can't vote on it
+			if bs.latestBlockNumber < proposedBlock {
+				continue
+			}
+			actualBlockNumber, actualBlockHash, err := fetch(ctx, be, proposedBlock.String())
+			if err != nil {
+				log.Warn("error updating backend", "name", be.Name, "err", err)
+				continue
+			}
+			if actualBlockNumber != proposedBlock {
+				continue
+			}
+			votes[actualBlockHash] = append(votes[actualBlockHash], be)
+			if len(votes[actualBlockHash]) >= required {
+				winner = actualBlockHash
+			}
+		}
+
+		if winner != "" {
+			result.BlockNumber = proposedBlock
+			result.BlockHash = winner
+			for _, be := range votes[winner] {
+				result.Members[be] = candidates[be]
+			}
+			for hash, backends := range votes {
+				if hash == winner {
+					continue
+				}
+				for _, be := range backends {
+					log.Warn("backend disagrees with consensus quorum",
+						"name", be.Name,
+						"actualBlockHash", hash,
+						"proposedBlock", proposedBlock,
+						"proposedBlockHash", winner)
+				}
+			}
+			break
+		}
+
+		if currentConsensusBlockNumber >= proposedBlock {
+			log.Warn("consensus quorum lost",
+				"proposedBlock", proposedBlock,
+				"required", required,
+				"candidates", len(candidates))
+			result.Broken = true
+			result.Dissenters = appendMinorities(result.Dissenters, votes)
+		}
+		// walk one block behind and try again
+		proposedBlock -= 1
+		log.Debug("no consensus quorum, now trying", "block:", proposedBlock)
+	}
+
+	// forced candidates are always members
+	for be, bs := range candidates {
+		if be.forcedCandidate {
+			result.Members[be] = bs
+		}
+	}
+	return result
+}
+
+// appendMinorities appends the backends that didn't vote for the most voted hash,
+// or all of them when no hash has a strict plurality
+func appendMinorities(backends []*Backend, votes map[string][]*Backend) []*Backend {
+	var plurality string
+	tied := false
+	for hash, voters := range votes {
+		if len(voters) > len(votes[plurality]) {
+			plurality = hash
+			tied = false
+		} else if len(voters) == len(votes[plurality]) {
+			tied = true
+		}
+	}
+	for hash, voters := range votes {
+		if hash != plurality || tied {
+			for _, be := range voters {
+				if !slices.Contains(backends, be) {
+					backends = append(backends, be)
+				}
+			}
+		}
+	}
+	return backends
+}
+
+// required is the number of candidates forming a quorum
+func (s *QuorumStrategy) required(candidates int) int {
+	// the epsilon absorbs floating point errors, e.g. 2/3 of 3 candidates
+	required := int(math.Ceil(s.Quorum*float64(candidates) - 1e-9))
+	if required < 1 {
+		required = 1
+	}
+	if required > candidates {
+		required = candidates
+	}
+	return required
+}
@@ -0,0 +1,63 @@
+package proxyd
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuorumStrategyFailedFetches(t *testing.T) {
+	node1, node2, node3 := &Backend{Name: "node1"}, &Backend{Name: "node2"}, &Backend{Name: "node3"}
+	candidates := map[*Backend]*backendState{
+		node1: {latestBlockNumber: 0x1000, latestBlockHash: "hash"},
+		node2: {latestBlockNumber: 0x1000, latestBlockHash: "hash"},
+		node3: {latestBlockNumber: 0x1000, latestBlockHash: "hash"},
+	}
+
+	// only node1 answers, the 2 votes required never come
+	var fetches atomic.Int64
+	fetch := func(ctx context.Context, be *Backend, block string) (hexutil.Uint64, string, error) {
+		fetches.Add(1)
+		if be != node1 {
+			return 0, "", errors.New("connection refused")
+		}
+		number, err := hexutil.DecodeUint64(block)
+		require.NoError(t, err)
+		return hexutil.Uint64(number), "hash", nil
+	}
+
+	s := &QuorumStrategy{Quorum: DefaultConsensusQuorum, MaxDepth: 4}
+	require.Nil(t, s.Resolve(context.Background(), fetch, candidates, 0x1000))
+	// the proposed block and the 4 blocks behind it are tried, instead of walking to genesis
+	require.Equal(t, int64(5*len(candidates)), fetches.Load())
+
+	// the walk stops once the poller is shut down
+	ctx, cancel := context.WithCancel(context.Background())
+	fetches.Store(0)
+	cancelling := func(ctx context.Context, be *Backend, block string) (hexutil.Uint64, string, error) {
+		cancel()
+		return fetch(ctx, be, block)
+	}
+	require.Nil(t, s.Resolve(ctx, cancelling, candidates, 0x1000))
+	require.Equal(t, int64(len(candidates)), fetches.Load())
+
+	// a quorum forms once node2 answers again
+	fetches.Store(0)
+	recovered := func(ctx context.Context, be *Backend, block string) (hexutil.Uint64, string, error) {
+		if be == node2 {
+			number, err := hexutil.DecodeUint64(block)
+			require.NoError(t, err)
+			return hexutil.Uint64(number), "hash", nil
+		}
+		return fetch(ctx, be, block)
+	}
+	result := s.Resolve(context.Background(), recovered, candidates, 0x1000)
+	require.NotNil(t, result)
+	require.Equal(t, hexutil.Uint64(0x1000), result.BlockNumber)
+	require.Len(t, result.Members, 2)
+	require.False(t, result.Broken)
+}
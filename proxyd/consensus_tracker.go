@@ -112,7 +112,7 @@ func (ct *InMemoryConsensusTracker) SetFinalizedBlockNumber(blockNumber hexutil.
 // RedisConsensusTracker store and retrieve in a shared Redis cluster, with leader election
 type RedisConsensusTracker struct {
 	ctx          context.Context
-	client       *redis.Client
+	client       redis.UniversalClient
 	namespace    string
 	backendGroup *BackendGroup
 
@@ -145,7 +145,7 @@ func WithHeartbeatInterval(heartbeatInterval time.Duration) RedisConsensusTracke
 	}
 }
 func NewRedisConsensusTracker(ctx context.Context,
-	redisClient *redis.Client,
+	redisClient redis.UniversalClient,
 	bg *BackendGroup,
 	namespace string,
 	opts ...RedisConsensusTrackerOpt) ConsensusTracker {
@@ -0,0 +1,318 @@
+package proxyd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	DefaultCostDeprioritizeRatio = 0.8
+
+	defaultCostFlushInterval = 10 * time.Second
+	// spend is kept in Redis for a while after the month ends, so that it can be reconciled with the invoices
+	spendRetention = 400 * 24 * time.Hour
+)
+
+// CostTier orders the backends of a group by cost, the lower tiers being used first
+type CostTier int
+
+const (
+	CostTierFree CostTier = iota
+	CostTierPaid
+	// CostTierDeprioritized is a paid backend which spent most of its monthly budget
+	CostTierDeprioritized
+	// CostTierExcluded is a paid backend which spent all of its monthly budget
+	CostTierExcluded
+)
+
+var (
+	errCostNegative          = errors.New("cost prices and monthly_budget must not be negative")
+	errCostDeprioritizeRatio = errors.New("cost deprioritize_ratio must be between 0 and 1")
+)
+
+// CostModel prices the requests to a paid backend, and tracks its spend against its monthly budget.
+// A nil model is a free backend
+type CostModel struct {
+	backend           string
+	pricePerRequest   float64
+	methods           map[string]float64
+	monthlyBudget     float64
+	deprioritizeRatio float64
+	tracker           *SpendTracker
+}
+
+// NewCostModel creates the cost model of a backend, or returns nil if the config is nil
+func NewCostModel(backend string, cfg *CostConfig, tracker *SpendTracker) (*CostModel, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	c := &CostModel{
+		backend:           backend,
+		pricePerRequest:   cfg.PricePerRequest,
+		methods:           cfg.Methods,
+		monthlyBudget:     cfg.MonthlyBudget,
+		deprioritizeRatio: cfg.DeprioritizeRatio,
+		tracker:           tracker,
+	}
+	if c.deprioritizeRatio == 0 {
+		c.deprioritizeRatio = DefaultCostDeprioritizeRatio
+	}
+
+	if c.pricePerRequest < 0 || c.monthlyBudget < 0 {
+		return nil, errCostNegative
+	}
+	for method, price := range c.methods {
+		if price < 0 {
+			return nil, fmt.Errorf("%w, got %v for %s", errCostNegative, price, method)
+		}
+	}
+	if c.deprioritizeRatio < 0 || c.deprioritizeRatio > 1 {
+		return nil, errCostDeprioritizeRatio
+	}
+
+	tracker.register(backend, c.monthlyBudget)
+	return c, nil
+}
+
+// Price returns the price of a call to the method
+func (c *CostModel) Price(method string) float64 {
+	if price, ok := c.methods[method]; ok {
+		return price
+	}
+	return c.pricePerRequest
+}
+
+// Charge adds the price of the requests served by the backend to its spend
+func (c *CostModel) Charge(reqs []*RPCReq) {
+	if c == nil {
+		return
+	}
+	for _, req := range reqs {
+		if price := c.Price(req.Method); price > 0 {
+			c.tracker.Charge(c.backend, req.Method, price)
+		}
+	}
+}
+
+// Tier returns the cost tier of the backend, given its spend in the current month
+func (c *CostModel) Tier() CostTier {
+	if c == nil {
+		return CostTierFree
+	}
+	if c.monthlyBudget == 0 {
+		return CostTierPaid
+	}
+	spend := c.tracker.Spend(c.backend)
+	switch {
+	case spend >= c.monthlyBudget:
+		return CostTierExcluded
+	case spend >= c.monthlyBudget*c.deprioritizeRatio:
+		return CostTierDeprioritized
+	default:
+		return CostTierPaid
+	}
+}
+
+// orderByCost moves the paid backends after the free ones, and the backends running out of budget last,
+// keeping the order of the backends within a tier. The backends which spent their budget are dropped
+func orderByCost(backends []*Backend) []*Backend {
+	hasCost := false
+	for _, be := range backends {
+		if be.costModel != nil {
+			hasCost = true
+			break
+		}
+	}
+	if !hasCost {
+		return backends
+	}
+
+	tiers := make([]CostTier, len(backends))
+	for i, be := range backends {
+		tiers[i] = be.costModel.Tier()
+	}
+	// the backends slice may be shared with the group, so the result is a new one
+	result := make([]*Backend, 0, len(backends))
+	for tier := CostTierFree; tier < CostTierExcluded; tier++ {
+		for i, be := range backends {
+			if tiers[i] == tier {
+				result = append(result, be)
+			}
+		}
+	}
+	return result
+}
+
+// hasBackendCosts returns whether a backend, or a discovered one, has a cost model
+func hasBackendCosts(config *Config) bool {
+	for _, cfg := range config.Backends {
+		if cfg.Cost != nil {
+			return true
+		}
+	}
+	for _, bg := range config.BackendGroups {
+		if bg.Discovery != nil && bg.Discovery.Template.Cost != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// SpendTracker accumulates the spend of the paid backends in memory, and flushes it periodically
+// to a Redis key per backend and calendar month, so that the budgets are shared by the proxyd instances
+type SpendTracker struct {
+	rdb           redis.UniversalClient
+	prefix        string
+	flushInterval time.Duration
+
+	mtx     sync.Mutex
+	budgets map[string]float64
+	pending map[string]float64
+	// totals are the spend of the backends in month as of the last flush
+	totals map[string]float64
+	month  string
+
+	now  func() time.Time
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewSpendTracker(rdb redis.UniversalClient, namespace string, flushInterval time.Duration) *SpendTracker {
+	if flushInterval == 0 {
+		flushInterval = defaultCostFlushInterval
+	}
+	prefix := "backend_spend"
+	if namespace != "" {
+		prefix = namespace + ":backend_spend"
+	}
+	return &SpendTracker{
+		rdb:           rdb,
+		prefix:        prefix,
+		flushInterval: flushInterval,
+		budgets:       make(map[string]float64),
+		pending:       make(map[string]float64),
+		totals:        make(map[string]float64),
+		now:           time.Now,
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+}
+
+func (t *SpendTracker) Start() {
+	go func() {
+		defer close(t.done)
+		ticker := time.NewTicker(t.flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.Flush(context.Background())
+			case <-t.stop:
+				t.Flush(context.Background())
+				return
+			}
+		}
+	}()
+}
+
+// Shutdown stops the periodic flush and flushes the remaining spend
+func (t *SpendTracker) Shutdown() {
+	close(t.stop)
+	<-t.done
+}
+
+// register adds a backend to the ones whose spend is read at each flush
+func (t *SpendTracker) register(backend string, budget float64) {
+	if t == nil {
+		return
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.budgets[backend] = budget
+}
+
+// Charge adds the price of a call to the spend of the backend
+func (t *SpendTracker) Charge(backend string, method string, amount float64) {
+	if t == nil {
+		return
+	}
+	t.mtx.Lock()
+	t.pending[backend] += amount
+	t.mtx.Unlock()
+	RecordBackendSpend(backend, method, amount)
+}
+
+// Spend returns the spend of the backend in the current month, across all proxyd instances
+// as of the last flush, plus the spend of this instance since
+func (t *SpendTracker) Spend(backend string) float64 {
+	if t == nil {
+		return 0
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	spend := t.pending[backend]
+	if t.month == t.currentMonth() {
+		spend += t.totals[backend]
+	}
+	return spend
+}
+
+// Flush writes the pending spend to Redis, and reads back the spend of all the instances
+func (t *SpendTracker) Flush(ctx context.Context) {
+	t.mtx.Lock()
+	pending := t.pending
+	t.pending = make(map[string]float64)
+	backends := make(map[string]bool, len(t.budgets))
+	for backend := range t.budgets {
+		backends[backend] = true
+	}
+	for backend := range pending {
+		backends[backend] = true
+	}
+	t.mtx.Unlock()
+
+	month := t.currentMonth()
+	cmds := make(map[string]*redis.FloatCmd, len(backends))
+	_, err := t.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for backend := range backends {
+			key := t.key(backend, month)
+			// incrementing by 0 reads the spend, and is 0 if there was none yet
+			cmds[backend] = pipe.IncrByFloat(ctx, key, pending[backend])
+			pipe.Expire(ctx, key, spendRetention)
+		}
+		return nil
+	})
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if err != nil {
+		log.Error("error flushing backend spend to redis", "err", err)
+		RecordRedisError("SpendFlush")
+		// keep the spend, so that it's written by the next flush
+		for backend, amount := range pending {
+			t.pending[backend] += amount
+		}
+		return
+	}
+
+	t.month = month
+	for backend := range backends {
+		t.totals[backend] = cmds[backend].Val()
+		RecordBackendMonthlySpend(backend, t.totals[backend], t.budgets[backend])
+	}
+}
+
+func (t *SpendTracker) currentMonth() string {
+	return t.now().UTC().Format("2006-01")
+}
+
+// key hash tags the backend, so that its months share a Redis Cluster slot
+func (t *SpendTracker) key(backend string, month string) string {
+	return fmt.Sprintf("%s:{%s}:%s", t.prefix, backend, month)
+}
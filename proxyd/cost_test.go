@@ -0,0 +1,127 @@
+package proxyd
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpendTracker(t *testing.T) {
+	redisServer, err := miniredis.Run()
+	require.NoError(t, err)
+	defer redisServer.Close()
+
+	redisClient := redis.NewClient(&redis.Options{
+		Addr: fmt.Sprintf("127.0.0.1:%s", redisServer.Port()),
+	})
+
+	ctx := context.Background()
+	now := time.Date(2024, 5, 31, 23, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	requireSpend := func(key string, expected string) {
+		val, err := redisServer.Get(key)
+		require.NoError(t, err)
+		require.Equal(t, expected, val)
+	}
+
+	// two proxyd instances share the budget of the backend
+	a := NewSpendTracker(redisClient, "proxyd", 0)
+	b := NewSpendTracker(redisClient, "proxyd", 0)
+	a.now, b.now = clock, clock
+
+	cfg := &CostConfig{
+		PricePerRequest: 1,
+		Methods:         map[string]float64{"debug_traceTransaction": 5, "eth_chainId": 0},
+		MonthlyBudget:   20,
+	}
+	costA, err := NewCostModel("paid", cfg, a)
+	require.NoError(t, err)
+	costB, err := NewCostModel("paid", cfg, b)
+	require.NoError(t, err)
+	require.Equal(t, CostTierPaid, costA.Tier())
+
+	costA.Charge([]*RPCReq{{Method: "eth_call"}, {Method: "debug_traceTransaction"}, {Method: "eth_chainId"}})
+	require.Equal(t, 6.0, a.Spend("paid"))
+	a.Flush(ctx)
+	requireSpend("proxyd:backend_spend:{paid}:2024-05", "6")
+
+	// the spend of the other instance is read at the next flush
+	costB.Charge([]*RPCReq{{Method: "debug_traceTransaction"}, {Method: "debug_traceTransaction"}})
+	require.Equal(t, 10.0, b.Spend("paid"))
+	b.Flush(ctx)
+	require.Equal(t, 16.0, b.Spend("paid"))
+	require.Equal(t, CostTierDeprioritized, costB.Tier())
+	require.Equal(t, CostTierPaid, costA.Tier())
+	a.Flush(ctx)
+	require.Equal(t, CostTierDeprioritized, costA.Tier())
+
+	costA.Charge([]*RPCReq{{Method: "debug_traceTransaction"}})
+	require.Equal(t, CostTierExcluded, costA.Tier())
+
+	// the spend is kept on Redis errors
+	redisServer.Close()
+	a.Flush(ctx)
+	require.Equal(t, 21.0, a.Spend("paid"))
+	require.NoError(t, redisServer.Restart())
+	a.Flush(ctx)
+	requireSpend("proxyd:backend_spend:{paid}:2024-05", "21")
+
+	// the budget is reset every month
+	now = now.Add(2 * time.Hour)
+	require.Equal(t, CostTierPaid, costA.Tier())
+	a.Flush(ctx)
+	require.Equal(t, 0.0, a.Spend("paid"))
+	requireSpend("proxyd:backend_spend:{paid}:2024-06", "0")
+
+	var free *CostModel
+	free.Charge([]*RPCReq{{Method: "eth_call"}})
+	require.Equal(t, CostTierFree, free.Tier())
+
+	_, err = NewCostModel("paid", &CostConfig{DeprioritizeRatio: 1.5}, nil)
+	require.ErrorIs(t, err, errCostDeprioritizeRatio)
+}
+
+func TestOrderByCost(t *testing.T) {
+	tracker := NewSpendTracker(nil, "", 0)
+	newBackend := func(name string, spend float64) *Backend {
+		be := &Backend{Name: name}
+		if spend < 0 {
+			return be
+		}
+		cost, err := NewCostModel(name, &CostConfig{PricePerRequest: 1, MonthlyBudget: 10}, tracker)
+		require.NoError(t, err)
+		tracker.pending[name] = spend
+		be.costModel = cost
+		return be
+	}
+	names := func(backends []*Backend) []string {
+		var result []string
+		for _, be := range backends {
+			result = append(result, be.Name)
+		}
+		return result
+	}
+
+	free1 := newBackend("free1", -1)
+	free2 := newBackend("free2", -1)
+	require.Equal(t, []string{"free1", "free2"}, names(orderByCost([]*Backend{free1, free2})))
+
+	backends := []*Backend{
+		newBackend("nearly_spent", 9),
+		newBackend("paid", 0),
+		free1,
+		newBackend("spent", 10),
+		free2,
+		newBackend("paid2", 5),
+	}
+	require.Equal(t,
+		[]string{"free1", "free2", "paid", "paid2", "nearly_spent"},
+		names(orderByCost(backends)))
+	// the backends of the group are left untouched
+	require.Equal(t, "nearly_spent", backends[0].Name)
+}
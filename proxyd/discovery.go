@@ -0,0 +1,385 @@
+package proxyd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	DiscoveryTypeDNS  = "dns"
+	DiscoveryTypeFile = "file"
+
+	defaultDiscoveryInterval = 30 * time.Second
+	defaultDiscoveryRPCURL   = "http://{host}:{port}"
+	discoveryTimeout         = 10 * time.Second
+)
+
+// DiscoveredBackend is a backend found by a DiscoveryProvider
+type DiscoveredBackend struct {
+	// Name defaults to host:port
+	Name string `json:"name" toml:"name"`
+	Host string `json:"host" toml:"host"`
+	Port int    `json:"port" toml:"port"`
+	// RPCURL and WSURL override the URLs of the template
+	RPCURL string `json:"rpc_url" toml:"rpc_url"`
+	WSURL  string `json:"ws_url" toml:"ws_url"`
+}
+
+// DiscoveryProvider lists the backends that should currently be members of a backend group
+type DiscoveryProvider interface {
+	Discover(ctx context.Context) ([]*DiscoveredBackend, error)
+}
+
+func NewDiscoveryProvider(cfg *DiscoveryConfig) (DiscoveryProvider, error) {
+	switch cfg.Type {
+	case DiscoveryTypeDNS:
+		return NewDNSDiscovery(cfg)
+	case DiscoveryTypeFile:
+		return NewFileDiscovery(cfg)
+	default:
+		return nil, fmt.Errorf("unknown discovery type: %s", cfg.Type)
+	}
+}
+
+// DNSDiscovery resolves SRV records, or A/AAAA records of a hostname
+type DNSDiscovery struct {
+	resolver *net.Resolver
+	srvName  string
+	hostname string
+	port     int
+}
+
+func NewDNSDiscovery(cfg *DiscoveryConfig) (*DNSDiscovery, error) {
+	if cfg.SRVName == "" && cfg.Hostname == "" {
+		return nil, errors.New("dns discovery must set srv_name or hostname")
+	}
+	if cfg.SRVName == "" && cfg.Port == 0 {
+		return nil, errors.New("dns discovery must set the port of the resolved hostname")
+	}
+
+	resolver := net.DefaultResolver
+	if cfg.Nameserver != "" {
+		nameserver := cfg.Nameserver
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, nameserver)
+			},
+		}
+	}
+
+	return &DNSDiscovery{
+		resolver: resolver,
+		srvName:  cfg.SRVName,
+		hostname: cfg.Hostname,
+		port:     cfg.Port,
+	}, nil
+}
+
+func (d *DNSDiscovery) Discover(ctx context.Context) ([]*DiscoveredBackend, error) {
+	if d.srvName != "" {
+		_, records, err := d.resolver.LookupSRV(ctx, "", "", d.srvName)
+		if err != nil {
+			return nil, err
+		}
+		backends := make([]*DiscoveredBackend, 0, len(records))
+		for _, srv := range records {
+			backends = append(backends, &DiscoveredBackend{
+				Host: strings.TrimSuffix(srv.Target, "."),
+				Port: int(srv.Port),
+			})
+		}
+		return backends, nil
+	}
+
+	addrs, err := d.resolver.LookupHost(ctx, d.hostname)
+	if err != nil {
+		return nil, err
+	}
+	backends := make([]*DiscoveredBackend, 0, len(addrs))
+	for _, addr := range addrs {
+		backends = append(backends, &DiscoveredBackend{
+			Host: addr,
+			Port: d.port,
+		})
+	}
+	return backends, nil
+}
+
+// FileDiscovery reads the backends from a JSON or TOML file with a top-level "backends" list
+type FileDiscovery struct {
+	path string
+}
+
+type discoveryFile struct {
+	Backends []*DiscoveredBackend `json:"backends" toml:"backends"`
+}
+
+func NewFileDiscovery(cfg *DiscoveryConfig) (*FileDiscovery, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("file discovery must set path")
+	}
+	return &FileDiscovery{path: cfg.Path}, nil
+}
+
+func (f *FileDiscovery) Discover(ctx context.Context) ([]*DiscoveredBackend, error) {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+	var file discoveryFile
+	if strings.EqualFold(filepath.Ext(f.path), ".json") {
+		err = json.Unmarshal(data, &file)
+	} else {
+		err = toml.Unmarshal(data, &file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", f.path, err)
+	}
+	return file.Backends, nil
+}
+
+// Watch calls onChange whenever the file is written or replaced, until the context is done.
+// The parent directory is watched, since editors and config map updates replace the file.
+func (f *FileDiscovery) Watch(ctx context.Context, onChange func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(f.path)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		defer watcher.Close()
+		name := filepath.Clean(f.path)
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) == name && event.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename) {
+					onChange()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Warn("error watching backend discovery file", "path", f.path, "err", err)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// BackendDiscovery keeps the members of a backend group in sync with a DiscoveryProvider.
+// Backends found by the provider are created from a template config and added as primaries,
+// backends no longer found are removed from the group and from its consensus poller.
+// The static backends of the group are never removed.
+type BackendDiscovery struct {
+	group      *BackendGroup
+	provider   DiscoveryProvider
+	template   BackendConfig
+	newBackend func(name string, cfg *BackendConfig) (*Backend, error)
+	interval   time.Duration
+
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+	refresh    chan struct{}
+
+	syncMux    sync.Mutex
+	discovered map[string]*discoveredMember
+}
+
+type discoveredMember struct {
+	backend *Backend
+	rpcURL  string
+	wsURL   string
+}
+
+// discoveryTemplate returns the config of the discovered backends, with the default RPC URL applied
+func discoveryTemplate(cfg *DiscoveryConfig) BackendConfig {
+	template := cfg.Template
+	if template.RPCURL == "" {
+		template.RPCURL = defaultDiscoveryRPCURL
+	}
+	return template
+}
+
+func NewBackendDiscovery(
+	group *BackendGroup,
+	cfg *DiscoveryConfig,
+	newBackend func(name string, cfg *BackendConfig) (*Backend, error),
+) (*BackendDiscovery, error) {
+	provider, err := NewDiscoveryProvider(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("backend group %s: %w", group.Name, err)
+	}
+
+	interval := time.Duration(cfg.Interval)
+	if interval == 0 {
+		interval = defaultDiscoveryInterval
+	}
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	return &BackendDiscovery{
+		group:      group,
+		provider:   provider,
+		template:   discoveryTemplate(cfg),
+		newBackend: newBackend,
+		interval:   interval,
+		ctx:        ctx,
+		cancelFunc: cancelFunc,
+		refresh:    make(chan struct{}, 1),
+		discovered: make(map[string]*discoveredMember),
+	}, nil
+}
+
+// Start refreshes the group members periodically, and on changes when the provider supports watching
+func (d *BackendDiscovery) Start() {
+	if w, ok := d.provider.(interface {
+		Watch(ctx context.Context, onChange func()) error
+	}); ok {
+		if err := w.Watch(d.ctx, d.triggerRefresh); err != nil {
+			log.Warn("error watching backend discovery, falling back to polling",
+				"backend_group", d.group.Name, "err", err)
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-d.refresh:
+			case <-d.ctx.Done():
+				return
+			}
+			if err := d.Sync(d.ctx); err != nil && d.ctx.Err() == nil {
+				log.Warn("error discovering backends", "backend_group", d.group.Name, "err", err)
+			}
+		}
+	}()
+}
+
+func (d *BackendDiscovery) triggerRefresh() {
+	select {
+	case d.refresh <- struct{}{}:
+	default:
+	}
+}
+
+func (d *BackendDiscovery) Shutdown() {
+	d.cancelFunc()
+}
+
+// Sync adds the newly discovered backends to the group and removes the ones that are gone.
+// The members are left unchanged when the provider fails, so that a DNS or file outage
+// doesn't empty the group.
+func (d *BackendDiscovery) Sync(ctx context.Context) error {
+	d.syncMux.Lock()
+	defer d.syncMux.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, discoveryTimeout)
+	defer cancel()
+	found, err := d.provider.Discover(ctx)
+	if err != nil {
+		RecordBackendDiscovery(d.group, false, len(d.discovered))
+		return err
+	}
+
+	desired := make(map[string]*DiscoveredBackend, len(found))
+	for _, db := range found {
+		name := db.Name
+		if name == "" {
+			name = net.JoinHostPort(db.Host, strconv.Itoa(db.Port))
+		}
+		desired[name] = db
+	}
+
+	for name, member := range d.discovered {
+		db, ok := desired[name]
+		if ok {
+			cfg := d.backendConfig(db)
+			if cfg.RPCURL == member.rpcURL && cfg.WSURL == member.wsURL {
+				continue
+			}
+		}
+		d.remove(name, member)
+	}
+
+	for name, db := range desired {
+		if _, ok := d.discovered[name]; ok {
+			continue
+		}
+		if err := d.add(name, d.backendConfig(db)); err != nil {
+			log.Error("error adding discovered backend", "backend_group", d.group.Name, "name", name, "err", err)
+		}
+	}
+
+	RecordBackendDiscovery(d.group, true, len(d.discovered))
+	return nil
+}
+
+func (d *BackendDiscovery) backendConfig(db *DiscoveredBackend) *BackendConfig {
+	cfg := d.template
+	placeholders := strings.NewReplacer("{host}", db.Host, "{port}", strconv.Itoa(db.Port))
+	cfg.RPCURL = placeholders.Replace(cfg.RPCURL)
+	cfg.WSURL = placeholders.Replace(cfg.WSURL)
+	if db.RPCURL != "" {
+		cfg.RPCURL = db.RPCURL
+	}
+	if db.WSURL != "" {
+		cfg.WSURL = db.WSURL
+	}
+	return &cfg
+}
+
+func (d *BackendDiscovery) add(name string, cfg *BackendConfig) error {
+	be, err := d.newBackend(name, cfg)
+	if err != nil {
+		return err
+	}
+	if !d.group.AddBackend(be, false) {
+		return fmt.Errorf("backend group already has a backend named %s", name)
+	}
+	if d.group.Consensus != nil {
+		d.group.Consensus.AddBackend(be)
+	}
+	d.discovered[name] = &discoveredMember{
+		backend: be,
+		rpcURL:  cfg.RPCURL,
+		wsURL:   cfg.WSURL,
+	}
+	log.Info("added discovered backend", "backend_group", d.group.Name, "name", name, "rpc_url", cfg.RPCURL)
+	return nil
+}
+
+// remove takes the backend out of rotation. Requests already forwarded to it are left to complete.
+func (d *BackendDiscovery) remove(name string, member *discoveredMember) {
+	d.group.RemoveBackend(name)
+	if d.group.Consensus != nil {
+		d.group.Consensus.RemoveBackend(member.backend)
+	}
+	delete(d.discovered, name)
+	RemoveBackendMetrics(d.group, name)
+	log.Info("removed discovered backend", "backend_group", d.group.Name, "name", name)
+}
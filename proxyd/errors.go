@@ -5,3 +5,26 @@ import "fmt"
 func wrapErr(err error, msg string) error {
 	return fmt.Errorf("%s %w", msg, err)
 }
+
+// backendTransportError is an error sending a request to a backend or reading its response,
+// such as a refused connection or a timeout
+type backendTransportError struct {
+	err error
+}
+
+func (e *backendTransportError) Error() string {
+	return e.err.Error()
+}
+
+func (e *backendTransportError) Unwrap() error {
+	return e.err
+}
+
+// backendStatusError is an unexpected HTTP status code of a backend
+type backendStatusError struct {
+	code int
+}
+
+func (e *backendStatusError) Error() string {
+	return fmt.Sprintf("response code %d", e.code)
+}
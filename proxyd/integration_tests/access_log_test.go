@@ -0,0 +1,98 @@
+package integration_tests
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ethereum-optimism/optimism/proxyd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessLog(t *testing.T) {
+	var failures atomic.Int32
+	goodHandler := BatchedResponseHandler(200, goodResponse)
+	goodBackend := NewMockBackend(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures.Load() > 0 {
+			failures.Add(-1)
+			w.WriteHeader(503)
+			return
+		}
+		goodHandler(w, r)
+	}))
+	defer goodBackend.Close()
+
+	require.NoError(t, os.Setenv("GOOD_BACKEND_RPC_URL", goodBackend.URL()))
+
+	config := ReadConfig("access_log")
+	config.AccessLog.Output = filepath.Join(t.TempDir(), "access.log")
+	client := NewProxydClient("http://127.0.0.1:8545")
+	_, shutdown, err := proxyd.Start(config)
+	require.NoError(t, err)
+	defer shutdown()
+
+	readRecords := func() []map[string]interface{} {
+		f, err := os.Open(config.AccessLog.Output)
+		require.NoError(t, err)
+		defer f.Close()
+		var records []map[string]interface{}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var rec map[string]interface{}
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &rec))
+			records = append(records, rec)
+		}
+		require.NoError(t, scanner.Err())
+		return records
+	}
+
+	t.Run("single request", func(t *testing.T) {
+		failures.Store(1)
+		_, code, err := client.SendRPC("eth_chainId", nil)
+		require.NoError(t, err)
+		require.Equal(t, 200, code)
+
+		records := readRecords()
+		require.Len(t, records, 1)
+		rec := records[0]
+		require.NotEmpty(t, rec["req_id"])
+		require.Equal(t, "none", rec["auth"])
+		require.Equal(t, "[REDACTED]", rec["remote_ip"])
+		require.Equal(t, false, rec["batch"])
+		require.Equal(t, "eth_chainId", rec["method"])
+		require.Equal(t, "main", rec["backend_group"])
+		require.Equal(t, "good", rec["backend"])
+		require.Equal(t, false, rec["cache_hit"])
+		require.Equal(t, float64(1), rec["retries"])
+		require.Equal(t, float64(200), rec["http_status"])
+		require.Greater(t, rec["response_size"], float64(0))
+		require.Greater(t, rec["total_latency_ms"], float64(0))
+		require.Greater(t, rec["upstream_latency_ms"], float64(0))
+		require.NotContains(t, rec, "error_code")
+	})
+
+	t.Run("batch request", func(t *testing.T) {
+		_, code, err := client.SendBatchRPC(
+			NewRPCReq("1", "eth_chainId", nil),
+			NewRPCReq("2", "eth_sendRawTransaction", nil),
+		)
+		require.NoError(t, err)
+		require.Equal(t, 200, code)
+
+		records := readRecords()
+		require.Len(t, records, 2)
+		rec := records[1]
+		require.Equal(t, true, rec["batch"])
+		require.Equal(t, float64(2), rec["batch_size"])
+		calls := rec["calls"].([]interface{})
+		require.Len(t, calls, 2)
+		require.Equal(t, "eth_chainId", calls[0].(map[string]interface{})["method"])
+		require.Equal(t, "good", calls[0].(map[string]interface{})["backend"])
+		require.Equal(t, "eth_sendRawTransaction", calls[1].(map[string]interface{})["method"])
+		require.Equal(t, float64(proxyd.ErrMethodNotWhitelisted.Code), calls[1].(map[string]interface{})["error_code"])
+	})
+}
@@ -0,0 +1,122 @@
+package integration_tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum-optimism/optimism/proxyd"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdmissionControl(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		methods []string
+	)
+	arrived := make(chan struct{}, 1)
+	unblock := make(chan struct{})
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		var req proxyd.RPCReq
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		mu.Lock()
+		methods = append(methods, req.Method)
+		mu.Unlock()
+		select {
+		case arrived <- struct{}{}:
+		default:
+		}
+
+		<-unblock
+		SingleResponseHandler(200, goodResponse)(w, r)
+	}
+	// We don't use the MockBackend because it serializes requests to the handler
+	slowBackend := httptest.NewServer(http.HandlerFunc(handler))
+	defer slowBackend.Close()
+
+	require.NoError(t, os.Setenv("GOOD_BACKEND_RPC_URL", slowBackend.URL))
+
+	config := ReadConfig("admission")
+	client := NewProxydClient("http://127.0.0.1:8545")
+	_, shutdown, err := proxyd.Start(config)
+	require.NoError(t, err)
+	defer shutdown()
+
+	type resWithCode struct {
+		res  []byte
+		code int
+	}
+	send := func(method string) chan *resWithCode {
+		resCh := make(chan *resWithCode, 1)
+		go func() {
+			res, code, err := client.SendRPC(method, nil)
+			require.NoError(t, err)
+			resCh <- &resWithCode{res, code}
+		}()
+		return resCh
+	}
+	requireQueued := func(class string, depth float64) {
+		require.Eventually(t, func() bool {
+			return admissionQueueDepth(t, class) == depth
+		}, time.Second, 10*time.Millisecond)
+	}
+	shed := `{"jsonrpc":"2.0","error":{"code":-32026,"message":"server is overloaded, request shed"},"id":999}`
+
+	// the only slot is taken
+	standard1 := send("eth_chainId")
+	<-arrived
+
+	bulk := send("eth_blockNumber")
+	requireQueued("bulk", 1)
+
+	// the bulk queue is full
+	res := <-send("eth_blockNumber")
+	require.Equal(t, 503, res.code)
+	RequireEqualJSON(t, []byte(shed), res.res)
+
+	standard2 := send("eth_chainId")
+	requireQueued("standard", 1)
+
+	// max_queued is reached, the bulk request is shed for the critical one
+	critical := send("eth_sendRawTransaction")
+	requireQueued("critical", 1)
+	res = <-bulk
+	require.Equal(t, 503, res.code)
+	RequireEqualJSON(t, []byte(shed), res.res)
+	requireQueued("bulk", 0)
+
+	close(unblock)
+	for _, resCh := range []chan *resWithCode{standard1, standard2, critical} {
+		res := <-resCh
+		require.Equal(t, 200, res.code)
+		RequireEqualJSON(t, []byte(goodResponse), res.res)
+	}
+
+	// the critical request is admitted first
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []string{"eth_chainId", "eth_sendRawTransaction", "eth_chainId"}, methods)
+}
+
+func admissionQueueDepth(t *testing.T, class string) float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != "proxyd_admission_queue_depth" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "class" && l.GetValue() == class {
+					return m.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+	return -1
+}
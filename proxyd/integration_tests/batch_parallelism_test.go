@@ -0,0 +1,83 @@
+package integration_tests
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum-optimism/optimism/proxyd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchParallelism(t *testing.T) {
+	router := NewBatchRPCResponseRouter()
+	var mtx sync.Mutex
+	var inFlight, maxInFlight, batches int
+	// MockBackend serves one request at a time
+	goodBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		batches++
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mtx.Unlock()
+		time.Sleep(200 * time.Millisecond)
+		router.ServeHTTP(w, r)
+		mtx.Lock()
+		inFlight--
+		mtx.Unlock()
+	}))
+	defer goodBackend.Close()
+	require.NoError(t, os.Setenv("GOOD_BACKEND_RPC_URL", goodBackend.URL))
+
+	// 7 calls to main and 3 to other are forwarded in 6 upstream batches of at most 2 calls
+	var reqs []*proxyd.RPCReq
+	var expected []string
+	for i := 1; i <= 10; i++ {
+		method := "eth_chainId"
+		if i%3 == 0 {
+			method = "net_version"
+		}
+		id := fmt.Sprintf("%d", i)
+		result := fmt.Sprintf("%s%d", method, i)
+		router.SetRoute(method, id, result)
+		reqs = append(reqs, NewRPCReq(id, method, nil))
+		expected = append(expected, fmt.Sprintf(`{"jsonrpc":"2.0","result":"%s","id":%d}`, result, i))
+	}
+
+	tests := []struct {
+		name                string
+		parallelism         int
+		expectedMaxInFlight int
+	}{
+		{"concurrent minibatches", 3, 3},
+		{"sequential minibatches", 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mtx.Lock()
+			batches, maxInFlight = 0, 0
+			mtx.Unlock()
+
+			config := ReadConfig("batch_parallelism")
+			config.Server.MaxUpstreamBatchParallelism = tt.parallelism
+			client := NewProxydClient("http://127.0.0.1:8545")
+			_, shutdown, err := proxyd.Start(config)
+			require.NoError(t, err)
+			defer shutdown()
+
+			res, code, err := client.SendBatchRPC(reqs...)
+			require.NoError(t, err)
+			require.Equal(t, 200, code)
+			// the responses are in the order of the requests, whichever minibatch completed first
+			RequireEqualJSON(t, []byte(asArray(expected...)), res)
+			mtx.Lock()
+			defer mtx.Unlock()
+			require.Equal(t, 6, batches)
+			require.Equal(t, tt.expectedMaxInFlight, maxInFlight)
+		})
+	}
+}
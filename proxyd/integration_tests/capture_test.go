@@ -0,0 +1,79 @@
+package integration_tests
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum-optimism/optimism/proxyd"
+	"github.com/ethereum-optimism/optimism/proxyd/tools/replay/replayer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCaptureAndReplay(t *testing.T) {
+	goodBackend := NewMockBackend(BatchedResponseHandler(200, goodResponse))
+	defer goodBackend.Close()
+
+	require.NoError(t, os.Setenv("GOOD_BACKEND_RPC_URL", goodBackend.URL()))
+
+	config := ReadConfig("capture")
+	config.Capture.Output = filepath.Join(t.TempDir(), "capture.jsonl")
+	client := NewProxydClientWithHeaders("http://127.0.0.1:8545", http.Header{
+		"Authorization": []string{"Bearer secret"},
+		"User-Agent":    []string{"capture-test"},
+	})
+	_, shutdown, err := proxyd.Start(config)
+	require.NoError(t, err)
+	defer shutdown()
+
+	_, code, err := client.SendRPC("eth_chainId", nil)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	_, code, err = client.SendRPC("eth_sendRawTransaction", nil)
+	require.NoError(t, err)
+	require.Equal(t, 403, code)
+
+	reqs, err := replayer.Load(config.Capture.Output)
+	require.NoError(t, err)
+	require.Len(t, reqs, 2)
+	require.Equal(t, "[REDACTED]", reqs[0].Headers["Authorization"])
+	require.Equal(t, "capture-test", reqs[0].Headers["User-Agent"])
+	require.Contains(t, reqs[0].Body, "eth_chainId")
+	require.Equal(t, 200, reqs[0].StatusCode)
+	RequireEqualJSON(t, []byte(goodResponse), []byte(reqs[0].Response))
+	require.Equal(t, 403, reqs[1].StatusCode)
+	require.Greater(t, reqs[0].DurationMs, float64(0))
+
+	r := &replayer.Replayer{
+		Target:        "http://127.0.0.1:8545",
+		Speed:         0,
+		Concurrency:   2,
+		Timeout:       time.Second,
+		ReplayHeaders: true,
+		MaxMismatches: 10,
+	}
+
+	t.Run("replay against the same proxyd", func(t *testing.T) {
+		report := r.Run(reqs)
+		require.Equal(t, 2, report.Total)
+		require.Equal(t, 0, report.TransportErrors)
+		require.Equal(t, 1, report.OriginalErrors)
+		require.Equal(t, 1, report.ReplayErrors)
+		require.Equal(t, 2, report.Compared)
+		require.Equal(t, 0, report.MismatchCount)
+	})
+
+	t.Run("replay against a different backend", func(t *testing.T) {
+		otherBackend := NewMockBackend(BatchedResponseHandler(200, `{"jsonrpc": "2.0", "result": "other", "id": 999}`))
+		defer otherBackend.Close()
+
+		r.Target = otherBackend.URL()
+		report := r.Run(reqs)
+		require.Equal(t, 1, report.OriginalErrors)
+		require.Equal(t, 0, report.ReplayErrors)
+		require.Equal(t, 2, report.MismatchCount)
+		require.Len(t, report.Mismatches, 2)
+	})
+}
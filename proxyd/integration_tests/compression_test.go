@@ -0,0 +1,116 @@
+package integration_tests
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ethereum-optimism/optimism/proxyd"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompression(t *testing.T) {
+	largeResponse := fmt.Sprintf(`{"jsonrpc": "2.0", "result": "%s", "id": 999}`, strings.Repeat("0x00", 1000))
+	router := NewBatchRPCResponseRouter()
+	router.SetFallbackRoute("eth_chainId", "0x1")
+	router.SetFallbackRoute("eth_getLogs", strings.Repeat("0x00", 1000))
+	goodBackend := NewMockBackend(router)
+	defer goodBackend.Close()
+
+	require.NoError(t, os.Setenv("GOOD_BACKEND_RPC_URL", goodBackend.URL()))
+
+	config := ReadConfig("compression")
+	_, shutdown, err := proxyd.Start(config)
+	require.NoError(t, err)
+	defer shutdown()
+
+	send := func(body []byte, headers map[string]string) (*http.Response, []byte) {
+		req, err := http.NewRequest("POST", "http://127.0.0.1:8545", bytes.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		// use a transport that does not transparently decompress responses
+		res, err := (&http.Transport{DisableCompression: true}).RoundTrip(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		resBody, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res, resBody
+	}
+	getLogs := []byte(`{"jsonrpc": "2.0", "method": "eth_getLogs", "params": [], "id": 999}`)
+
+	t.Run("gzip response", func(t *testing.T) {
+		res, body := send(getLogs, map[string]string{"Accept-Encoding": "gzip"})
+		require.Equal(t, 200, res.StatusCode)
+		require.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+		require.Contains(t, res.Header.Values("Vary"), "Accept-Encoding")
+		gr, err := gzip.NewReader(bytes.NewReader(body))
+		require.NoError(t, err)
+		decoded, err := io.ReadAll(gr)
+		require.NoError(t, err)
+		require.Less(t, len(body), len(decoded))
+		RequireEqualJSON(t, []byte(largeResponse), decoded)
+	})
+
+	t.Run("zstd response is preferred", func(t *testing.T) {
+		res, body := send(getLogs, map[string]string{"Accept-Encoding": "gzip, zstd"})
+		require.Equal(t, 200, res.StatusCode)
+		require.Equal(t, "zstd", res.Header.Get("Content-Encoding"))
+		dec, err := zstd.NewReader(nil)
+		require.NoError(t, err)
+		defer dec.Close()
+		decoded, err := dec.DecodeAll(body, nil)
+		require.NoError(t, err)
+		RequireEqualJSON(t, []byte(largeResponse), decoded)
+	})
+
+	t.Run("small responses are not compressed", func(t *testing.T) {
+		res, body := send([]byte(`{"jsonrpc": "2.0", "method": "eth_chainId", "params": [], "id": 999}`), map[string]string{"Accept-Encoding": "gzip"})
+		require.Equal(t, 200, res.StatusCode)
+		require.Empty(t, res.Header.Get("Content-Encoding"))
+		RequireEqualJSON(t, []byte(`{"jsonrpc": "2.0", "result": "0x1", "id": 999}`), body)
+	})
+
+	t.Run("gzip request", func(t *testing.T) {
+		res, body := send(gzipBytes(t, getLogs), map[string]string{"Content-Encoding": "gzip"})
+		require.Equal(t, 200, res.StatusCode)
+		require.Empty(t, res.Header.Get("Content-Encoding"))
+		RequireEqualJSON(t, []byte(largeResponse), body)
+	})
+
+	t.Run("decompressed request size is limited", func(t *testing.T) {
+		padded := fmt.Sprintf(`{"jsonrpc": "2.0", "method": "eth_chainId", "params": ["%s"], "id": 999}`, strings.Repeat("0", 10000))
+		compressed := gzipBytes(t, []byte(padded))
+		require.Less(t, len(compressed), 1024)
+		res, body := send(compressed, map[string]string{"Content-Encoding": "gzip"})
+		require.Equal(t, 413, res.StatusCode)
+		RequireEqualJSON(t, []byte(`{"jsonrpc":"2.0","error":{"code":-32021,"message":"request body too large"},"id":null}`), body)
+	})
+
+	t.Run("invalid gzip request", func(t *testing.T) {
+		res, _ := send(getLogs, map[string]string{"Content-Encoding": "gzip"})
+		require.Equal(t, 400, res.StatusCode)
+	})
+
+	t.Run("unsupported request encoding", func(t *testing.T) {
+		res, _ := send(getLogs, map[string]string{"Content-Encoding": "br"})
+		require.Equal(t, 415, res.StatusCode)
+	})
+}
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	_, err := gw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+	return buf.Bytes()
+}
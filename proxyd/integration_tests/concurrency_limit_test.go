@@ -0,0 +1,58 @@
+package integration_tests
+
+import (
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/ethereum-optimism/optimism/proxyd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	arrived := make(chan struct{})
+	unblock := make(chan struct{})
+	slowBackend := NewMockBackend(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(arrived)
+		<-unblock
+		SingleResponseHandler(200, goodResponse)(w, r)
+	}))
+	defer slowBackend.Close()
+	goodBackend := NewMockBackend(BatchedResponseHandler(200, goodResponse))
+	defer goodBackend.Close()
+
+	require.NoError(t, os.Setenv("SLOW_BACKEND_RPC_URL", slowBackend.URL()))
+	require.NoError(t, os.Setenv("GOOD_BACKEND_RPC_URL", goodBackend.URL()))
+
+	config := ReadConfig("concurrency_limit")
+	client := NewProxydClient("http://127.0.0.1:8545")
+	_, shutdown, err := proxyd.Start(config)
+	require.NoError(t, err)
+	defer shutdown()
+
+	type resWithCodeErr struct {
+		res  []byte
+		code int
+		err  error
+	}
+	resCh := make(chan *resWithCodeErr, 1)
+	go func() {
+		res, code, err := client.SendRPC("eth_chainId", nil)
+		resCh <- &resWithCodeErr{res, code, err}
+	}()
+	<-arrived
+
+	// the slow backend is at its limit, the request goes to the next backend instead of waiting
+	res, code, err := client.SendRPC("eth_chainId", nil)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	RequireEqualJSON(t, []byte(goodResponse), res)
+	require.Len(t, goodBackend.Requests(), 1)
+
+	close(unblock)
+	slowRes := <-resCh
+	require.NoError(t, slowRes.err)
+	require.Equal(t, 200, slowRes.code)
+	RequireEqualJSON(t, []byte(goodResponse), slowRes.res)
+	require.Len(t, slowBackend.Requests(), 1)
+}
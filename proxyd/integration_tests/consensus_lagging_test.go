@@ -0,0 +1,110 @@
+package integration_tests
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum-optimism/optimism/proxyd"
+	ms "github.com/ethereum-optimism/optimism/proxyd/tools/mockserver/handler"
+)
+
+func TestConsensusServeLagging(t *testing.T) {
+	dir, err := os.Getwd()
+	require.NoError(t, err)
+	responses := path.Join(dir, "testdata/consensus_responses.yml")
+
+	h1 := &ms.MockedHandler{Overrides: []*ms.MethodTemplate{}, Autoload: true, AutoloadFile: responses}
+	h2 := &ms.MockedHandler{Overrides: []*ms.MethodTemplate{}, Autoload: true, AutoloadFile: responses}
+	node1 := NewMockBackend(http.HandlerFunc(h1.Handler))
+	defer node1.Close()
+	node2 := NewMockBackend(http.HandlerFunc(h2.Handler))
+	defer node2.Close()
+	require.NoError(t, os.Setenv("NODE1_URL", node1.URL()))
+	require.NoError(t, os.Setenv("NODE2_URL", node2.URL()))
+
+	// node2 is in sync, but too far behind node1 to be part of the consensus group
+	h2.AddOverride(&ms.MethodTemplate{
+		Method: "eth_getBlockByNumber",
+		Block:  "latest",
+		Response: buildResponse(map[string]string{
+			"number": "0xe1",
+			"hash":   "hash_0xe1",
+		}),
+	})
+
+	config := ReadConfig("consensus_lagging")
+	svr, shutdown, err := proxyd.Start(config)
+	require.NoError(t, err)
+	defer shutdown()
+	client := NewProxydClient("http://127.0.0.1:8545")
+
+	bg := svr.BackendGroups["node"]
+	require.NotNil(t, bg.Consensus)
+	ctx := context.Background()
+	for _, be := range bg.Backends {
+		bg.Consensus.UpdateBackend(ctx, be)
+	}
+	bg.Consensus.UpdateBackendGroupConsensus(ctx)
+	require.Equal(t, "0x101", bg.Consensus.GetLatestBlockNumber().String())
+	require.Equal(t, []*proxyd.Backend{bg.Backends[0]}, bg.Consensus.GetConsensusGroup())
+
+	// send sends the request many times, and returns how many of them each node served
+	send := func(reqs ...*proxyd.RPCReq) (int, int) {
+		t.Helper()
+		node1.Reset()
+		node2.Reset()
+		for i := 0; i < 20; i++ {
+			_, statusCode, err := client.SendBatchRPC(reqs...)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, statusCode)
+		}
+		return len(node1.Requests()), len(node2.Requests())
+	}
+	block := func(tag string) *proxyd.RPCReq {
+		return NewRPCReq(`"`+tag+`"`, "eth_getBlockByNumber", []interface{}{tag, false})
+	}
+
+	t.Run("lagging backend serves finalized blocks", func(t *testing.T) {
+		for _, reqs := range [][]*proxyd.RPCReq{
+			{block("0xc1")},
+			{block("0x91")},
+			{block("finalized")},
+			{block("0x91"), block("0xc1")},
+		} {
+			served1, served2 := send(reqs...)
+			require.Equal(t, 20, served1+served2)
+			require.Greater(t, served1, 0)
+			require.Greater(t, served2, 0)
+		}
+	})
+
+	t.Run("head of the chain is only served by the consensus group", func(t *testing.T) {
+		for _, reqs := range [][]*proxyd.RPCReq{
+			{block("latest")},
+			{block("0x101")},
+			{block("0xc1"), block("latest")},
+			// unfinalized blocks, even the ones the lagging backend has
+			{block("0xe1")},
+			{block("safe")},
+			{block("0xc1"), block("0xe1")},
+		} {
+			served1, served2 := send(reqs...)
+			require.Equal(t, 20, served1)
+			require.Equal(t, 0, served2)
+		}
+	})
+
+	t.Run("banned backend doesn't serve", func(t *testing.T) {
+		bg.Consensus.Ban(bg.Backends[1])
+		defer bg.Consensus.Unban(bg.Backends[1])
+
+		served1, served2 := send(block("0xc1"))
+		require.Equal(t, 20, served1)
+		require.Equal(t, 0, served2)
+	})
+}
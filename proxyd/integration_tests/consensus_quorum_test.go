@@ -0,0 +1,361 @@
+package integration_tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"github.com/ethereum-optimism/optimism/proxyd"
+	ms "github.com/ethereum-optimism/optimism/proxyd/tools/mockserver/handler"
+	"github.com/stretchr/testify/require"
+)
+
+func setupQuorum(t *testing.T) (map[string]nodeContext, *proxyd.BackendGroup, *ProxydHTTPClient, func()) {
+	dir, err := os.Getwd()
+	require.NoError(t, err)
+
+	responses := path.Join(dir, "testdata/consensus_responses.yml")
+
+	// setup mock servers
+	names := []string{"node1", "node2", "node3"}
+	mocks := make(map[string]*MockBackend, len(names))
+	handlers := make(map[string]*ms.MockedHandler, len(names))
+	for i, name := range names {
+		h := &ms.MockedHandler{
+			Overrides:    []*ms.MethodTemplate{},
+			Autoload:     true,
+			AutoloadFile: responses,
+		}
+		mocks[name] = NewMockBackend(http.HandlerFunc(h.Handler))
+		handlers[name] = h
+		require.NoError(t, os.Setenv("NODE"+string(rune('1'+i))+"_URL", mocks[name].URL()))
+	}
+
+	// setup proxyd
+	config := ReadConfig("consensus_quorum")
+	svr, shutdown, err := proxyd.Start(config)
+	require.NoError(t, err)
+
+	// expose the proxyd client
+	client := NewProxydClient("http://127.0.0.1:8545")
+
+	// expose the backend group
+	bg := svr.BackendGroups["node"]
+	require.NotNil(t, bg)
+	require.NotNil(t, bg.Consensus)
+	require.Equal(t, 3, len(bg.Backends)) // should match config
+
+	// convenient mapping to access the nodes by name
+	nodes := make(map[string]nodeContext, len(names))
+	for i, name := range names {
+		nodes[name] = nodeContext{
+			mockBackend: mocks[name],
+			backend:     bg.Backends[i],
+			handler:     handlers[name],
+		}
+	}
+
+	return nodes, bg, client, shutdown
+}
+
+func TestConsensusQuorum(t *testing.T) {
+	reorgs := make(chan *proxyd.ReorgEvent, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := new(proxyd.ReorgEvent)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(event))
+		reorgs <- event
+	}))
+	defer webhook.Close()
+	require.NoError(t, os.Setenv("REORG_WEBHOOK_URL", webhook.URL))
+
+	nodes, bg, client, shutdown := setupQuorum(t)
+	for _, node := range nodes {
+		defer node.mockBackend.Close()
+	}
+	defer shutdown()
+
+	ctx := context.Background()
+
+	// poll for updated consensus
+	update := func() {
+		for _, be := range bg.Backends {
+			bg.Consensus.UpdateBackend(ctx, be)
+		}
+		bg.Consensus.UpdateBackendGroupConsensus(ctx)
+	}
+
+	// convenient methods to manipulate state and mock responses
+	reset := func() {
+		for _, node := range nodes {
+			node.handler.ResetOverrides()
+			node.mockBackend.Reset()
+		}
+		bg.Consensus.ClearListeners()
+		bg.Consensus.Reset()
+	}
+
+	override := func(node string, method string, block string, response string) {
+		if _, ok := nodes[node]; !ok {
+			t.Fatalf("node %s does not exist in the nodes map", node)
+		}
+		nodes[node].handler.AddOverride(&ms.MethodTemplate{
+			Method:   method,
+			Block:    block,
+			Response: response,
+		})
+	}
+
+	overrideBlock := func(node string, blockRequest string, blockResponse string) {
+		override(node,
+			"eth_getBlockByNumber",
+			blockRequest,
+			buildResponse(map[string]string{
+				"number": blockResponse,
+				"hash":   "hash_" + blockResponse,
+			}))
+	}
+
+	overrideBlockHash := func(node string, blockRequest string, number string, hash string) {
+		override(node,
+			"eth_getBlockByNumber",
+			blockRequest,
+			buildResponse(map[string]string{
+				"number": number,
+				"hash":   hash,
+			}))
+	}
+
+	overridePeerCount := func(node string, count int) {
+		override(node, "net_peerCount", "", buildResponse(hexutil.Uint64(count).String()))
+	}
+
+	requireGroup := func(members ...string) {
+		t.Helper()
+		consensusGroup := bg.Consensus.GetConsensusGroup()
+		require.Equal(t, len(members), len(consensusGroup))
+		for _, member := range members {
+			require.Contains(t, consensusGroup, nodes[member].backend)
+		}
+	}
+
+	t.Run("initial consensus", func(t *testing.T) {
+		reset()
+		update()
+
+		require.Equal(t, "0x101", bg.Consensus.GetLatestBlockNumber().String())
+		require.Equal(t, "0xe1", bg.Consensus.GetSafeBlockNumber().String())
+		require.Equal(t, "0xc1", bg.Consensus.GetFinalizedBlockNumber().String())
+		requireGroup("node1", "node2", "node3")
+	})
+
+	t.Run("slow node doesn't hold back the quorum", func(t *testing.T) {
+		reset()
+		overrideBlock("node1", "latest", "0x102")
+		overrideBlock("node2", "latest", "0x102")
+		update()
+
+		// node3 is still at 0x101, but 2 of 3 nodes reached 0x102
+		require.Equal(t, "0x102", bg.Consensus.GetLatestBlockNumber().String())
+		requireGroup("node1", "node2")
+		require.False(t, bg.Consensus.IsBanned(nodes["node3"].backend))
+
+		// node3 catches up and joins the group again
+		overrideBlock("node3", "latest", "0x102")
+		update()
+
+		require.Equal(t, "0x102", bg.Consensus.GetLatestBlockNumber().String())
+		requireGroup("node1", "node2", "node3")
+	})
+
+	t.Run("quorum waits for enough nodes", func(t *testing.T) {
+		reset()
+		overrideBlock("node1", "latest", "0x103")
+		overrideBlock("node2", "latest", "0x102")
+		update()
+
+		// only node1 reached 0x103, the quorum is at 0x102
+		require.Equal(t, "0x102", bg.Consensus.GetLatestBlockNumber().String())
+		requireGroup("node1", "node2")
+	})
+
+	t.Run("forked node is excluded", func(t *testing.T) {
+		reset()
+		overrideBlockHash("node3", "latest", "0x101", "fork_0x101")
+		overrideBlockHash("node3", "0x101", "0x101", "fork_0x101")
+		update()
+
+		require.Equal(t, "0x101", bg.Consensus.GetLatestBlockNumber().String())
+		requireGroup("node1", "node2")
+		require.False(t, bg.Consensus.IsBanned(nodes["node3"].backend))
+
+		// requests are only served by the quorum
+		for _, node := range nodes {
+			node.mockBackend.Reset()
+		}
+		for i := 0; i < 4; i++ {
+			_, statusCode, err := client.SendRPC("eth_getBlockByNumber", []interface{}{"latest", false})
+			require.NoError(t, err)
+			require.Equal(t, 200, statusCode)
+		}
+		require.Empty(t, nodes["node3"].mockBackend.Requests())
+		require.Equal(t, 4, len(nodes["node1"].mockBackend.Requests())+len(nodes["node2"].mockBackend.Requests()))
+
+		// the forked node isn't lagging, but it only serves the finalized blocks
+		send := func(block string) int {
+			t.Helper()
+			for _, node := range nodes {
+				node.mockBackend.Reset()
+			}
+			for i := 0; i < 20; i++ {
+				_, statusCode, err := client.SendRPC("eth_getBlockByNumber", []interface{}{block, false})
+				require.NoError(t, err)
+				require.Equal(t, 200, statusCode)
+			}
+			return len(nodes["node3"].mockBackend.Requests())
+		}
+		require.Zero(t, send("0xe1"))
+		require.Zero(t, send("safe"))
+		require.Greater(t, send("0xc1"), 0)
+		require.Greater(t, send("finalized"), 0)
+	})
+
+	t.Run("safe and finalized of excluded nodes are ignored", func(t *testing.T) {
+		reset()
+		overrideBlock("node1", "latest", "0x102")
+		overrideBlock("node2", "latest", "0x102")
+		overrideBlock("node3", "safe", "0xd1")
+		overrideBlock("node3", "finalized", "0x91")
+		update()
+
+		require.Equal(t, "0x102", bg.Consensus.GetLatestBlockNumber().String())
+		require.Equal(t, "0xe1", bg.Consensus.GetSafeBlockNumber().String())
+		require.Equal(t, "0xc1", bg.Consensus.GetFinalizedBlockNumber().String())
+		requireGroup("node1", "node2")
+	})
+
+	t.Run("walk back when there is no quorum", func(t *testing.T) {
+		reset()
+		update()
+		require.Equal(t, "0x101", bg.Consensus.GetLatestBlockNumber().String())
+
+		listenerCalled := false
+		bg.Consensus.AddListener(func() {
+			listenerCalled = true
+		})
+
+		// every node is on a different fork at 0x101, they agree on 0x100
+		overrideBlockHash("node2", "latest", "0x101", "fork2_0x101")
+		overrideBlockHash("node2", "0x101", "0x101", "fork2_0x101")
+		overrideBlockHash("node3", "latest", "0x101", "fork3_0x101")
+		overrideBlockHash("node3", "0x101", "0x101", "fork3_0x101")
+		for _, node := range []string{"node1", "node2", "node3"} {
+			overrideBlock(node, "0x100", "0x100")
+		}
+		update()
+
+		require.Equal(t, "0x100", bg.Consensus.GetLatestBlockNumber().String())
+		requireGroup("node1", "node2", "node3")
+		require.True(t, listenerCalled)
+
+		// the reorg is notified with the backends that broke the consensus
+		select {
+		case event := <-reorgs:
+			require.Equal(t, "node", event.BackendGroup)
+			require.Equal(t, proxyd.ReorgHead{Number: 0x101, Hash: "hash_0x101"}, event.OldHead)
+			require.Equal(t, proxyd.ReorgHead{Number: 0x100, Hash: "hash_0x100"}, event.NewHead)
+			require.Equal(t, uint64(1), event.Depth)
+			require.Equal(t, []string{"node1", "node2", "node3"}, event.Backends)
+		case <-time.After(5 * time.Second):
+			t.Fatal("reorg was not notified")
+		}
+	})
+
+	t.Run("keep the consensus when no quorum forms", func(t *testing.T) {
+		reset()
+		update()
+		require.Equal(t, "0x101", bg.Consensus.GetLatestBlockNumber().String())
+
+		listenerCalled := false
+		bg.Consensus.AddListener(func() {
+			listenerCalled = true
+		})
+
+		// every node is on a different fork at 0x101, and the blocks behind it fail to be fetched
+		overrideBlockHash("node2", "latest", "0x101", "fork2_0x101")
+		overrideBlockHash("node2", "0x101", "0x101", "fork2_0x101")
+		overrideBlockHash("node3", "latest", "0x101", "fork3_0x101")
+		overrideBlockHash("node3", "0x101", "0x101", "fork3_0x101")
+		update()
+
+		// the previous consensus is kept
+		require.Equal(t, "0x101", bg.Consensus.GetLatestBlockNumber().String())
+		requireGroup("node1", "node2", "node3")
+		require.False(t, listenerCalled)
+		// the walk gives up after consensus_max_block_lag blocks, instead of fetching the 0x101 blocks down to genesis
+		require.Less(t, len(nodes["node1"].mockBackend.Requests()), 50)
+	})
+
+	t.Run("ban backend with conflicting finalized hash", func(t *testing.T) {
+		reset()
+		overrideBlockHash("node3", "finalized", "0xc1", "fork_0xc1")
+		update()
+
+		require.Equal(t, "0x101", bg.Consensus.GetLatestBlockNumber().String())
+		require.Equal(t, "0xc1", bg.Consensus.GetFinalizedBlockNumber().String())
+		requireGroup("node1", "node2")
+		require.True(t, bg.Consensus.IsBanned(nodes["node3"].backend))
+	})
+
+	t.Run("ban backend with conflicting finalized hash at the lowest common height", func(t *testing.T) {
+		reset()
+		// node3 finalized further, but its block at the group finalized height differs
+		overrideBlock("node3", "finalized", "0xd1")
+		overrideBlockHash("node3", "0xc1", "0xc1", "fork_0xc1")
+		update()
+
+		require.Equal(t, "0xc1", bg.Consensus.GetFinalizedBlockNumber().String())
+		requireGroup("node1", "node2")
+		require.True(t, bg.Consensus.IsBanned(nodes["node3"].backend))
+	})
+
+	t.Run("exclude backend with conflicting safe hash", func(t *testing.T) {
+		reset()
+		overrideBlockHash("node3", "safe", "0xe1", "fork_0xe1")
+		update()
+
+		require.Equal(t, "0xe1", bg.Consensus.GetSafeBlockNumber().String())
+		requireGroup("node1", "node2")
+		require.False(t, bg.Consensus.IsBanned(nodes["node3"].backend))
+
+		// node3 joins the group again once it agrees
+		nodes["node3"].handler.ResetOverrides()
+		update()
+		requireGroup("node1", "node2", "node3")
+	})
+
+	t.Run("quorum is relative to the candidates", func(t *testing.T) {
+		reset()
+		// node3 isn't a candidate, node1 and node2 form the quorum
+		overridePeerCount("node3", 0)
+		overrideBlock("node1", "latest", "0x102")
+		overrideBlock("node2", "latest", "0x102")
+		update()
+
+		require.Equal(t, "0x102", bg.Consensus.GetLatestBlockNumber().String())
+		requireGroup("node1", "node2")
+
+		// with 2 candidates, 0.66 requires both of them
+		overrideBlock("node2", "latest", "0x101")
+		update()
+
+		require.Equal(t, "0x101", bg.Consensus.GetLatestBlockNumber().String())
+		requireGroup("node1", "node2")
+	})
+}
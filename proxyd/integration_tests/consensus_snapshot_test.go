@@ -0,0 +1,99 @@
+package integration_tests
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum-optimism/optimism/proxyd"
+	ms "github.com/ethereum-optimism/optimism/proxyd/tools/mockserver/handler"
+)
+
+func TestConsensusSnapshot(t *testing.T) {
+	dir, err := os.Getwd()
+	require.NoError(t, err)
+	responses := path.Join(dir, "testdata/consensus_responses.yml")
+
+	h1 := &ms.MockedHandler{Overrides: []*ms.MethodTemplate{}, Autoload: true, AutoloadFile: responses}
+	h2 := &ms.MockedHandler{Overrides: []*ms.MethodTemplate{}, Autoload: true, AutoloadFile: responses}
+	node1 := NewMockBackend(http.HandlerFunc(h1.Handler))
+	defer node1.Close()
+	node2 := NewMockBackend(http.HandlerFunc(h2.Handler))
+	defer node2.Close()
+	require.NoError(t, os.Setenv("NODE1_URL", node1.URL()))
+	require.NoError(t, os.Setenv("NODE2_URL", node2.URL()))
+
+	snapshotDir := t.TempDir()
+	start := func() (*proxyd.BackendGroup, func()) {
+		config := ReadConfig("consensus_snapshot")
+		config.BackendGroups["node"].ConsensusSnapshot.Path = snapshotDir
+		svr, shutdown, err := proxyd.Start(config)
+		require.NoError(t, err)
+		bg := svr.BackendGroups["node"]
+		require.NotNil(t, bg.Consensus)
+		return bg, shutdown
+	}
+	update := func(bg *proxyd.BackendGroup) {
+		ctx := context.Background()
+		for _, be := range bg.Backends {
+			bg.Consensus.UpdateBackend(ctx, be)
+		}
+		bg.Consensus.UpdateBackendGroupConsensus(ctx)
+	}
+
+	t.Run("no snapshot on first start", func(t *testing.T) {
+		bg, shutdown := start()
+		require.Equal(t, "0x0", bg.Consensus.GetLatestBlockNumber().String())
+
+		update(bg)
+		require.Equal(t, "0x101", bg.Consensus.GetLatestBlockNumber().String())
+		bg.Consensus.Ban(bg.Backends[1])
+
+		// the snapshot is saved on shutdown
+		shutdown()
+		require.FileExists(t, path.Join(snapshotDir, "node.json"))
+	})
+
+	t.Run("restart from the snapshot", func(t *testing.T) {
+		bg, shutdown := start()
+		defer shutdown()
+
+		// the consensus and the bans are restored before the first poll
+		require.Equal(t, "0x101", bg.Consensus.GetLatestBlockNumber().String())
+		require.Equal(t, "0xe1", bg.Consensus.GetSafeBlockNumber().String())
+		require.Equal(t, "0xc1", bg.Consensus.GetFinalizedBlockNumber().String())
+		require.False(t, bg.Consensus.IsBanned(bg.Backends[0]))
+		require.True(t, bg.Consensus.IsBanned(bg.Backends[1]))
+
+		update(bg)
+		require.Equal(t, "0x101", bg.Consensus.GetLatestBlockNumber().String())
+		require.Equal(t, []*proxyd.Backend{bg.Backends[0]}, bg.Consensus.GetConsensusGroup())
+	})
+
+	t.Run("ignore a snapshot that is not on the live chain", func(t *testing.T) {
+		// the snapshot head was reorged while proxyd was down
+		for _, h := range []*ms.MockedHandler{h1, h2} {
+			h.AddOverride(&ms.MethodTemplate{
+				Method: "eth_getBlockByNumber",
+				Block:  "0x101",
+				Response: buildResponse(map[string]string{
+					"number": "0x101",
+					"hash":   "fork_0x101",
+				}),
+			})
+		}
+		defer h1.ResetOverrides()
+		defer h2.ResetOverrides()
+
+		bg, shutdown := start()
+		defer shutdown()
+
+		require.Equal(t, "0x0", bg.Consensus.GetLatestBlockNumber().String())
+		// bans don't depend on the chain, and are kept
+		require.True(t, bg.Consensus.IsBanned(bg.Backends[1]))
+	})
+}
@@ -315,6 +315,18 @@ func TestConsensus(t *testing.T) {
 		require.Equal(t, 0, len(consensusGroup))
 	})
 
+	t.Run("keep backends if finalized hashes are tied", func(t *testing.T) {
+		reset()
+		overrideBlockHash("node2", "finalized", "0xc1", "fork_0xc1")
+		update()
+
+		// with no majority, there is no way to tell which backend is right
+		consensusGroup := bg.Consensus.GetConsensusGroup()
+		require.False(t, bg.Consensus.IsBanned(nodes["node1"].backend))
+		require.False(t, bg.Consensus.IsBanned(nodes["node2"].backend))
+		require.Equal(t, 2, len(consensusGroup))
+	})
+
 	t.Run("ban backend if tags are messed - safe < finalized", func(t *testing.T) {
 		reset()
 		overrideBlock("node1", "finalized", "0xb1")
@@ -683,6 +695,35 @@ func TestConsensus(t *testing.T) {
 		require.Equal(t, 10, len(nodes["node2"].mockBackend.Requests()), msg)
 	})
 
+	t.Run("block hashes are checked once per height", func(t *testing.T) {
+		reset()
+		overrideBlock("node2", "finalized", "0xc2")
+		overrideBlock("node2", "safe", "0xe2")
+
+		// node2 is ahead, so it's asked for the lowest safe and finalized blocks of the group
+		fetched := func() []string {
+			var blocks []string
+			for _, r := range nodes["node2"].mockBackend.Requests() {
+				var req proxyd.RPCReq
+				require.NoError(t, json.Unmarshal(r.Body, &req))
+				var params []interface{}
+				require.NoError(t, json.Unmarshal(req.Params, &params))
+				if req.Method == "eth_getBlockByNumber" && (params[0] == "0xc1" || params[0] == "0xe1") {
+					blocks = append(blocks, params[0].(string))
+				}
+			}
+			return blocks
+		}
+		update()
+		require.ElementsMatch(t, []string{"0xc1", "0xe1"}, fetched())
+
+		nodes["node2"].mockBackend.Reset()
+		update()
+		require.Empty(t, fetched())
+		require.Equal(t, "0xe1", bg.Consensus.GetSafeBlockNumber().String())
+		require.Equal(t, "0xc1", bg.Consensus.GetFinalizedBlockNumber().String())
+	})
+
 	t.Run("rewrite response of eth_blockNumber", func(t *testing.T) {
 		reset()
 		update()
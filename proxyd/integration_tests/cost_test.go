@@ -0,0 +1,95 @@
+package integration_tests
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/ethereum-optimism/optimism/proxyd"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCostAwareRouting(t *testing.T) {
+	redis, err := miniredis.Run()
+	require.NoError(t, err)
+	defer redis.Close()
+
+	paidBackend := NewMockBackend(BatchedResponseHandler(200, goodResponse))
+	defer paidBackend.Close()
+	spentBackend := NewMockBackend(BatchedResponseHandler(200, goodResponse))
+	defer spentBackend.Close()
+	freeBackend := NewMockBackend(BatchedResponseHandler(200, goodResponse))
+	defer freeBackend.Close()
+
+	require.NoError(t, os.Setenv("REDIS_URL", fmt.Sprintf("redis://127.0.0.1:%s", redis.Port())))
+	require.NoError(t, os.Setenv("PAID_BACKEND_RPC_URL", paidBackend.URL()))
+	require.NoError(t, os.Setenv("SPENT_BACKEND_RPC_URL", spentBackend.URL()))
+	require.NoError(t, os.Setenv("FREE_BACKEND_RPC_URL", freeBackend.URL()))
+
+	// other proxyd instances spent the monthly budget of a backend
+	month := time.Now().UTC().Format("2006-01")
+	require.NoError(t, redis.Set("proxyd:backend_spend:{spent}:"+month, "12.5"))
+
+	config := ReadConfig("cost")
+	client := NewProxydClient("http://127.0.0.1:8545")
+	_, shutdown, err := proxyd.Start(config)
+	require.NoError(t, err)
+	defer shutdown()
+
+	send := func(method string) {
+		res, code, err := client.SendRPC(method, nil)
+		require.NoError(t, err)
+		require.Equal(t, 200, code)
+		RequireEqualJSON(t, []byte(goodResponse), res)
+	}
+
+	// the spend counters are global to the test binary
+	chainIDSpend := backendSpend(t, "paid", "eth_chainId")
+	blockSpend := backendSpend(t, "paid", "eth_getBlockByNumber")
+
+	// the free backend is used while it's healthy
+	send("eth_chainId")
+	require.Len(t, freeBackend.Requests(), 1)
+	require.Len(t, paidBackend.Requests(), 0)
+
+	// the paid backend with budget left takes over when it fails
+	freeBackend.SetHandler(SingleResponseHandler(503, "unavailable"))
+	send("eth_chainId")
+	send("eth_getBlockByNumber")
+	require.Len(t, paidBackend.Requests(), 2)
+	require.Len(t, spentBackend.Requests(), 0)
+
+	require.Equal(t, chainIDSpend+0.5, backendSpend(t, "paid", "eth_chainId"))
+	require.Equal(t, blockSpend+2, backendSpend(t, "paid", "eth_getBlockByNumber"))
+	require.Equal(t, 0.0, backendSpend(t, "free", "eth_chainId"))
+
+	// the backend over its budget is excluded, even if the others fail
+	paidBackend.SetHandler(SingleResponseHandler(503, "unavailable"))
+	_, code, err := client.SendRPC("eth_chainId", nil)
+	require.NoError(t, err)
+	require.Equal(t, 503, code)
+	require.Len(t, spentBackend.Requests(), 0)
+}
+
+func backendSpend(t *testing.T, backend string, method string) float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != "proxyd_backend_spend_total" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["backend_name"] == backend && labels["method_name"] == method {
+				return m.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
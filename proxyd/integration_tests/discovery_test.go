@@ -0,0 +1,235 @@
+package integration_tests
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/ethereum-optimism/optimism/proxyd"
+	ms "github.com/ethereum-optimism/optimism/proxyd/tools/mockserver/handler"
+)
+
+// dnsStub answers SRV queries for a single name with a mutable list of ports on localhost
+type dnsStub struct {
+	conn  net.PacketConn
+	name  string
+	mu    sync.Mutex
+	ports []uint16
+}
+
+func newDNSStub(t *testing.T, name string) *dnsStub {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	stub := &dnsStub{conn: conn, name: name}
+	go stub.serve()
+	return stub
+}
+
+func (s *dnsStub) Addr() string {
+	return s.conn.LocalAddr().String()
+}
+
+func (s *dnsStub) Close() {
+	s.conn.Close()
+}
+
+func (s *dnsStub) SetPorts(ports ...uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ports = ports
+}
+
+func (s *dnsStub) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var req dnsmessage.Message
+		if err := req.Unpack(buf[:n]); err != nil || len(req.Questions) != 1 {
+			continue
+		}
+		q := req.Questions[0]
+		res := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: req.ID, Response: true, Authoritative: true},
+			Questions: req.Questions,
+		}
+		if q.Name.String() != s.name {
+			res.RCode = dnsmessage.RCodeNameError
+		} else if q.Type == dnsmessage.TypeSRV {
+			s.mu.Lock()
+			for _, port := range s.ports {
+				res.Answers = append(res.Answers, dnsmessage.Resource{
+					Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET, TTL: 1},
+					Body:   &dnsmessage.SRVResource{Target: dnsmessage.MustNewName("localhost."), Port: port},
+				})
+			}
+			s.mu.Unlock()
+		}
+		packed, err := res.Pack()
+		if err != nil {
+			continue
+		}
+		_, _ = s.conn.WriteTo(packed, addr)
+	}
+}
+
+func mockBackendPort(t *testing.T, m *MockBackend) uint16 {
+	u, err := url.Parse(m.URL())
+	require.NoError(t, err)
+	port, err := strconv.Atoi(u.Port())
+	require.NoError(t, err)
+	return uint16(port)
+}
+
+func backendNames(bg *proxyd.BackendGroup) []string {
+	names := make([]string, 0)
+	for _, be := range bg.GetBackends() {
+		names = append(names, be.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestBackendDiscovery(t *testing.T) {
+	dir, err := os.Getwd()
+	require.NoError(t, err)
+	responses := path.Join(dir, "testdata/consensus_responses.yml")
+
+	nodes := make([]*MockBackend, 2)
+	for i := range nodes {
+		h := &ms.MockedHandler{
+			Overrides:    []*ms.MethodTemplate{},
+			Autoload:     true,
+			AutoloadFile: responses,
+		}
+		nodes[i] = NewMockBackend(http.HandlerFunc(h.Handler))
+		defer nodes[i].Close()
+	}
+
+	discoveryFile := filepath.Join(t.TempDir(), "backends.json")
+	writeDiscoveryFile := func(backends ...*proxyd.DiscoveredBackend) {
+		data, err := json.Marshal(map[string]interface{}{"backends": backends})
+		require.NoError(t, err)
+		// replace the file like config management tools do
+		tmp := discoveryFile + ".tmp"
+		require.NoError(t, os.WriteFile(tmp, data, 0o644))
+		require.NoError(t, os.Rename(tmp, discoveryFile))
+	}
+	node1 := &proxyd.DiscoveredBackend{Name: "node1", RPCURL: nodes[0].URL()}
+	node2 := &proxyd.DiscoveredBackend{Name: "node2", RPCURL: nodes[1].URL()}
+	writeDiscoveryFile(node1)
+
+	dns := newDNSStub(t, "_rpc._tcp.nodes.proxyd.test.")
+	defer dns.Close()
+	port1, port2 := mockBackendPort(t, nodes[0]), mockBackendPort(t, nodes[1])
+	dns.SetPorts(port1)
+
+	config := ReadConfig("discovery")
+	config.BackendGroups["node"].Discovery.Path = discoveryFile
+	config.BackendGroups["dns"].Discovery.Nameserver = dns.Addr()
+	svr, shutdown, err := proxyd.Start(config)
+	require.NoError(t, err)
+	defer shutdown()
+
+	client := NewProxydClient("http://127.0.0.1:8545")
+	ctx := context.Background()
+
+	t.Run("file", func(t *testing.T) {
+		bg := svr.BackendGroups["node"]
+		update := func() {
+			for _, be := range bg.GetBackends() {
+				bg.Consensus.UpdateBackend(ctx, be)
+			}
+			bg.Consensus.UpdateBackendGroupConsensus(ctx)
+		}
+		consensusNames := func() []string {
+			names := make([]string, 0)
+			for _, be := range bg.Consensus.GetConsensusGroup() {
+				names = append(names, be.Name)
+			}
+			sort.Strings(names)
+			return names
+		}
+
+		// the first sync happens on start
+		require.Equal(t, []string{"node1"}, backendNames(bg))
+		update()
+		require.Equal(t, []string{"node1"}, consensusNames())
+
+		// a new backend joins the group and the consensus
+		writeDiscoveryFile(node1, node2)
+		require.Eventually(t, func() bool {
+			return len(bg.GetBackends()) == 2
+		}, 5*time.Second, 10*time.Millisecond)
+		require.Equal(t, []string{"node1", "node2"}, backendNames(bg))
+		update()
+		require.Equal(t, []string{"node1", "node2"}, consensusNames())
+
+		// a removed backend leaves the consensus group right away and stops serving
+		writeDiscoveryFile(node2)
+		require.Eventually(t, func() bool {
+			return len(bg.GetBackends()) == 1
+		}, 5*time.Second, 10*time.Millisecond)
+		require.Equal(t, []string{"node2"}, backendNames(bg))
+		require.Equal(t, []string{"node2"}, consensusNames())
+
+		nodes[0].Reset()
+		nodes[1].Reset()
+		for i := 0; i < 4; i++ {
+			_, code, err := client.SendRPC("eth_syncing", nil)
+			require.NoError(t, err)
+			require.Equal(t, 200, code)
+		}
+		require.Empty(t, nodes[0].Requests())
+		require.Len(t, nodes[1].Requests(), 4)
+
+		update()
+		require.Equal(t, []string{"node2"}, consensusNames())
+
+		// an unreadable file leaves the members unchanged
+		require.NoError(t, os.WriteFile(discoveryFile, []byte("{not json"), 0o644))
+		time.Sleep(300 * time.Millisecond)
+		require.Equal(t, []string{"node2"}, backendNames(bg))
+	})
+
+	t.Run("dns", func(t *testing.T) {
+		bg := svr.BackendGroups["dns"]
+		name1 := net.JoinHostPort("localhost", strconv.Itoa(int(port1)))
+		name2 := net.JoinHostPort("localhost", strconv.Itoa(int(port2)))
+		require.Equal(t, []string{name1}, backendNames(bg))
+
+		dns.SetPorts(port2)
+		require.Eventually(t, func() bool {
+			names := backendNames(bg)
+			return len(names) == 1 && names[0] == name2
+		}, 5*time.Second, 10*time.Millisecond)
+
+		nodes[0].Reset()
+		nodes[1].Reset()
+		_, code, err := client.SendRPC("eth_getBlockByNumber", []interface{}{"latest", false})
+		require.NoError(t, err)
+		require.Equal(t, 200, code)
+		require.Empty(t, nodes[0].Requests())
+		require.Len(t, nodes[1].Requests(), 1)
+
+		dns.SetPorts(port1, port2)
+		require.Eventually(t, func() bool {
+			return len(bg.GetBackends()) == 2
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+}
@@ -372,3 +372,83 @@ func TestFallback(t *testing.T) {
 		}
 	})
 }
+
+func TestFallbackHysteresis(t *testing.T) {
+	dir, err := os.Getwd()
+	require.NoError(t, err)
+	responses := path.Join(dir, "testdata/consensus_responses.yml")
+
+	names := []string{"normal1", "normal2", "fallback"}
+	handlers := make(map[string]*ms.MockedHandler, len(names))
+	for i, name := range names {
+		h := &ms.MockedHandler{Overrides: []*ms.MethodTemplate{}, Autoload: true, AutoloadFile: responses}
+		node := NewMockBackend(http.HandlerFunc(h.Handler))
+		defer node.Close()
+		handlers[name] = h
+		require.NoError(t, os.Setenv(fmt.Sprintf("NODE%d_URL", i+1), node.URL()))
+	}
+
+	config := ReadConfig("fallback_hysteresis")
+	svr, shutdown, err := proxyd.Start(config)
+	require.NoError(t, err)
+	defer shutdown()
+	bg := svr.BackendGroups["node"]
+	require.NotNil(t, bg.Consensus)
+
+	ctx := context.Background()
+	update := func() {
+		for _, be := range bg.Primaries() {
+			bg.Consensus.UpdateBackend(ctx, be)
+		}
+		for _, be := range bg.Fallbacks() {
+			bg.Consensus.UpdateBackend(ctx, be)
+		}
+		bg.Consensus.UpdateBackendGroupConsensus(ctx)
+	}
+	setPeerCount := func(node string, count int) {
+		handlers[node].ResetOverrides()
+		handlers[node].AddOverride(&ms.MethodTemplate{
+			Method:   "net_peerCount",
+			Response: buildResponse(hexutil.Uint64(count).String()),
+		})
+	}
+	requireMode := func(mode proxyd.FallbackMode, members ...string) {
+		t.Helper()
+		require.Equal(t, mode, bg.Consensus.GetFallbackMode())
+		group := make([]string, 0, len(members))
+		for _, be := range bg.Consensus.GetConsensusGroup() {
+			group = append(group, be.Name)
+		}
+		require.ElementsMatch(t, members, group)
+	}
+
+	update()
+	requireMode(proxyd.FallbackModePrimary, "normal1", "normal2")
+
+	// a single unhealthy primary is below fallback_min_primaries
+	setPeerCount("normal2", 0)
+	update()
+	requireMode(proxyd.FallbackModeFallback, "fallback")
+
+	// the primaries must stay healthy for the fail-back period
+	setPeerCount("normal2", 5)
+	update()
+	requireMode(proxyd.FallbackModeRecovering, "fallback")
+
+	// flapping primaries restart the fail-back period
+	setPeerCount("normal2", 0)
+	update()
+	requireMode(proxyd.FallbackModeFallback, "fallback")
+	setPeerCount("normal2", 5)
+	update()
+	requireMode(proxyd.FallbackModeRecovering, "fallback")
+	update()
+	requireMode(proxyd.FallbackModeRecovering, "fallback")
+
+	// fails back once the primaries have been healthy for the period
+	require.Eventually(t, func() bool {
+		update()
+		return bg.Consensus.GetFallbackMode() == proxyd.FallbackModePrimary
+	}, 5*time.Second, 50*time.Millisecond)
+	requireMode(proxyd.FallbackModePrimary, "normal1", "normal2")
+}
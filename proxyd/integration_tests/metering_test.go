@@ -0,0 +1,56 @@
+package integration_tests
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/alicebob/miniredis"
+	"github.com/ethereum-optimism/optimism/proxyd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetering(t *testing.T) {
+	redis, err := miniredis.Run()
+	require.NoError(t, err)
+	defer redis.Close()
+
+	goodBackend := NewMockBackend(BatchedResponseHandler(200, goodResponse))
+	defer goodBackend.Close()
+
+	require.NoError(t, os.Setenv("GOOD_BACKEND_RPC_URL", goodBackend.URL()))
+	require.NoError(t, os.Setenv("REDIS_URL", fmt.Sprintf("redis://127.0.0.1:%s", redis.Port())))
+
+	config := ReadConfig("metering")
+	_, shutdown, err := proxyd.Start(config)
+	require.NoError(t, err)
+	defer shutdown()
+
+	send := func(body string) *http.Response {
+		res, err := http.Post("http://127.0.0.1:8545", "application/json", bytes.NewReader([]byte(body)))
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		return res
+	}
+	single := `{"jsonrpc": "2.0", "method": "eth_chainId", "params": [], "id": 999}`
+	batch := `[{"jsonrpc": "2.0", "method": "eth_chainId", "params": [], "id": 1}, {"jsonrpc": "2.0", "method": "eth_chainId", "params": [], "id": 2}]`
+
+	res := send(single)
+	require.Equal(t, 200, res.StatusCode)
+	require.Empty(t, res.Header.Get("X-Proxyd-Quota-Warning"))
+
+	// each call of a batch counts against the quota
+	res = send(batch)
+	require.Equal(t, 200, res.StatusCode)
+	require.Empty(t, res.Header.Get("X-Proxyd-Quota-Warning"))
+
+	res = send(single)
+	require.Equal(t, 200, res.StatusCode)
+	require.Equal(t, "true", res.Header.Get("X-Proxyd-Quota-Warning"))
+
+	res = send(single)
+	require.Equal(t, 429, res.StatusCode)
+	require.Equal(t, 3, len(goodBackend.Requests()))
+}
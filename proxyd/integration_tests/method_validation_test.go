@@ -0,0 +1,87 @@
+package integration_tests
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/ethereum-optimism/optimism/proxyd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMethodValidation(t *testing.T) {
+	goodBackend := NewMockBackend(BatchedResponseHandler(200, goodResponse))
+	defer goodBackend.Close()
+
+	require.NoError(t, os.Setenv("GOOD_BACKEND_RPC_URL", goodBackend.URL()))
+
+	config := ReadConfig("method_validation")
+	client := NewProxydClient("http://127.0.0.1:8545")
+	_, shutdown, err := proxyd.Start(config)
+	require.NoError(t, err)
+	defer shutdown()
+
+	t.Run("valid calls are forwarded", func(t *testing.T) {
+		goodBackend.Reset()
+		res, code, err := client.SendRPC("eth_call", []interface{}{
+			map[string]string{"to": "0x4200000000000000000000000000000000000042", "gas": "0x2faf080"},
+			"latest",
+		})
+		require.NoError(t, err)
+		require.Equal(t, 200, code)
+		RequireEqualJSON(t, []byte(goodResponse), res)
+		require.Equal(t, 1, len(goodBackend.Requests()))
+	})
+
+	t.Run("calls without gas are capped", func(t *testing.T) {
+		goodBackend.Reset()
+		res, code, err := client.SendRPC("eth_call", []interface{}{
+			map[string]string{"to": "0x4200000000000000000000000000000000000042"},
+			"latest",
+		})
+		require.NoError(t, err)
+		require.Equal(t, 200, code)
+		RequireEqualJSON(t, []byte(goodResponse), res)
+		require.Equal(t, 1, len(goodBackend.Requests()))
+
+		var req proxyd.RPCReq
+		require.NoError(t, json.Unmarshal(goodBackend.Requests()[0].Body, &req))
+		require.JSONEq(t, `[{"to":"0x4200000000000000000000000000000000000042","gas":"0x2faf080"},"latest"]`, string(req.Params))
+	})
+
+	t.Run("gas over the cap", func(t *testing.T) {
+		goodBackend.Reset()
+		res, code, err := client.SendRPC("eth_call", []interface{}{
+			map[string]string{"to": "0x4200000000000000000000000000000000000042", "gas": "0x3b9aca00"},
+			"latest",
+		})
+		require.NoError(t, err)
+		require.Equal(t, 400, code)
+		RequireEqualJSON(t, []byte(`{"jsonrpc":"2.0","error":{"code":-32602,"message":"gas 1000000000 exceeds the maximum of 50000000"},"id":999}`), res)
+		require.Equal(t, 0, len(goodBackend.Requests()))
+	})
+
+	t.Run("invalid calls of a batch are rejected", func(t *testing.T) {
+		router := NewBatchRPCResponseRouter()
+		router.SetRoute("eth_chainId", "3", "0xa")
+		goodBackend.SetHandler(router)
+		goodBackend.Reset()
+		res, code, err := client.SendBatchRPC(
+			NewRPCReq("1", "eth_getLogs", []interface{}{map[string]interface{}{"fromBlock": "0x1"}}),
+			NewRPCReq("2", "eth_getLogs", []interface{}{map[string]interface{}{
+				"blockHash": "0x01",
+				"address":   []string{"0x01", "0x02", "0x03"},
+			}}),
+			NewRPCReq("3", "eth_chainId", nil),
+		)
+		require.NoError(t, err)
+		require.Equal(t, 200, code)
+		RequireEqualJSON(t, []byte(asArray(
+			`{"jsonrpc":"2.0","error":{"code":-32602,"message":"filter must specify fromBlock and toBlock, or blockHash"},"id":1}`,
+			`{"jsonrpc":"2.0","error":{"code":-32602,"message":"filter has 3 addresses, the maximum is 2"},"id":2}`,
+			`{"jsonrpc":"2.0","result":"0xa","id":3}`,
+		)), res)
+		require.Equal(t, 1, router.GetNumCalls("eth_chainId", "3"))
+		require.Equal(t, 1, len(goodBackend.Requests()))
+	})
+}
@@ -0,0 +1,133 @@
+package integration_tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum-optimism/optimism/proxyd"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMirror(t *testing.T) {
+	goodBackend := NewMockBackend(BatchedResponseHandler(200, goodResponse))
+	defer goodBackend.Close()
+	shadowHandler := BatchedResponseHandler(200, `{"jsonrpc": "2.0", "result": "shadow", "id": 999}`)
+	shadowBackend := NewMockBackend(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the shadow group must not add to the client latency
+		time.Sleep(500 * time.Millisecond)
+		shadowHandler(w, r)
+	}))
+	defer shadowBackend.Close()
+	shadow2Backend := NewMockBackend(BatchedResponseHandler(200, goodResponse))
+	defer shadow2Backend.Close()
+
+	require.NoError(t, os.Setenv("GOOD_BACKEND_RPC_URL", goodBackend.URL()))
+	require.NoError(t, os.Setenv("SHADOW_BACKEND_RPC_URL", shadowBackend.URL()))
+	require.NoError(t, os.Setenv("SHADOW2_BACKEND_RPC_URL", shadow2Backend.URL()))
+
+	config := ReadConfig("mirror")
+	client := NewProxydClient("http://127.0.0.1:8545")
+	_, shutdown, err := proxyd.Start(config)
+	require.NoError(t, err)
+	defer shutdown()
+
+	mismatches := mirrorResults(t, "eth_chainId", proxyd.MirrorResultMismatch)
+
+	start := time.Now()
+	res, code, err := client.SendRPC("eth_chainId", nil)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	RequireEqualJSON(t, []byte(goodResponse), res)
+	require.Less(t, time.Since(start), 500*time.Millisecond)
+
+	_, code, err = client.SendRPC("eth_getBalance", nil)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+
+	require.Eventually(t, func() bool {
+		return mirrorResults(t, "eth_chainId", proxyd.MirrorResultMismatch) == mismatches+1
+	}, 2*time.Second, 50*time.Millisecond)
+
+	require.Equal(t, 2, len(goodBackend.Requests()))
+	require.Equal(t, 1, len(shadowBackend.Requests()))
+	require.Contains(t, string(shadowBackend.Requests()[0].Body), "eth_chainId")
+
+	// every matching mirror gets the request, not only the first one
+	require.Eventually(t, func() bool {
+		return len(shadow2Backend.Requests()) == 1
+	}, 2*time.Second, 50*time.Millisecond)
+	require.Contains(t, string(shadow2Backend.Requests()[0].Body), "eth_chainId")
+}
+
+func TestMirrorConcurrency(t *testing.T) {
+	goodBackend := NewMockBackend(BatchedResponseHandler(200, goodResponse))
+	defer goodBackend.Close()
+
+	// the shadow backend holds the mirrored requests until released
+	release := make(chan struct{})
+	var shadowInFlight atomic.Int64
+	shadowBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		shadowInFlight.Add(1)
+		defer shadowInFlight.Add(-1)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		BatchedResponseHandler(200, goodResponse)(w, r)
+	}))
+	defer shadowBackend.Close()
+	defer close(release)
+
+	require.NoError(t, os.Setenv("GOOD_BACKEND_RPC_URL", goodBackend.URL()))
+	require.NoError(t, os.Setenv("SHADOW_BACKEND_RPC_URL", shadowBackend.URL))
+
+	config := ReadConfig("mirror_concurrency")
+	client := NewProxydClient("http://127.0.0.1:8545")
+	_, shutdown, err := proxyd.Start(config)
+	require.NoError(t, err)
+	defer shutdown()
+
+	// the shadow requests outnumber max_concurrent_rpcs
+	for i := 0; i < 4; i++ {
+		_, code, err := client.SendRPC("eth_chainId", nil)
+		require.NoError(t, err)
+		require.Equal(t, 200, code)
+	}
+	require.Eventually(t, func() bool {
+		return shadowInFlight.Load() == 4
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// the clients are still admitted right away
+	start := time.Now()
+	res, code, err := client.SendRPC("eth_getBalance", nil)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	RequireEqualJSON(t, []byte(goodResponse), res)
+	require.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+func mirrorResults(t *testing.T, method string, result string) float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != "proxyd_mirror_requests_total" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["method_name"] == method && labels["result"] == result {
+				return m.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,44 @@
+package integration_tests
+
+import (
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/ethereum-optimism/optimism/proxyd"
+	"github.com/stretchr/testify/require"
+)
+
+const retryBudgetExhaustedResponse = `{"error":{"code":-32025,"message":"retry budget exhausted"},"id":999,"jsonrpc":"2.0"}`
+
+func TestRetryBudget(t *testing.T) {
+	goodBackend := NewMockBackend(BatchedResponseHandler(200, goodResponse))
+	defer goodBackend.Close()
+	badBackend := NewMockBackend(SingleResponseHandler(503, "unavailable"))
+	defer badBackend.Close()
+
+	require.NoError(t, os.Setenv("GOOD_BACKEND_RPC_URL", goodBackend.URL()))
+	require.NoError(t, os.Setenv("BAD_BACKEND_RPC_URL", badBackend.URL()))
+
+	config := ReadConfig("retry_budget")
+	client := NewProxydClient("http://127.0.0.1:8545")
+	_, shutdown, err := proxyd.Start(config)
+	require.NoError(t, err)
+	defer shutdown()
+
+	// the budget starts with 2 retries: one on the bad backend, and one on the good backend
+	res, statusCode, err := client.SendRPC("eth_chainId", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, statusCode)
+	RequireEqualJSON(t, []byte(goodResponse), res)
+	require.Len(t, badBackend.Requests(), 2)
+	require.Len(t, goodBackend.Requests(), 1)
+
+	// a single request doesn't earn enough for another retry, so the next failure isn't retried
+	res, statusCode, err = client.SendRPC("eth_chainId", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusServiceUnavailable, statusCode)
+	RequireEqualJSON(t, []byte(retryBudgetExhaustedResponse), res)
+	require.Len(t, badBackend.Requests(), 3)
+	require.Len(t, goodBackend.Requests(), 1)
+}
@@ -0,0 +1,79 @@
+package integration_tests
+
+import (
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/ethereum-optimism/optimism/proxyd"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	headerNotFoundResponse    = `{"jsonrpc": "2.0", "error": {"code": -32000, "message": "header not found"}, "id": 999}`
+	capacityExceededResponse  = `{"jsonrpc": "2.0", "error": {"code": -32005, "message": "capacity exceeded"}, "id": 999}`
+	executionRevertedResponse = `{"jsonrpc": "2.0", "error": {"code": -32000, "message": "execution reverted"}, "id": 999}`
+)
+
+func TestRetryableErrors(t *testing.T) {
+	firstBackend := NewMockBackend(nil)
+	defer firstBackend.Close()
+	secondBackend := NewMockBackend(BatchedResponseHandler(200, goodResponse))
+	defer secondBackend.Close()
+
+	require.NoError(t, os.Setenv("FIRST_BACKEND_RPC_URL", firstBackend.URL()))
+	require.NoError(t, os.Setenv("SECOND_BACKEND_RPC_URL", secondBackend.URL()))
+
+	config := ReadConfig("retryable_errors")
+	client := NewProxydClient("http://127.0.0.1:8545")
+	_, shutdown, err := proxyd.Start(config)
+	require.NoError(t, err)
+	defer shutdown()
+
+	send := func() []byte {
+		t.Helper()
+		res, statusCode, err := client.SendRPC("eth_getBlockByNumber", []interface{}{"0x1", false})
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, statusCode)
+		return res
+	}
+	reset := func(first, second string) {
+		firstBackend.SetHandler(SingleResponseHandler(200, first))
+		secondBackend.SetHandler(SingleResponseHandler(200, second))
+		firstBackend.Reset()
+		secondBackend.Reset()
+	}
+
+	t.Run("fails over on an error matching a backend option rule", func(t *testing.T) {
+		reset(headerNotFoundResponse, goodResponse)
+		RequireEqualJSON(t, []byte(goodResponse), send())
+		require.Len(t, firstBackend.Requests(), 1)
+		require.Len(t, secondBackend.Requests(), 1)
+	})
+
+	t.Run("fails over on an error matching a backend rule", func(t *testing.T) {
+		reset(capacityExceededResponse, goodResponse)
+		RequireEqualJSON(t, []byte(goodResponse), send())
+		require.Len(t, firstBackend.Requests(), 1)
+		require.Len(t, secondBackend.Requests(), 1)
+	})
+
+	t.Run("returns other errors to the client", func(t *testing.T) {
+		reset(executionRevertedResponse, goodResponse)
+		RequireEqualJSON(t, []byte(executionRevertedResponse), send())
+		require.Len(t, firstBackend.Requests(), 1)
+		require.Len(t, secondBackend.Requests(), 0)
+	})
+
+	t.Run("returns the last retryable error if no backend can answer", func(t *testing.T) {
+		reset(capacityExceededResponse, headerNotFoundResponse)
+		RequireEqualJSON(t, []byte(headerNotFoundResponse), send())
+		require.Len(t, firstBackend.Requests(), 1)
+		require.Len(t, secondBackend.Requests(), 1)
+	})
+
+	t.Run("rules are specific to the backend", func(t *testing.T) {
+		reset(headerNotFoundResponse, capacityExceededResponse)
+		RequireEqualJSON(t, []byte(capacityExceededResponse), send())
+	})
+}
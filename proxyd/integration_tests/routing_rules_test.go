@@ -0,0 +1,106 @@
+package integration_tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/ethereum-optimism/optimism/proxyd"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	partnerResponse      = `{"jsonrpc": "2.0", "result": "partner", "id": 999}`
+	ruleRejectedResponse = `{"jsonrpc":"2.0","error":{"code":-32022,"message":"debug_traceCall requires an API key"},"id":999}`
+)
+
+func TestRoutingRules(t *testing.T) {
+	goodBackend := NewMockBackend(BatchedResponseHandler(200, goodResponse))
+	defer goodBackend.Close()
+	partnerBackend := NewMockBackend(BatchedResponseHandler(200, partnerResponse))
+	defer partnerBackend.Close()
+
+	require.NoError(t, os.Setenv("GOOD_BACKEND_RPC_URL", goodBackend.URL()))
+	require.NoError(t, os.Setenv("PARTNER_BACKEND_RPC_URL", partnerBackend.URL()))
+
+	config := ReadConfig("routing_rules")
+	client := NewProxydClient("http://127.0.0.1:8545")
+	_, shutdown, err := proxyd.Start(config)
+	require.NoError(t, err)
+	defer shutdown()
+
+	t.Run("route by param", func(t *testing.T) {
+		res, code, err := client.SendRPC("eth_call", []interface{}{
+			map[string]string{"to": "0x4200000000000000000000000000000000000042"},
+			"latest",
+		})
+		require.NoError(t, err)
+		require.Equal(t, 200, code)
+		RequireEqualJSON(t, []byte(partnerResponse), res)
+
+		res, code, err = client.SendRPC("eth_call", []interface{}{
+			map[string]string{"to": "0x4200000000000000000000000000000000000006"},
+			"latest",
+		})
+		require.NoError(t, err)
+		require.Equal(t, 200, code)
+		RequireEqualJSON(t, []byte(goodResponse), res)
+	})
+
+	t.Run("reject anonymous", func(t *testing.T) {
+		goodBackend.Reset()
+		res, code, err := client.SendRPC("debug_traceCall", []interface{}{})
+		require.NoError(t, err)
+		require.Equal(t, 403, code)
+		RequireEqualJSON(t, []byte(ruleRejectedResponse), res)
+		require.Equal(t, 0, len(goodBackend.Requests()))
+	})
+
+	t.Run("rate limit by user agent", func(t *testing.T) {
+		h := make(http.Header)
+		h.Set("User-Agent", "limited_agent")
+		limitedClient := NewProxydClientWithHeaders("http://127.0.0.1:8545", h)
+
+		res, code, err := limitedClient.SendRPC("eth_chainId", nil)
+		require.NoError(t, err)
+		require.Equal(t, 200, code)
+		RequireEqualJSON(t, []byte(goodResponse), res)
+
+		res, code, err = limitedClient.SendRPC("eth_chainId", nil)
+		require.NoError(t, err)
+		require.Equal(t, 429, code)
+		// the message of ErrOverRateLimit is a global that may be customized by other tests
+		RequireEqualJSON(t, mustMarshalRPCErr(t, proxyd.ErrOverRateLimit), res)
+
+		// other user agents are not affected
+		res, code, err = client.SendRPC("eth_chainId", nil)
+		require.NoError(t, err)
+		require.Equal(t, 200, code)
+		RequireEqualJSON(t, []byte(goodResponse), res)
+	})
+
+	t.Run("route does not bypass the whitelist", func(t *testing.T) {
+		h := make(http.Header)
+		h.Set("User-Agent", "partner_agent")
+		partnerClient := NewProxydClientWithHeaders("http://127.0.0.1:8545", h)
+
+		res, code, err := partnerClient.SendRPC("eth_chainId", nil)
+		require.NoError(t, err)
+		require.Equal(t, 200, code)
+		RequireEqualJSON(t, []byte(partnerResponse), res)
+
+		partnerBackend.Reset()
+		res, code, err = partnerClient.SendRPC("admin_peers", nil)
+		require.NoError(t, err)
+		require.Equal(t, 403, code)
+		RequireEqualJSON(t, mustMarshalRPCErr(t, proxyd.ErrMethodNotWhitelisted), res)
+		require.Equal(t, 0, len(partnerBackend.Requests()))
+	})
+}
+
+func mustMarshalRPCErr(t *testing.T, err *proxyd.RPCErr) []byte {
+	res, jsonErr := json.Marshal(proxyd.NewRPCErrorRes(json.RawMessage("999"), err))
+	require.NoError(t, jsonErr)
+	return res
+}
@@ -0,0 +1,57 @@
+package integration_tests
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/ethereum-optimism/optimism/proxyd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSharedRateLimit(t *testing.T) {
+	redis, err := miniredis.Run()
+	require.NoError(t, err)
+	defer redis.Close()
+
+	limitedBackend := NewMockBackend(BatchedResponseHandler(200, goodResponse))
+	defer limitedBackend.Close()
+	goodBackend := NewMockBackend(BatchedResponseHandler(200, goodResponse))
+	defer goodBackend.Close()
+
+	require.NoError(t, os.Setenv("REDIS_URL", fmt.Sprintf("redis://127.0.0.1:%s", redis.Port())))
+	require.NoError(t, os.Setenv("LIMITED_BACKEND_RPC_URL", limitedBackend.URL()))
+	require.NoError(t, os.Setenv("GOOD_BACKEND_RPC_URL", goodBackend.URL()))
+
+	config := ReadConfig("shared_rate_limit")
+	client := NewProxydClient("http://127.0.0.1:8545")
+	_, shutdown, err := proxyd.Start(config)
+	require.NoError(t, err)
+	defer shutdown()
+
+	// other proxyd instances used up the max_rps of the limited backend for the next seconds
+	now := time.Now().Unix()
+	for window := now; window < now+5; window++ {
+		require.NoError(t, redis.Set(fmt.Sprintf("proxyd:backend_rate_limit:{limited}:%d", window), "4"))
+	}
+
+	for i := 0; i < 3; i++ {
+		res, code, err := client.SendRPC("eth_chainId", nil)
+		require.NoError(t, err)
+		require.Equal(t, 200, code)
+		RequireEqualJSON(t, []byte(goodResponse), res)
+	}
+	require.Len(t, limitedBackend.Requests(), 0)
+	require.Len(t, goodBackend.Requests(), 3)
+
+	// the limited backend serves again once the fleet is under its max_rps, from the next second
+	redis.FlushAll()
+	time.Sleep(time.Until(time.Unix(time.Now().Unix()+1, 0)))
+	res, code, err := client.SendRPC("eth_chainId", nil)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	RequireEqualJSON(t, []byte(goodResponse), res)
+	require.Len(t, limitedBackend.Requests(), 1)
+}
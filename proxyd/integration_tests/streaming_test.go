@@ -0,0 +1,57 @@
+package integration_tests
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ethereum-optimism/optimism/proxyd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseStreaming(t *testing.T) {
+	trace := `{"gas":21000,"failed":false,"returnValue":"","structLogs":[` +
+		strings.Repeat(`{"pc":0,"op":"PUSH1","gas":100,"stack":["0x1"]},`, 5000) +
+		`{"pc":1,"op":"STOP","gas":97,"stack":[]}]}`
+	headerNotFound := `{"jsonrpc":"2.0","error":{"code":-32000,"message":"header not found"},"id":1}`
+
+	laggingBackend := NewMockBackend(SingleResponseHandler(200, headerNotFound))
+	defer laggingBackend.Close()
+	goodBackend := NewMockBackend(SingleResponseHandler(200, `{"jsonrpc":"2.0","id":1,"result":`+trace+`}`))
+	defer goodBackend.Close()
+
+	require.NoError(t, os.Setenv("LAGGING_BACKEND_RPC_URL", laggingBackend.URL()))
+	require.NoError(t, os.Setenv("GOOD_BACKEND_RPC_URL", goodBackend.URL()))
+
+	config := ReadConfig("streaming")
+	client := NewProxydClient("http://127.0.0.1:8545")
+	_, shutdown, err := proxyd.Start(config)
+	require.NoError(t, err)
+	defer shutdown()
+
+	// the error of the lagging backend is retried, and the result of the good one streamed with the id of the client
+	res, code, err := client.SendRequest([]byte(`{"jsonrpc":"2.0","method":"debug_traceTransaction","params":["0x1"],"id":"trace-1"}`))
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	require.Equal(t, `{"jsonrpc":"2.0","id":"trace-1","result":`+trace+"}\n", string(res))
+	require.Len(t, laggingBackend.Requests(), 1)
+	require.Len(t, goodBackend.Requests(), 1)
+
+	// error responses are served as usual
+	goodBackend.SetHandler(SingleResponseHandler(200, headerNotFound))
+	res, code, err = client.SendRPC("debug_traceTransaction", []interface{}{"0x1"})
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	RequireEqualJSON(t, []byte(headerNotFound), res)
+
+	// batches are not streamed
+	goodBackend.SetHandler(BatchedResponseHandler(200, goodResponse, goodResponse))
+	laggingBackend.SetHandler(BatchedResponseHandler(200, goodResponse, goodResponse))
+	res, code, err = client.SendBatchRPC(
+		NewRPCReq("1", "eth_chainId", nil),
+		NewRPCReq("2", "eth_chainId", nil),
+	)
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+	RequireEqualJSON(t, []byte(asArray(goodResponse, goodResponse)), res)
+}
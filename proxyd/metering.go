@@ -0,0 +1,486 @@
+package proxyd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	MeteringPeriodDaily   = "daily"
+	MeteringPeriodMonthly = "monthly"
+
+	meteringFieldRequests      = "requests"
+	meteringFieldResponseBytes = "response_bytes"
+
+	defaultMeteringFlushInterval  = 10 * time.Second
+	defaultMeteringSoftLimitRatio = 0.8
+
+	// usage is kept in Redis for a while after the period ends, so that it can be billed
+	meteringDailyRetention   = 40 * 24 * time.Hour
+	meteringMonthlyRetention = 400 * 24 * time.Hour
+
+	quotaWarningHdr = "X-Proxyd-Quota-Warning"
+)
+
+// Usage is the number of requests and response bytes served to a tenant
+type Usage struct {
+	Requests      int64 `json:"requests"`
+	ResponseBytes int64 `json:"response_bytes"`
+}
+
+func (u *Usage) add(o *Usage) {
+	u.Requests += o.Requests
+	u.ResponseBytes += o.ResponseBytes
+}
+
+// periodUsage is the usage of a tenant as of the last flush, for the periods it was read for
+type periodUsage struct {
+	day     string
+	month   string
+	daily   Usage
+	monthly Usage
+}
+
+// Metering accumulates the usage of each auth alias in memory, flushes it to Redis
+// periodically and enforces the daily and monthly quotas across all proxyd instances
+type Metering struct {
+	rdb            redis.UniversalClient
+	prefix         string
+	flushInterval  time.Duration
+	softLimitRatio float64
+	quotas         map[string]*QuotaConfig
+	defaultQuota   *QuotaConfig
+	aliases        []string
+
+	mtx     sync.Mutex
+	pending map[string]map[string]*Usage
+	totals  map[string]*periodUsage
+	warned  map[string]string
+
+	now  func() time.Time
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewMetering(rdb redis.UniversalClient, namespace string, cfg MeteringConfig, aliases []string) (*Metering, error) {
+	if rdb == nil {
+		return nil, errors.New("must specify a Redis URL if metering is enabled")
+	}
+	softLimitRatio := cfg.SoftLimitRatio
+	if softLimitRatio == 0 {
+		softLimitRatio = defaultMeteringSoftLimitRatio
+	}
+	if softLimitRatio < 0 || softLimitRatio > 1 {
+		return nil, errors.New("metering.soft_limit_ratio must be between 0 and 1")
+	}
+	flushInterval := time.Duration(cfg.FlushInterval)
+	if flushInterval == 0 {
+		flushInterval = defaultMeteringFlushInterval
+	}
+
+	prefix := "metering"
+	if namespace != "" {
+		prefix = namespace + ":metering"
+	}
+
+	return &Metering{
+		rdb:            rdb,
+		prefix:         prefix,
+		flushInterval:  flushInterval,
+		softLimitRatio: softLimitRatio,
+		quotas:         cfg.Quotas,
+		defaultQuota:   cfg.DefaultQuota,
+		aliases:        aliases,
+		pending:        make(map[string]map[string]*Usage),
+		totals:         make(map[string]*periodUsage),
+		warned:         make(map[string]string),
+		now:            time.Now,
+		stop:           make(chan struct{}),
+		done:           make(chan struct{}),
+	}, nil
+}
+
+func (m *Metering) Start() {
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(m.flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.Flush(context.Background())
+			case <-m.stop:
+				m.Flush(context.Background())
+				return
+			}
+		}
+	}()
+}
+
+// Shutdown stops the periodic flush and flushes the remaining usage
+func (m *Metering) Shutdown() {
+	close(m.stop)
+	<-m.done
+}
+
+func (m *Metering) quotaFor(alias string) *QuotaConfig {
+	if q, ok := m.quotas[alias]; ok {
+		return q
+	}
+	return m.defaultQuota
+}
+
+// Record adds a served call to the usage of the alias
+func (m *Metering) Record(alias string, method string, responseBytes int) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	methods := m.pending[alias]
+	if methods == nil {
+		methods = make(map[string]*Usage)
+		m.pending[alias] = methods
+	}
+	u := methods[method]
+	if u == nil {
+		u = &Usage{}
+		methods[method] = u
+	}
+	u.Requests++
+	u.ResponseBytes += int64(responseBytes)
+}
+
+// Check returns ErrQuotaExceeded if the alias reached one of its hard limits,
+// and whether it is past the soft limit of one of its quotas
+func (m *Metering) Check(alias string) (bool, error) {
+	quota := m.quotaFor(alias)
+	if quota == nil {
+		return false, nil
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	day, month := m.periods()
+	var daily, monthly Usage
+	if t := m.totals[alias]; t != nil {
+		if t.day == day {
+			daily = t.daily
+		}
+		if t.month == month {
+			monthly = t.monthly
+		}
+	}
+	for _, u := range m.pending[alias] {
+		daily.add(u)
+		monthly.add(u)
+	}
+
+	warning := false
+	for _, limit := range []struct {
+		period string
+		unit   string
+		used   int64
+		limit  int64
+	}{
+		{MeteringPeriodDaily, meteringFieldRequests, daily.Requests, quota.DailyRequests},
+		{MeteringPeriodDaily, meteringFieldResponseBytes, daily.ResponseBytes, quota.DailyResponseBytes},
+		{MeteringPeriodMonthly, meteringFieldRequests, monthly.Requests, quota.MonthlyRequests},
+		{MeteringPeriodMonthly, meteringFieldResponseBytes, monthly.ResponseBytes, quota.MonthlyResponseBytes},
+	} {
+		if limit.limit <= 0 {
+			continue
+		}
+		if limit.used >= limit.limit {
+			RecordQuotaRejection(alias, limit.period)
+			return false, ErrQuotaExceeded
+		}
+		if float64(limit.used) >= m.softLimitRatio*float64(limit.limit) {
+			warning = true
+			// warn once per alias, period and unit
+			key := strings.Join([]string{alias, limit.period, limit.unit}, ":")
+			periodKey := day
+			if limit.period == MeteringPeriodMonthly {
+				periodKey = month
+			}
+			if m.warned[key] != periodKey {
+				m.warned[key] = periodKey
+				RecordQuotaWarning(alias, limit.period)
+				log.Warn(
+					"tenant approaching quota",
+					"auth", alias,
+					"period", limit.period,
+					"unit", limit.unit,
+					"used", limit.used,
+					"limit", limit.limit,
+				)
+			}
+		}
+	}
+	return warning, nil
+}
+
+// Flush writes the pending usage to Redis and refreshes the totals of every known alias
+func (m *Metering) Flush(ctx context.Context) {
+	m.mtx.Lock()
+	pending := m.pending
+	m.pending = make(map[string]map[string]*Usage)
+	// totals are refreshed for the configured aliases too, since they may be served by other instances
+	aliases := make(map[string]bool, len(m.aliases)+len(m.totals)+len(pending))
+	for _, alias := range m.aliases {
+		aliases[alias] = true
+	}
+	for alias := range m.totals {
+		aliases[alias] = true
+	}
+	for alias := range pending {
+		aliases[alias] = true
+	}
+	m.mtx.Unlock()
+
+	day, month := m.periods()
+	dailyCmds := make(map[string]*redis.SliceCmd, len(aliases))
+	monthlyCmds := make(map[string]*redis.SliceCmd, len(aliases))
+	_, err := m.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for alias := range aliases {
+			dailyKey := m.key(alias, MeteringPeriodDaily, day)
+			monthlyKey := m.key(alias, MeteringPeriodMonthly, month)
+			for method, u := range pending[alias] {
+				for _, key := range []string{dailyKey, monthlyKey} {
+					pipe.HIncrBy(ctx, key, meteringFieldRequests, u.Requests)
+					pipe.HIncrBy(ctx, key, meteringFieldResponseBytes, u.ResponseBytes)
+					pipe.HIncrBy(ctx, key, meteringFieldRequests+":"+method, u.Requests)
+					pipe.HIncrBy(ctx, key, meteringFieldResponseBytes+":"+method, u.ResponseBytes)
+				}
+			}
+			if len(pending[alias]) > 0 {
+				pipe.Expire(ctx, dailyKey, meteringDailyRetention)
+				pipe.Expire(ctx, monthlyKey, meteringMonthlyRetention)
+			}
+			dailyCmds[alias] = pipe.HMGet(ctx, dailyKey, meteringFieldRequests, meteringFieldResponseBytes)
+			monthlyCmds[alias] = pipe.HMGet(ctx, monthlyKey, meteringFieldRequests, meteringFieldResponseBytes)
+		}
+		return nil
+	})
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err != nil {
+		log.Error("error flushing usage to redis", "err", err)
+		RecordRedisError("MeteringFlush")
+		// keep the usage, so that it's written by the next flush
+		for alias, methods := range pending {
+			for method, u := range methods {
+				if m.pending[alias] == nil {
+					m.pending[alias] = make(map[string]*Usage)
+				}
+				if m.pending[alias][method] == nil {
+					m.pending[alias][method] = &Usage{}
+				}
+				m.pending[alias][method].add(u)
+			}
+		}
+		return
+	}
+
+	for alias := range aliases {
+		t := &periodUsage{
+			day:     day,
+			month:   month,
+			daily:   usageFromHMGet(dailyCmds[alias].Val()),
+			monthly: usageFromHMGet(monthlyCmds[alias].Val()),
+		}
+		m.totals[alias] = t
+		if quota := m.quotaFor(alias); quota != nil {
+			RecordQuotaUsage(alias, MeteringPeriodDaily, t.daily.Requests, quota.DailyRequests)
+			RecordQuotaUsage(alias, MeteringPeriodMonthly, t.monthly.Requests, quota.MonthlyRequests)
+		}
+	}
+}
+
+func (m *Metering) periods() (string, string) {
+	now := m.now().UTC()
+	return now.Format(time.DateOnly), now.Format("2006-01")
+}
+
+// key hash tags the alias, so that the daily and monthly usage of a tenant share a Redis Cluster slot
+// and are updated atomically by the flush transaction
+func (m *Metering) key(alias string, period string, date string) string {
+	return fmt.Sprintf("%s:{%s}:%s:%s", m.prefix, alias, period, date)
+}
+
+func usageFromHMGet(vals []interface{}) Usage {
+	var u Usage
+	if len(vals) == 2 {
+		u.Requests = parseRedisInt(vals[0])
+		u.ResponseBytes = parseRedisInt(vals[1])
+	}
+	return u
+}
+
+func parseRedisInt(v interface{}) int64 {
+	s, ok := v.(string)
+	if !ok {
+		return 0
+	}
+	var n int64
+	_, _ = fmt.Sscan(s, &n)
+	return n
+}
+
+// UsageSnapshot is the usage of every tenant for a day and a month, as stored in Redis
+type UsageSnapshot struct {
+	Timestamp time.Time               `json:"timestamp"`
+	Day       string                  `json:"day"`
+	Month     string                  `json:"month"`
+	Tenants   map[string]*TenantUsage `json:"tenants"`
+}
+
+type TenantUsage struct {
+	Daily   *PeriodUsage `json:"daily"`
+	Monthly *PeriodUsage `json:"monthly"`
+	Quota   *QuotaConfig `json:"quota,omitempty"`
+}
+
+type PeriodUsage struct {
+	Usage
+	Methods map[string]*Usage `json:"methods"`
+}
+
+// Snapshot reads the usage of all configured aliases for the given day (YYYY-MM-DD) and month (YYYY-MM)
+func (m *Metering) Snapshot(ctx context.Context, day string, month string) (*UsageSnapshot, error) {
+	snapshot := &UsageSnapshot{
+		Timestamp: m.now().UTC(),
+		Day:       day,
+		Month:     month,
+		Tenants:   make(map[string]*TenantUsage),
+	}
+
+	m.mtx.Lock()
+	aliases := make(map[string]bool)
+	for _, alias := range m.aliases {
+		aliases[alias] = true
+	}
+	for alias := range m.totals {
+		aliases[alias] = true
+	}
+	m.mtx.Unlock()
+
+	for alias := range aliases {
+		daily, err := m.rdb.HGetAll(ctx, m.key(alias, MeteringPeriodDaily, day)).Result()
+		if err != nil {
+			RecordRedisError("MeteringSnapshot")
+			return nil, err
+		}
+		monthly, err := m.rdb.HGetAll(ctx, m.key(alias, MeteringPeriodMonthly, month)).Result()
+		if err != nil {
+			RecordRedisError("MeteringSnapshot")
+			return nil, err
+		}
+		if len(daily) == 0 && len(monthly) == 0 {
+			continue
+		}
+		snapshot.Tenants[alias] = &TenantUsage{
+			Daily:   periodUsageFromHash(daily),
+			Monthly: periodUsageFromHash(monthly),
+			Quota:   m.quotaFor(alias),
+		}
+	}
+	return snapshot, nil
+}
+
+func periodUsageFromHash(hash map[string]string) *PeriodUsage {
+	pu := &PeriodUsage{Methods: make(map[string]*Usage)}
+	for field, val := range hash {
+		n := parseRedisInt(val)
+		name, method, _ := strings.Cut(field, ":")
+		u := &pu.Usage
+		if method != "" {
+			if pu.Methods[method] == nil {
+				pu.Methods[method] = &Usage{}
+			}
+			u = pu.Methods[method]
+		}
+		switch name {
+		case meteringFieldRequests:
+			u.Requests = n
+		case meteringFieldResponseBytes:
+			u.ResponseBytes = n
+		}
+	}
+	return pu
+}
+
+// HandleUsage serves usage snapshots, the day and month default to the current ones
+func (m *Metering) HandleUsage(w http.ResponseWriter, r *http.Request) {
+	day, month := m.periods()
+	if v := r.URL.Query().Get("day"); v != "" {
+		day = v
+	}
+	if v := r.URL.Query().Get("month"); v != "" {
+		month = v
+	}
+
+	snapshot, err := m.Snapshot(r.Context(), day, month)
+	if err != nil {
+		log.Error("error reading usage snapshot", "err", err)
+		http.Error(w, "error reading usage", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	if err := json.NewEncoder(w).Encode(snapshot); err != nil {
+		log.Error("error writing usage snapshot", "err", err)
+	}
+}
+
+const ContextKeyRequestUsage = "request_usage"
+
+// requestUsage holds the metered methods of the calls of a request, which are recorded
+// along with the size of their responses once these are written
+type requestUsage struct {
+	metering *Metering
+	alias    string
+	methods  []string
+}
+
+func withRequestUsage(ctx context.Context, usage *requestUsage) context.Context {
+	return context.WithValue(ctx, ContextKeyRequestUsage, usage) // nolint:staticcheck
+}
+
+func getRequestUsage(ctx context.Context) *requestUsage {
+	usage, ok := ctx.Value(ContextKeyRequestUsage).(*requestUsage)
+	if !ok {
+		return nil
+	}
+	return usage
+}
+
+// setMethods sets the methods of the calls, unknown methods being empty
+func (u *requestUsage) setMethods(methods []string) {
+	if u == nil {
+		return
+	}
+	u.methods = methods
+}
+
+// record meters the calls given the sizes of their responses. Requests rejected before their
+// calls were handled, e.g. over quota, have no methods and aren't metered
+func (u *requestUsage) record(sizes []int) {
+	if u == nil || u.methods == nil {
+		return
+	}
+	for i, size := range sizes {
+		method := MethodUnknown
+		if i < len(u.methods) && u.methods[i] != "" {
+			method = u.methods[i]
+		}
+		u.metering.Record(u.alias, method, size)
+	}
+}
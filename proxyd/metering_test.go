@@ -0,0 +1,150 @@
+package proxyd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetering(t *testing.T) {
+	redisServer, err := miniredis.Run()
+	require.NoError(t, err)
+	defer redisServer.Close()
+
+	cfg := MeteringConfig{
+		SoftLimitRatio: 0.5,
+		DefaultQuota:   &QuotaConfig{MonthlyRequests: 100},
+		Quotas: map[string]*QuotaConfig{
+			"alice": {DailyRequests: 4, DailyResponseBytes: 1000},
+			"bob":   nil,
+		},
+	}
+	now := time.Date(2024, 1, 31, 23, 0, 0, 0, time.UTC)
+	newMetering := func() *Metering {
+		rdb := redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+		m, err := NewMetering(rdb, "ns", cfg, []string{"none", "alice", "bob"})
+		require.NoError(t, err)
+		m.now = func() time.Time { return now }
+		return m
+	}
+	ctx := context.Background()
+	instance1 := newMetering()
+	instance2 := newMetering()
+
+	t.Run("quotas are shared across instances", func(t *testing.T) {
+		warning, err := instance1.Check("alice")
+		require.NoError(t, err)
+		require.False(t, warning)
+
+		instance1.Record("alice", "eth_call", 100)
+		instance1.Record("alice", "eth_chainId", 10)
+		warning, err = instance1.Check("alice")
+		require.NoError(t, err)
+		require.True(t, warning)
+
+		// not flushed yet
+		warning, err = instance2.Check("alice")
+		require.NoError(t, err)
+		require.False(t, warning)
+
+		instance1.Flush(ctx)
+		instance2.Flush(ctx)
+		warning, err = instance2.Check("alice")
+		require.NoError(t, err)
+		require.True(t, warning)
+
+		instance2.Record("alice", "eth_call", 100)
+		instance2.Record("alice", "eth_call", 100)
+		_, err = instance2.Check("alice")
+		require.ErrorIs(t, err, ErrQuotaExceeded)
+
+		instance2.Flush(ctx)
+		instance1.Flush(ctx)
+		_, err = instance1.Check("alice")
+		require.ErrorIs(t, err, ErrQuotaExceeded)
+	})
+
+	t.Run("tenants without quota are not limited", func(t *testing.T) {
+		for i := 0; i < 200; i++ {
+			instance1.Record("bob", "eth_call", 10)
+		}
+		warning, err := instance1.Check("bob")
+		require.NoError(t, err)
+		require.False(t, warning)
+	})
+
+	t.Run("default quota", func(t *testing.T) {
+		for i := 0; i < 100; i++ {
+			instance1.Record("none", "eth_call", 10)
+		}
+		_, err := instance1.Check("none")
+		require.ErrorIs(t, err, ErrQuotaExceeded)
+	})
+
+	t.Run("snapshot", func(t *testing.T) {
+		instance1.Flush(ctx)
+		snapshot, err := instance2.Snapshot(ctx, "2024-01-31", "2024-01")
+		require.NoError(t, err)
+		alice := snapshot.Tenants["alice"]
+		require.Equal(t, Usage{Requests: 4, ResponseBytes: 310}, alice.Daily.Usage)
+		require.Equal(t, &Usage{Requests: 3, ResponseBytes: 300}, alice.Daily.Methods["eth_call"])
+		require.Equal(t, &Usage{Requests: 1, ResponseBytes: 10}, alice.Daily.Methods["eth_chainId"])
+		require.Equal(t, int64(4), alice.Quota.DailyRequests)
+		require.Equal(t, int64(200), snapshot.Tenants["bob"].Monthly.Requests)
+		require.Nil(t, snapshot.Tenants["bob"].Quota)
+	})
+
+	t.Run("quotas reset with the period", func(t *testing.T) {
+		now = now.Add(2 * time.Hour)
+		_, err := instance1.Check("alice")
+		require.NoError(t, err)
+		_, err = instance1.Check("none")
+		require.NoError(t, err)
+	})
+}
+
+func TestRequestUsage(t *testing.T) {
+	redisServer, err := miniredis.Run()
+	require.NoError(t, err)
+	defer redisServer.Close()
+
+	rdb := redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+	m, err := NewMetering(rdb, "ns", MeteringConfig{}, []string{"alice"})
+	require.NoError(t, err)
+	now := time.Date(2024, 1, 31, 23, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+
+	res := []*RPCRes{
+		NewRPCRes(json.RawMessage("1"), "0x1"),
+		NewRPCErrorRes(json.RawMessage("2"), ErrMethodNotWhitelisted),
+	}
+	usage := &requestUsage{metering: m, alias: "alice"}
+	ctx := withRequestUsage(context.Background(), usage)
+
+	// responses written before the calls are handled aren't metered
+	writeRPCError(ctx, httptest.NewRecorder(), nil, ErrQuotaExceeded)
+
+	// unmapped methods are metered as unknown, with the size of the responses written
+	usage.setMethods([]string{"eth_call", ""})
+	w := httptest.NewRecorder()
+	writeBatchRPCRes(ctx, w, res)
+	expected, err := json.Marshal(res)
+	require.NoError(t, err)
+	require.Equal(t, string(expected)+"\n", w.Body.String())
+
+	m.Flush(context.Background())
+	snapshot, err := m.Snapshot(context.Background(), "2024-01-31", "2024-01")
+	require.NoError(t, err)
+	daily := snapshot.Tenants["alice"].Daily
+	require.Equal(t, int64(2), daily.Requests)
+	require.Equal(t, int64(len(expected)-3), daily.ResponseBytes)
+	require.Equal(t, &Usage{Requests: 1, ResponseBytes: int64(len(mustMarshalJSON(res[0])))}, daily.Methods["eth_call"])
+	require.Equal(t, &Usage{Requests: 1, ResponseBytes: int64(len(mustMarshalJSON(res[1])))}, daily.Methods[MethodUnknown])
+	require.Len(t, daily.Methods, 2)
+}
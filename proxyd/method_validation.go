@@ -0,0 +1,277 @@
+package proxyd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+const (
+	ValidationReasonParamsSize    = "params_size"
+	ValidationReasonInvalidParams = "invalid_params"
+	ValidationReasonGas           = "gas"
+	ValidationReasonCalldata      = "calldata"
+	ValidationReasonBlockRange    = "block_range"
+	ValidationReasonAddresses     = "addresses"
+	ValidationReasonTopics        = "topics"
+	ValidationReasonTracer        = "tracer"
+)
+
+var errValidationNegative = errors.New("validation limits must not be negative")
+
+// RequestValidator rejects the calls whose params exceed the limits configured for their method,
+// before they are forwarded to a backend. A nil validator accepts all the calls
+type RequestValidator struct {
+	methods map[string]*methodValidator
+}
+
+type methodValidator struct {
+	maxParamsBytes    int
+	maxGas            uint64
+	maxCalldataBytes  int
+	requireBlockRange bool
+	maxAddresses      int
+	maxTopics         int
+	allowedTracers    map[string]bool
+}
+
+// callObject holds the fields of the transaction object of eth_call and similar methods.
+// The calldata is kept as a hex string, its size being all that's checked
+type callObject struct {
+	Gas   *hexutil.Uint64 `json:"gas"`
+	Data  *string         `json:"data"`
+	Input *string         `json:"input"`
+}
+
+type logsFilter struct {
+	FromBlock *string           `json:"fromBlock"`
+	ToBlock   *string           `json:"toBlock"`
+	BlockHash *string           `json:"blockHash"`
+	Address   json.RawMessage   `json:"address"`
+	Topics    []json.RawMessage `json:"topics"`
+}
+
+type tracerOptions struct {
+	Tracer *string `json:"tracer"`
+}
+
+// NewRequestValidator creates the validator of the methods, or returns nil if there are none
+func NewRequestValidator(cfgs map[string]*MethodValidationConfig) (*RequestValidator, error) {
+	if len(cfgs) == 0 {
+		return nil, nil
+	}
+	v := &RequestValidator{methods: make(map[string]*methodValidator, len(cfgs))}
+	for method, cfg := range cfgs {
+		if cfg.MaxParamsBytes < 0 || cfg.MaxCalldataBytes < 0 || cfg.MaxAddresses < 0 || cfg.MaxTopics < 0 {
+			return nil, fmt.Errorf("validation of %s: %w", method, errValidationNegative)
+		}
+		mv := &methodValidator{
+			maxParamsBytes:    cfg.MaxParamsBytes,
+			maxGas:            cfg.MaxGas,
+			maxCalldataBytes:  cfg.MaxCalldataBytes,
+			requireBlockRange: cfg.RequireBlockRange,
+			maxAddresses:      cfg.MaxAddresses,
+			maxTopics:         cfg.MaxTopics,
+		}
+		if len(cfg.AllowedTracers) > 0 {
+			mv.allowedTracers = make(map[string]bool, len(cfg.AllowedTracers))
+			for _, tracer := range cfg.AllowedTracers {
+				mv.allowedTracers[tracer] = true
+			}
+		}
+		v.methods[method] = mv
+	}
+	return v, nil
+}
+
+// Validate returns an invalid params error if the call exceeds the limits of its method.
+// The params of the calls without gas are rewritten to use the maximum gas of their method
+func (v *RequestValidator) Validate(req *RPCReq) error {
+	if v == nil {
+		return nil
+	}
+	mv := v.methods[req.Method]
+	if mv == nil {
+		return nil
+	}
+	reason, err := mv.validate(req)
+	if err != nil {
+		RecordValidationRejection(req.Method, reason)
+	}
+	return err
+}
+
+func (mv *methodValidator) validate(req *RPCReq) (string, error) {
+	if mv.maxParamsBytes > 0 && len(req.Params) > mv.maxParamsBytes {
+		return ValidationReasonParamsSize, ErrInvalidParams(
+			fmt.Sprintf("params of %d bytes exceed the maximum of %d bytes", len(req.Params), mv.maxParamsBytes))
+	}
+
+	checksCall := mv.maxGas > 0 || mv.maxCalldataBytes > 0
+	checksLogs := mv.requireBlockRange || mv.maxAddresses > 0 || mv.maxTopics > 0
+	if !checksCall && !checksLogs && mv.allowedTracers == nil {
+		return "", nil
+	}
+
+	var params []json.RawMessage
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return ValidationReasonInvalidParams, ErrInvalidParams("params must be an array")
+		}
+	}
+
+	if checksCall && len(params) > 0 {
+		call, reason, err := mv.validateCall(params[0])
+		if err != nil {
+			return reason, err
+		}
+		if !bytes.Equal(call, params[0]) {
+			params[0] = call
+			req.Params = mustMarshalJSON(params)
+		}
+	}
+	if checksLogs {
+		if reason, err := mv.validateLogsFilter(params); err != nil {
+			return reason, err
+		}
+	}
+	if mv.allowedTracers != nil {
+		if reason, err := mv.validateTracer(params); err != nil {
+			return reason, err
+		}
+	}
+	return "", nil
+}
+
+// validateCall returns the transaction object to forward, with the gas set to the maximum when it's missing,
+// as the backends would otherwise use their own, higher, gas cap
+func (mv *methodValidator) validateCall(param json.RawMessage) (json.RawMessage, string, error) {
+	var call callObject
+	if err := json.Unmarshal(param, &call); err != nil {
+		return nil, ValidationReasonInvalidParams, ErrInvalidParams("invalid transaction object")
+	}
+	if mv.maxGas > 0 && call.Gas != nil && uint64(*call.Gas) > mv.maxGas {
+		return nil, ValidationReasonGas, ErrInvalidParams(
+			fmt.Sprintf("gas %d exceeds the maximum of %d", uint64(*call.Gas), mv.maxGas))
+	}
+	if mv.maxCalldataBytes > 0 {
+		size := max(hexSize(call.Data), hexSize(call.Input))
+		if size > mv.maxCalldataBytes {
+			return nil, ValidationReasonCalldata, ErrInvalidParams(
+				fmt.Sprintf("calldata of %d bytes exceeds the maximum of %d bytes", size, mv.maxCalldataBytes))
+		}
+	}
+	if mv.maxGas > 0 && call.Gas == nil {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(param, &fields); err != nil {
+			return nil, ValidationReasonInvalidParams, ErrInvalidParams("invalid transaction object")
+		}
+		if fields == nil {
+			fields = make(map[string]json.RawMessage, 1)
+		}
+		fields["gas"] = mustMarshalJSON(hexutil.Uint64(mv.maxGas))
+		return mustMarshalJSON(fields), "", nil
+	}
+	return param, "", nil
+}
+
+func (mv *methodValidator) validateLogsFilter(params []json.RawMessage) (string, error) {
+	var filter logsFilter
+	if len(params) > 0 {
+		if err := json.Unmarshal(params[0], &filter); err != nil {
+			return ValidationReasonInvalidParams, ErrInvalidParams("invalid filter object")
+		}
+	}
+	if mv.requireBlockRange && filter.BlockHash == nil && (filter.FromBlock == nil || filter.ToBlock == nil) {
+		return ValidationReasonBlockRange, ErrInvalidParams("filter must specify fromBlock and toBlock, or blockHash")
+	}
+
+	if mv.maxAddresses > 0 {
+		addresses, err := countValues(filter.Address)
+		if err != nil {
+			return ValidationReasonInvalidParams, ErrInvalidParams("invalid filter address")
+		}
+		if addresses > mv.maxAddresses {
+			return ValidationReasonAddresses, ErrInvalidParams(
+				fmt.Sprintf("filter has %d addresses, the maximum is %d", addresses, mv.maxAddresses))
+		}
+	}
+	if mv.maxTopics > 0 {
+		topics := 0
+		for _, position := range filter.Topics {
+			n, err := countValues(position)
+			if err != nil {
+				return ValidationReasonInvalidParams, ErrInvalidParams("invalid filter topics")
+			}
+			topics += n
+		}
+		if topics > mv.maxTopics {
+			return ValidationReasonTopics, ErrInvalidParams(
+				fmt.Sprintf("filter has %d topics, the maximum is %d", topics, mv.maxTopics))
+		}
+	}
+	return "", nil
+}
+
+// validateTracer checks the tracer of the tracing options, which follow the transaction, block or call params
+func (mv *methodValidator) validateTracer(params []json.RawMessage) (string, error) {
+	for i := 1; i < len(params); i++ {
+		if !isJSONObject(params[i]) {
+			continue
+		}
+		var opts tracerOptions
+		if err := json.Unmarshal(params[i], &opts); err != nil {
+			return ValidationReasonInvalidParams, ErrInvalidParams("invalid tracer options")
+		}
+		// the default struct logger is used without a tracer
+		if opts.Tracer != nil && !mv.allowedTracers[*opts.Tracer] {
+			return ValidationReasonTracer, ErrInvalidParams(
+				fmt.Sprintf("tracer is not allowed, use one of %s", strings.Join(mv.sortedTracers(), ", ")))
+		}
+	}
+	return "", nil
+}
+
+func (mv *methodValidator) sortedTracers() []string {
+	tracers := make([]string, 0, len(mv.allowedTracers))
+	for tracer := range mv.allowedTracers {
+		tracers = append(tracers, tracer)
+	}
+	sort.Strings(tracers)
+	return tracers
+}
+
+// countValues returns the number of values of a filter field, which is either null, a value or an array of values
+func countValues(raw json.RawMessage) (int, error) {
+	raw = bytes.TrimSpace(raw)
+	switch {
+	case len(raw) == 0 || bytes.Equal(raw, []byte("null")):
+		return 0, nil
+	case raw[0] == '[':
+		var values []json.RawMessage
+		if err := json.Unmarshal(raw, &values); err != nil {
+			return 0, err
+		}
+		return len(values), nil
+	default:
+		return 1, nil
+	}
+}
+
+// hexSize returns the number of bytes encoded by a hex string
+func hexSize(s *string) int {
+	if s == nil {
+		return 0
+	}
+	return len(strings.TrimPrefix(*s, "0x")) / 2
+}
+
+func isJSONObject(raw json.RawMessage) bool {
+	raw = bytes.TrimSpace(raw)
+	return len(raw) > 0 && raw[0] == '{'
+}
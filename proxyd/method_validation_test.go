@@ -0,0 +1,69 @@
+package proxyd
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestValidator(t *testing.T) {
+	v, err := NewRequestValidator(map[string]*MethodValidationConfig{
+		"eth_call":               {MaxGas: 50_000_000, MaxCalldataBytes: 4},
+		"eth_getLogs":            {RequireBlockRange: true, MaxAddresses: 2, MaxTopics: 3},
+		"debug_traceCall":        {AllowedTracers: []string{"prestateTracer", "callTracer"}},
+		"eth_sendRawTransaction": {MaxParamsBytes: 16},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		method  string
+		params  string
+		message string
+	}{
+		{"call", "eth_call", `[{"to":"0x01","gas":"0x2faf080","data":"0x01020304"},"latest"]`, ""},
+		{"call without gas", "eth_call", `[{"to":"0x01"},"latest"]`, ""},
+		{"call over gas", "eth_call", `[{"to":"0x01","gas":"0x2faf081"},"latest"]`, "gas 50000001 exceeds the maximum of 50000000"},
+		{"call over calldata", "eth_call", `[{"to":"0x01","input":"0x0102030405"}]`, "calldata of 5 bytes exceeds the maximum of 4 bytes"},
+		{"invalid call", "eth_call", `["0x01"]`, "invalid transaction object"},
+		{"params not an array", "eth_call", `{"to":"0x01"}`, "params must be an array"},
+		{"logs", "eth_getLogs", `[{"fromBlock":"0x1","toBlock":"latest","address":["0x01","0x02"],"topics":["0x03",null,["0x04","0x05"]]}]`, ""},
+		{"logs by hash", "eth_getLogs", `[{"blockHash":"0x01","address":"0x01"}]`, ""},
+		{"logs without range", "eth_getLogs", `[{"fromBlock":"0x1"}]`, "filter must specify fromBlock and toBlock, or blockHash"},
+		{"logs without filter", "eth_getLogs", `[]`, "filter must specify fromBlock and toBlock, or blockHash"},
+		{"logs over addresses", "eth_getLogs", `[{"blockHash":"0x01","address":["0x01","0x02","0x03"]}]`, "filter has 3 addresses, the maximum is 2"},
+		{"logs over topics", "eth_getLogs", `[{"blockHash":"0x01","topics":[["0x01","0x02"],["0x03","0x04"]]}]`, "filter has 4 topics, the maximum is 3"},
+		{"trace", "debug_traceCall", `[{"to":"0x01"},"latest",{"tracer":"callTracer"}]`, ""},
+		{"trace with struct logger", "debug_traceCall", `[{"to":"0x01"},"latest",{"disableStorage":true}]`, ""},
+		{"trace with custom tracer", "debug_traceCall", `[{"to":"0x01"},"latest",{"tracer":"{step: function() {}}"}]`, "tracer is not allowed, use one of callTracer, prestateTracer"},
+		{"raw transaction", "eth_sendRawTransaction", `["0x0102"]`, ""},
+		{"raw transaction over size", "eth_sendRawTransaction", `["0x01020304050607"]`, "params of 20 bytes exceed the maximum of 16 bytes"},
+		{"method without validation", "eth_chainId", `[{"gas":"0xffffffff"}]`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := v.Validate(&RPCReq{Method: tt.method, Params: json.RawMessage(tt.params)})
+			if tt.message == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Equal(t, ErrInvalidParams(tt.message), err)
+		})
+	}
+
+	// the calls without gas are capped at the maximum gas
+	req := &RPCReq{Method: "eth_call", Params: json.RawMessage(`[{"to":"0x01","data":"0x01"},"latest"]`)}
+	require.NoError(t, v.Validate(req))
+	require.JSONEq(t, `[{"to":"0x01","data":"0x01","gas":"0x2faf080"},"latest"]`, string(req.Params))
+
+	req = &RPCReq{Method: "eth_call", Params: json.RawMessage(`[{"to":"0x01","gas":"0x5208"},"latest"]`)}
+	require.NoError(t, v.Validate(req))
+	require.Equal(t, `[{"to":"0x01","gas":"0x5208"},"latest"]`, string(req.Params))
+
+	var none *RequestValidator
+	require.NoError(t, none.Validate(&RPCReq{Method: "eth_call"}))
+
+	_, err = NewRequestValidator(map[string]*MethodValidationConfig{"eth_getLogs": {MaxTopics: -1}})
+	require.ErrorIs(t, err, errValidationNegative)
+}
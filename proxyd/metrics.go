@@ -70,6 +70,126 @@ var (
 		"error_code",
 	})
 
+	rpcErrorClassificationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "rpc_error_classifications_total",
+		Help:      "Count of JSON-RPC errors returned by backends, by retryable error classification.",
+	}, []string{
+		"backend_name",
+		"method_name",
+		"retryable",
+		"rule",
+	})
+
+	retryBudgetRetriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "retry_budget_retries_total",
+		Help:      "Count of retries checked against the retry budget of a backend group, by whether they were allowed.",
+	}, []string{
+		"backend_group_name",
+		"allowed",
+	})
+
+	retryBudgetTokens = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: MetricsNamespace,
+		Name:      "retry_budget_tokens",
+		Help:      "Retries currently available in the retry budget of a backend group.",
+	}, []string{
+		"backend_group_name",
+	})
+
+	backendRateLimitedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "backend_rate_limited_total",
+		Help:      "Count of requests skipping a backend over its shared max_rps.",
+	}, []string{
+		"backend_name",
+	})
+
+	backendConcurrencyLimit = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: MetricsNamespace,
+		Name:      "backend_concurrency_limit",
+		Help:      "Adaptive limit of the requests in flight to a backend.",
+	}, []string{
+		"backend_name",
+	})
+
+	backendConcurrencyInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: MetricsNamespace,
+		Name:      "backend_concurrency_in_flight",
+		Help:      "Requests in flight to a backend with an adaptive concurrency limit.",
+	}, []string{
+		"backend_name",
+	})
+
+	backendConcurrencyLimitedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "backend_concurrency_limited_total",
+		Help:      "Count of requests skipping a backend at its adaptive concurrency limit.",
+	}, []string{
+		"backend_name",
+	})
+
+	streamedResponsesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "streamed_responses_total",
+		Help:      "Count of responses streamed from the backends to the clients, by result.",
+	}, []string{
+		"result",
+	})
+
+	backendSpendTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "backend_spend_total",
+		Help:      "Spend of this instance on the requests to a paid backend, by method.",
+	}, []string{
+		"backend_name",
+		"method_name",
+	})
+
+	backendMonthlySpend = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: MetricsNamespace,
+		Name:      "backend_monthly_spend",
+		Help:      "Spend of all the instances on a paid backend in the current calendar month.",
+	}, []string{
+		"backend_name",
+	})
+
+	backendMonthlyBudgetRatio = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: MetricsNamespace,
+		Name:      "backend_monthly_budget_ratio",
+		Help:      "Ratio of the monthly budget of a paid backend spent in the current calendar month.",
+	}, []string{
+		"backend_name",
+	})
+
+	admissionRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "admission_requests_total",
+		Help:      "Count of backend requests going through admission control, by priority class and result.",
+	}, []string{
+		"class",
+		"result",
+	})
+
+	admissionQueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: MetricsNamespace,
+		Name:      "admission_queue_depth",
+		Help:      "Number of backend requests waiting for admission, by priority class.",
+	}, []string{
+		"class",
+	})
+
+	admissionWaitDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: MetricsNamespace,
+		Name:      "admission_wait_duration_milliseconds",
+		Help:      "Histogram of the time backend requests waited for admission, in milliseconds.",
+		Buckets:   MillisecondDurationBuckets,
+	}, []string{
+		"class",
+		"result",
+	})
+
 	rpcSpecialErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
 		Namespace: MetricsNamespace,
 		Name:      "rpc_special_errors_total",
@@ -331,6 +451,85 @@ var (
 		"backend_name",
 	})
 
+	consensusBlockHashMismatchBackend = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: MetricsNamespace,
+		Name:      "consensus_backend_block_hash_mismatch",
+		Help:      "Bool gauge for a backend block hash conflicting with the consensus group",
+	}, []string{
+		"backend_name",
+		"block_tag",
+	})
+
+	consensusFinalizedHashMismatches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "consensus_finalized_hash_mismatches_total",
+		Help:      "Count of backends banned for a finalized block hash conflicting with the consensus group",
+	}, []string{
+		"backend_group_name",
+		"backend_name",
+	})
+
+	consensusLaggingRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "consensus_lagging_backend_requests_total",
+		Help:      "Count of block-specific requests served by a lagging backend out of the consensus group",
+	}, []string{
+		"backend_group_name",
+		"backend_name",
+	})
+
+	consensusReorgs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "consensus_reorgs_total",
+		Help:      "Count of consensus reorgs",
+	}, []string{
+		"backend_group_name",
+	})
+
+	consensusReorgDepth = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: MetricsNamespace,
+		Name:      "consensus_reorg_depth",
+		Help:      "Histogram of the number of consensus blocks replaced by reorgs",
+		Buckets:   []float64{1, 2, 3, 5, 8, 13, 21, 34, 64, 128},
+	}, []string{
+		"backend_group_name",
+	})
+
+	consensusReorgBackends = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "consensus_reorg_backends_total",
+		Help:      "Count of consensus reorgs by backend breaking the consensus",
+	}, []string{
+		"backend_group_name",
+		"backend_name",
+	})
+
+	reorgNotifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "reorg_notifications_total",
+		Help:      "Count of reorg webhook notifications",
+	}, []string{
+		"success",
+	})
+
+	consensusSnapshotRestores = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "consensus_snapshot_restores_total",
+		Help:      "Count of consensus snapshot restores by result",
+	}, []string{
+		"backend_group_name",
+		"result",
+	})
+
+	consensusSnapshotSaves = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "consensus_snapshot_saves_total",
+		Help:      "Count of consensus snapshot saves",
+	}, []string{
+		"backend_group_name",
+		"success",
+	})
+
 	consensusGroupCount = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: MetricsNamespace,
 		Name:      "group_consensus_count",
@@ -428,6 +627,118 @@ var (
 		"backend_name",
 		"fallback",
 	})
+
+	backendGroupFallbackMode = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: MetricsNamespace,
+		Name:      "backend_group_fallback_mode",
+		Help:      "Bool gauge for the current fallback mode of a backend group: primary, fallback or recovering",
+	}, []string{
+		"backend_group_name",
+		"mode",
+	})
+
+	backendGroupFallbackTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "backend_group_fallback_transitions_total",
+		Help:      "Count of fallback mode transitions of a backend group",
+	}, []string{
+		"backend_group_name",
+		"from",
+		"to",
+	})
+
+	routingRuleMatchesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "routing_rule_matches_total",
+		Help:      "Count of requests matched by each routing rule.",
+	}, []string{
+		"rule",
+		"action",
+	})
+
+	validationRejectionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "validation_rejections_total",
+		Help:      "Count of calls rejected by the method validation, by reason.",
+	}, []string{
+		"method_name",
+		"reason",
+	})
+
+	mirrorRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "mirror_requests_total",
+		Help:      "Count of requests mirrored to a shadow backend group, by comparison result.",
+	}, []string{
+		"backend_group_name",
+		"shadow_group_name",
+		"method_name",
+		"result",
+	})
+
+	compressionRatioHistogram = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: MetricsNamespace,
+		Name:      "compression_ratio",
+		Help:      "Histogram of uncompressed to compressed size ratios of request and response bodies.",
+		Buckets:   []float64{1, 1.5, 2, 3, 4, 6, 8, 12, 16, 24, 32},
+	}, []string{
+		"direction",
+		"encoding",
+	})
+
+	compressionBytesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "compression_bytes_total",
+		Help:      "Count of bytes of compressed request and response bodies, before and after compression.",
+	}, []string{
+		"direction",
+		"encoding",
+		"stage",
+	})
+
+	quotaWarningsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "quota_warnings_total",
+		Help:      "Count of tenants crossing the soft limit of a quota.",
+	}, []string{
+		"auth",
+		"period",
+	})
+
+	quotaRejectionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "quota_rejections_total",
+		Help:      "Count of requests rejected because the tenant exceeded a quota.",
+	}, []string{
+		"auth",
+		"period",
+	})
+
+	quotaRequestsUsageRatio = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: MetricsNamespace,
+		Name:      "quota_requests_usage_ratio",
+		Help:      "Share of the request quota used by each tenant, as of the last metering flush.",
+	}, []string{
+		"auth",
+		"period",
+	})
+
+	discoveredBackends = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: MetricsNamespace,
+		Name:      "backend_discovery_backends",
+		Help:      "Number of backends currently discovered for a backend group.",
+	}, []string{
+		"backend_group_name",
+	})
+
+	backendDiscoveryUpdatesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "backend_discovery_updates_total",
+		Help:      "Count of backend discovery updates, by result.",
+	}, []string{
+		"backend_group_name",
+		"success",
+	})
 )
 
 func RecordRedisError(source string) {
@@ -447,6 +758,54 @@ func RecordRPCError(ctx context.Context, backendName, method string, err error)
 	rpcErrorsTotal.WithLabelValues(GetAuthCtx(ctx), backendName, method, strconv.Itoa(code)).Inc()
 }
 
+func RecordRPCErrorClassification(backendName, method, rule string, retryable bool) {
+	if rule == "" {
+		rule = "none"
+	}
+	rpcErrorClassificationsTotal.WithLabelValues(backendName, method, strconv.FormatBool(retryable), rule).Inc()
+}
+
+func RecordRetryBudgetRetry(group string, allowed bool) {
+	retryBudgetRetriesTotal.WithLabelValues(group, strconv.FormatBool(allowed)).Inc()
+}
+
+func RecordRetryBudgetTokens(group string, tokens float64) {
+	retryBudgetTokens.WithLabelValues(group).Set(tokens)
+}
+
+func RecordBackendRateLimited(backendName string) {
+	backendRateLimitedTotal.WithLabelValues(backendName).Inc()
+}
+
+func RecordBackendConcurrency(backendName string, limit float64, inFlight int) {
+	backendConcurrencyLimit.WithLabelValues(backendName).Set(limit)
+	backendConcurrencyInFlight.WithLabelValues(backendName).Set(float64(inFlight))
+}
+
+func RecordBackendConcurrencyLimited(backendName string) {
+	backendConcurrencyLimitedTotal.WithLabelValues(backendName).Inc()
+}
+
+func RecordBackendSpend(backendName string, method string, amount float64) {
+	backendSpendTotal.WithLabelValues(backendName, method).Add(amount)
+}
+
+func RecordBackendMonthlySpend(backendName string, spend float64, budget float64) {
+	backendMonthlySpend.WithLabelValues(backendName).Set(spend)
+	if budget > 0 {
+		backendMonthlyBudgetRatio.WithLabelValues(backendName).Set(spend / budget)
+	}
+}
+
+func RecordAdmission(class string, result string, wait time.Duration) {
+	admissionRequestsTotal.WithLabelValues(class, result).Inc()
+	admissionWaitDuration.WithLabelValues(class, result).Observe(float64(wait.Milliseconds()))
+}
+
+func RecordAdmissionQueueDepth(class string, depth int) {
+	admissionQueueDepth.WithLabelValues(class).Set(float64(depth))
+}
+
 func RecordWSMessage(ctx context.Context, backendName, source string) {
 	wsMessagesTotal.WithLabelValues(GetAuthCtx(ctx), backendName, source).Inc()
 }
@@ -477,6 +836,10 @@ func RecordResponsePayloadSize(ctx context.Context, payloadSize int) {
 	responsePayloadSizesGauge.WithLabelValues(GetAuthCtx(ctx)).Observe(float64(payloadSize))
 }
 
+func RecordStreamedResponse(result string) {
+	streamedResponsesTotal.WithLabelValues(result).Inc()
+}
+
 func RecordCacheHit(method string) {
 	cacheHitsTotal.WithLabelValues(method).Inc()
 }
@@ -555,6 +918,38 @@ func RecordBackendUnexpectedBlockTags(b *Backend, unexpected bool) {
 	backendUnexpectedBlockTagsBackend.WithLabelValues(b.Name).Set(boolToFloat64(unexpected))
 }
 
+func RecordConsensusBackendBlockHashMismatch(b *Backend, tag string, mismatch bool) {
+	consensusBlockHashMismatchBackend.WithLabelValues(b.Name, tag).Set(boolToFloat64(mismatch))
+}
+
+func RecordConsensusFinalizedHashMismatch(bg *BackendGroup, b *Backend) {
+	consensusFinalizedHashMismatches.WithLabelValues(bg.Name, b.Name).Inc()
+}
+
+func RecordConsensusLaggingRequest(bg *BackendGroup, b *Backend) {
+	consensusLaggingRequests.WithLabelValues(bg.Name, b.Name).Inc()
+}
+
+func RecordConsensusReorg(event *ReorgEvent) {
+	consensusReorgs.WithLabelValues(event.BackendGroup).Inc()
+	consensusReorgDepth.WithLabelValues(event.BackendGroup).Observe(float64(event.Depth))
+	for _, name := range event.Backends {
+		consensusReorgBackends.WithLabelValues(event.BackendGroup, name).Inc()
+	}
+}
+
+func RecordReorgNotification(success bool) {
+	reorgNotifications.WithLabelValues(strconv.FormatBool(success)).Inc()
+}
+
+func RecordConsensusSnapshotRestore(bg *BackendGroup, result string) {
+	consensusSnapshotRestores.WithLabelValues(bg.Name, result).Inc()
+}
+
+func RecordConsensusSnapshotSave(bg *BackendGroup, success bool) {
+	consensusSnapshotSaves.WithLabelValues(bg.Name, strconv.FormatBool(success)).Inc()
+}
+
 func RecordConsensusBackendBanned(b *Backend, banned bool) {
 	consensusBannedBackends.WithLabelValues(b.Name).Set(boolToFloat64(banned))
 }
@@ -589,10 +984,84 @@ func RecordBackendNetworkErrorRateSlidingWindow(b *Backend, rate float64) {
 	networkErrorRateBackend.WithLabelValues(b.Name).Set(rate)
 }
 
+func RecordFallbackMode(group string, mode FallbackMode) {
+	for _, m := range fallbackModes {
+		backendGroupFallbackMode.WithLabelValues(group, string(m)).Set(boolToFloat64(m == mode))
+	}
+}
+
+func RecordFallbackTransition(group string, from, to FallbackMode) {
+	backendGroupFallbackTransitions.WithLabelValues(group, string(from), string(to)).Inc()
+	RecordFallbackMode(group, to)
+}
+
 func RecordBackendGroupFallbacks(bg *BackendGroup, name string, fallback bool) {
 	backendGroupFallbackBackend.WithLabelValues(bg.Name, name, strconv.FormatBool(fallback)).Set(boolToFloat64(fallback))
 }
 
+func RecordRoutingRuleMatch(rule *RoutingRule) {
+	routingRuleMatchesTotal.WithLabelValues(rule.Name, rule.Action).Inc()
+}
+
+func RecordValidationRejection(method string, reason string) {
+	validationRejectionsTotal.WithLabelValues(method, reason).Inc()
+}
+
+func RecordMirrorResult(group string, shadowGroup string, method string, result string) {
+	mirrorRequestsTotal.WithLabelValues(group, shadowGroup, method, result).Inc()
+}
+
+func RecordCompression(direction string, encoding string, uncompressedSize int, compressedSize int) {
+	if compressedSize > 0 {
+		compressionRatioHistogram.WithLabelValues(direction, encoding).Observe(float64(uncompressedSize) / float64(compressedSize))
+	}
+	compressionBytesTotal.WithLabelValues(direction, encoding, "uncompressed").Add(float64(uncompressedSize))
+	compressionBytesTotal.WithLabelValues(direction, encoding, "compressed").Add(float64(compressedSize))
+}
+
+func RecordQuotaWarning(alias string, period string) {
+	quotaWarningsTotal.WithLabelValues(alias, period).Inc()
+}
+
+func RecordQuotaRejection(alias string, period string) {
+	quotaRejectionsTotal.WithLabelValues(alias, period).Inc()
+}
+
+func RecordQuotaUsage(alias string, period string, used int64, limit int64) {
+	if limit > 0 {
+		quotaRequestsUsageRatio.WithLabelValues(alias, period).Set(float64(used) / float64(limit))
+	}
+}
+
+func RecordBackendDiscovery(bg *BackendGroup, success bool, discovered int) {
+	backendDiscoveryUpdatesTotal.WithLabelValues(bg.Name, strconv.FormatBool(success)).Inc()
+	discoveredBackends.WithLabelValues(bg.Name).Set(float64(discovered))
+}
+
+// RemoveBackendMetrics deletes the state gauges of a backend removed at runtime, so that it
+// doesn't keep reporting its last known state
+func RemoveBackendMetrics(bg *BackendGroup, name string) {
+	for _, gauge := range []*prometheus.GaugeVec{
+		backendLatestBlockBackend,
+		backendSafeBlockBackend,
+		backendFinalizedBlockBackend,
+		backendUnexpectedBlockTagsBackend,
+		consensusBannedBackends,
+		consensusPeerCountBackend,
+		consensusInSyncBackend,
+		consensusUpdateDelayBackend,
+		avgLatencyBackend,
+		degradedBackends,
+		networkErrorRateBackend,
+		backendConcurrencyLimit,
+		backendConcurrencyInFlight,
+	} {
+		gauge.DeleteLabelValues(name)
+	}
+	backendGroupFallbackBackend.DeletePartialMatch(prometheus.Labels{"backend_group": bg.Name, "backend_name": name})
+	consensusBlockHashMismatchBackend.DeletePartialMatch(prometheus.Labels{"backend_name": name})
+}
+
 func boolToFloat64(b bool) float64 {
 	if b {
 		return 1
@@ -0,0 +1,276 @@
+package proxyd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+const (
+	ContextKeyMirrorRequest = "mirror_request"
+
+	MirrorResultMatch    = "match"
+	MirrorResultMismatch = "mismatch"
+	MirrorResultError    = "error"
+	MirrorResultDropped  = "dropped"
+
+	defaultMirrorTimeout     = 5 * time.Second
+	defaultMirrorMaxInFlight = 100
+	maxMirrorDiffs           = 10
+)
+
+// Mirror duplicates a sampled share of the requests served by a backend group to a shadow
+// backend group, and compares the shadow responses with the ones served to the client
+type Mirror struct {
+	sourceGroup string
+	methods     map[string]bool
+	shadow      *BackendGroup
+	sampleRate  float64
+	timeout     time.Duration
+	inFlight    chan struct{}
+}
+
+func NewMirrors(cfgs []*MirrorConfig, backendGroups map[string]*BackendGroup) ([]*Mirror, error) {
+	mirrors := make([]*Mirror, 0, len(cfgs))
+	for i, cfg := range cfgs {
+		if cfg.BackendGroup == "" && len(cfg.Methods) == 0 {
+			return nil, fmt.Errorf("mirror %d must set backend_group or methods", i)
+		}
+		if cfg.BackendGroup != "" && backendGroups[cfg.BackendGroup] == nil {
+			return nil, fmt.Errorf("mirror %d: backend group %s does not exist", i, cfg.BackendGroup)
+		}
+		shadow := backendGroups[cfg.ShadowGroup]
+		if shadow == nil {
+			return nil, fmt.Errorf("mirror %d: shadow group %s does not exist", i, cfg.ShadowGroup)
+		}
+		if cfg.ShadowGroup == cfg.BackendGroup {
+			return nil, fmt.Errorf("mirror %d: shadow group must differ from the backend group", i)
+		}
+		if cfg.SampleRate <= 0 || cfg.SampleRate > 1 {
+			return nil, errors.New("mirror sample_rate must be greater than 0 and at most 1")
+		}
+
+		timeout := time.Duration(cfg.Timeout)
+		if timeout == 0 {
+			timeout = defaultMirrorTimeout
+		}
+		maxInFlight := cfg.MaxInFlight
+		if maxInFlight == 0 {
+			maxInFlight = defaultMirrorMaxInFlight
+		}
+
+		var methods map[string]bool
+		if len(cfg.Methods) > 0 {
+			methods = make(map[string]bool, len(cfg.Methods))
+			for _, method := range cfg.Methods {
+				methods[method] = true
+			}
+		}
+
+		mirrors = append(mirrors, &Mirror{
+			sourceGroup: cfg.BackendGroup,
+			methods:     methods,
+			shadow:      shadow,
+			sampleRate:  cfg.SampleRate,
+			timeout:     timeout,
+			inFlight:    make(chan struct{}, maxInFlight),
+		})
+	}
+	return mirrors, nil
+}
+
+func (m *Mirror) matches(group string, method string) bool {
+	if m.sourceGroup != "" && m.sourceGroup != group {
+		return false
+	}
+	if m.methods != nil && !m.methods[method] {
+		return false
+	}
+	return group != m.shadow.Name
+}
+
+// mirrorRequests sends the sampled requests of a forwarded batch to their shadow group in the background.
+// res holds the responses served to the client, in the same order as elems.
+func (s *Server) mirrorRequests(ctx context.Context, group string, elems []batchElem, res []*RPCRes) {
+	if len(s.mirrors) == 0 {
+		return
+	}
+
+	type shadowBatch struct {
+		reqs    []*RPCReq
+		primary []*RPCRes
+	}
+	batches := make(map[*Mirror]*shadowBatch)
+	for i, elem := range elems {
+		for _, m := range s.mirrors {
+			if !m.matches(group, elem.Req.Method) {
+				continue
+			}
+			if rand.Float64() < m.sampleRate {
+				if batches[m] == nil {
+					batches[m] = &shadowBatch{}
+				}
+				// the primary request may be rewritten again by the shadow group
+				req := *elem.Req
+				batches[m].reqs = append(batches[m].reqs, &req)
+				batches[m].primary = append(batches[m].primary, res[i])
+			}
+		}
+	}
+
+	reqID := GetReqID(ctx)
+	for m, batch := range batches {
+		select {
+		case m.inFlight <- struct{}{}:
+		default:
+			for _, req := range batch.reqs {
+				RecordMirrorResult(group, m.shadow.Name, req.Method, MirrorResultDropped)
+			}
+			continue
+		}
+
+		go func(m *Mirror, batch *shadowBatch) {
+			defer func() { <-m.inFlight }()
+			m.forward(reqID, group, batch.reqs, batch.primary)
+		}(m, batch)
+	}
+}
+
+// isMirrorRequest returns whether the request is sent to a shadow group. Mirrored requests don't take
+// the max_concurrent_rpcs slots of the clients, their concurrency is bounded by max_in_flight instead
+func isMirrorRequest(ctx context.Context) bool {
+	mirrored, _ := ctx.Value(ContextKeyMirrorRequest).(bool)
+	return mirrored
+}
+
+func (m *Mirror) forward(reqID string, group string, reqs []*RPCReq, primary []*RPCRes) {
+	// detached from the client request, so that the shadow group never adds to its latency
+	ctx := context.WithValue(context.Background(), ContextKeyReqID, reqID) // nolint:staticcheck
+	ctx = context.WithValue(ctx, ContextKeyMirrorRequest, true)            // nolint:staticcheck
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	shadowRes, _, err := m.shadow.Forward(ctx, reqs, len(reqs) > 1)
+	if err == nil && len(shadowRes) != len(reqs) {
+		err = ErrBackendUnexpectedJSONRPC
+	}
+	if err != nil {
+		log.Warn(
+			"error forwarding mirrored request",
+			"req_id", reqID,
+			"backend_group", group,
+			"shadow_group", m.shadow.Name,
+			"err", err,
+		)
+		for _, req := range reqs {
+			RecordMirrorResult(group, m.shadow.Name, req.Method, MirrorResultError)
+		}
+		return
+	}
+
+	for i, req := range reqs {
+		diffs := diffRPCRes(primary[i], shadowRes[i])
+		if len(diffs) == 0 {
+			RecordMirrorResult(group, m.shadow.Name, req.Method, MirrorResultMatch)
+			continue
+		}
+		RecordMirrorResult(group, m.shadow.Name, req.Method, MirrorResultMismatch)
+		log.Warn(
+			"mirrored response mismatch",
+			"req_id", reqID,
+			"method", req.Method,
+			"params", truncate(string(req.Params), maxRequestBodyLogLen),
+			"backend_group", group,
+			"shadow_group", m.shadow.Name,
+			"diffs", diffs,
+		)
+	}
+}
+
+// diffRPCRes compares two responses after normalization. Errors are compared by code only,
+// since the messages usually differ between node clients.
+func diffRPCRes(primary *RPCRes, shadow *RPCRes) []string {
+	if primary.IsError() || shadow.IsError() {
+		if primary.IsError() && shadow.IsError() && primary.Error.Code == shadow.Error.Code {
+			return nil
+		}
+		return []string{fmt.Sprintf("error: %s != %s", describeRPCRes(primary), describeRPCRes(shadow))}
+	}
+
+	var diffs []string
+	diffJSON("result", normalizeJSON(primary.Result), normalizeJSON(shadow.Result), &diffs)
+	return diffs
+}
+
+func describeRPCRes(res *RPCRes) string {
+	if res.IsError() {
+		return fmt.Sprintf("error %d (%s)", res.Error.Code, res.Error.Message)
+	}
+	return "result"
+}
+
+// normalizeJSON round trips a value through JSON, so that numbers, maps and raw messages compare equal
+func normalizeJSON(v interface{}) interface{} {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out interface{}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return v
+	}
+	return out
+}
+
+// diffJSON collects up to maxMirrorDiffs differences between two normalized JSON values
+func diffJSON(path string, a interface{}, b interface{}, diffs *[]string) {
+	if len(*diffs) >= maxMirrorDiffs {
+		return
+	}
+
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(av)+len(bv))
+		for k := range av {
+			keys = append(keys, k)
+		}
+		for k := range bv {
+			if _, ok := av[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			diffJSON(path+"."+k, av[k], bv[k], diffs)
+		}
+		return
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			break
+		}
+		for i := range av {
+			diffJSON(fmt.Sprintf("%s[%d]", path, i), av[i], bv[i], diffs)
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		*diffs = append(*diffs, fmt.Sprintf("%s: %s != %s", path, truncate(mustMarshalJSONString(a), 100), truncate(mustMarshalJSONString(b), 100)))
+	}
+}
+
+func mustMarshalJSONString(v interface{}) string {
+	return string(mustMarshalJSON(v))
+}
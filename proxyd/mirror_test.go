@@ -0,0 +1,55 @@
+package proxyd
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffRPCRes(t *testing.T) {
+	parse := func(s string) *RPCRes {
+		res := new(RPCRes)
+		require.NoError(t, json.Unmarshal([]byte(s), res))
+		return res
+	}
+
+	tests := []struct {
+		name    string
+		primary string
+		shadow  string
+		diffs   []string
+	}{
+		{
+			name:    "equal ignoring key order and id",
+			primary: `{"jsonrpc":"2.0","result":{"a":"0x1","b":["0x2"]},"id":1}`,
+			shadow:  `{"jsonrpc":"2.0","id":2,"result":{"b":["0x2"],"a":"0x1"}}`,
+		},
+		{
+			name:    "different fields",
+			primary: `{"jsonrpc":"2.0","result":{"a":"0x1","b":["0x2","0x3"]},"id":1}`,
+			shadow:  `{"jsonrpc":"2.0","result":{"b":["0x2","0x4"],"c":true},"id":1}`,
+			diffs: []string{
+				`result.a: "0x1" != null`,
+				`result.b[1]: "0x3" != "0x4"`,
+				`result.c: null != true`,
+			},
+		},
+		{
+			name:    "same error code",
+			primary: `{"jsonrpc":"2.0","error":{"code":-32000,"message":"execution reverted"},"id":1}`,
+			shadow:  `{"jsonrpc":"2.0","error":{"code":-32000,"message":"reverted"},"id":1}`,
+		},
+		{
+			name:    "error and result",
+			primary: `{"jsonrpc":"2.0","result":"0x1","id":1}`,
+			shadow:  `{"jsonrpc":"2.0","error":{"code":-32000,"message":"reverted"},"id":1}`,
+			diffs:   []string{"error: result != error -32000 (reverted)"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.diffs, diffRPCRes(parse(tt.primary), parse(tt.shadow)))
+		})
+	}
+}
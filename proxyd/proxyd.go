@@ -23,7 +23,7 @@ func SetLogLevel(logLevel slog.Leveler) {
 }
 
 func Start(config *Config) (*Server, func(), error) {
-	if len(config.Backends) == 0 {
+	if len(config.Backends) == 0 && !config.BackendGroups.HasDiscovery() {
 		return nil, nil, errors.New("must define at least one backend")
 	}
 	if len(config.BackendGroups) == 0 {
@@ -39,13 +39,10 @@ func Start(config *Config) (*Server, func(), error) {
 		}
 	}
 
-	var redisClient *redis.Client
+	var redisClient redis.UniversalClient
 	if config.Redis.URL != "" {
-		rURL, err := ReadFromEnvOrConfig(config.Redis.URL)
-		if err != nil {
-			return nil, nil, err
-		}
-		redisClient, err = NewRedisClient(rURL)
+		var err error
+		redisClient, err = NewRedisClient(config.Redis)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -78,121 +75,73 @@ func Start(config *Config) (*Server, func(), error) {
 		}
 	}
 
+	var tracingShutdown func(context.Context) error
+	if config.Tracing.Enabled {
+		var err error
+		tracingShutdown, err = InitTracing(context.Background(), config.Tracing)
+		if err != nil {
+			return nil, nil, err
+		}
+		log.Info("tracing enabled", "exporter", config.Tracing.Exporter, "endpoint", config.Tracing.Endpoint)
+	}
+
 	maxConcurrentRPCs := config.Server.MaxConcurrentRPCs
 	if maxConcurrentRPCs == 0 {
 		maxConcurrentRPCs = math.MaxInt64
 	}
-	rpcRequestSemaphore := semaphore.NewWeighted(maxConcurrentRPCs)
-
-	backendNames := make([]string, 0)
-	backendsByName := make(map[string]*Backend)
-	for name, cfg := range config.Backends {
-		opts := make([]BackendOpt, 0)
-
-		rpcURL, err := ReadFromEnvOrConfig(cfg.RPCURL)
-		if err != nil {
-			return nil, nil, err
-		}
-		wsURL, err := ReadFromEnvOrConfig(cfg.WSURL)
+	var rpcRequestSemaphore RPCSemaphore = semaphore.NewWeighted(maxConcurrentRPCs)
+	var admission *AdmissionController
+	if len(config.Admission.Classes) > 0 {
+		var err error
+		admission, err = NewAdmissionController(maxConcurrentRPCs, config.Admission)
 		if err != nil {
-			return nil, nil, err
-		}
-		if rpcURL == "" {
-			return nil, nil, fmt.Errorf("must define an RPC URL for backend %s", name)
-		}
-
-		if config.BackendOptions.ResponseTimeoutSeconds != 0 {
-			timeout := secondsToDuration(config.BackendOptions.ResponseTimeoutSeconds)
-			opts = append(opts, WithTimeout(timeout))
-		}
-		if config.BackendOptions.MaxRetries != 0 {
-			opts = append(opts, WithMaxRetries(config.BackendOptions.MaxRetries))
-		}
-		if config.BackendOptions.MaxResponseSizeBytes != 0 {
-			opts = append(opts, WithMaxResponseSize(config.BackendOptions.MaxResponseSizeBytes))
-		}
-		if config.BackendOptions.OutOfServiceSeconds != 0 {
-			opts = append(opts, WithOutOfServiceDuration(secondsToDuration(config.BackendOptions.OutOfServiceSeconds)))
+			return nil, nil, fmt.Errorf("error creating admission controller: %w", err)
 		}
-		if config.BackendOptions.MaxDegradedLatencyThreshold > 0 {
-			opts = append(opts, WithMaxDegradedLatencyThreshold(time.Duration(config.BackendOptions.MaxDegradedLatencyThreshold)))
-		}
-		if config.BackendOptions.MaxLatencyThreshold > 0 {
-			opts = append(opts, WithMaxLatencyThreshold(time.Duration(config.BackendOptions.MaxLatencyThreshold)))
-		}
-		if config.BackendOptions.MaxErrorRateThreshold > 0 {
-			opts = append(opts, WithMaxErrorRateThreshold(config.BackendOptions.MaxErrorRateThreshold))
-		}
-		if cfg.MaxRPS != 0 {
-			opts = append(opts, WithMaxRPS(cfg.MaxRPS))
-		}
-		if cfg.MaxWSConns != 0 {
-			opts = append(opts, WithMaxWSConns(cfg.MaxWSConns))
-		}
-		if cfg.Password != "" {
-			passwordVal, err := ReadFromEnvOrConfig(cfg.Password)
-			if err != nil {
-				return nil, nil, err
-			}
-			opts = append(opts, WithBasicAuth(cfg.Username, passwordVal))
-		}
-
-		headers := map[string]string{}
-		for headerName, headerValue := range cfg.Headers {
-			headerValue, err := ReadFromEnvOrConfig(headerValue)
-			if err != nil {
-				return nil, nil, err
-			}
+		rpcRequestSemaphore = admission
+	}
 
-			headers[headerName] = headerValue
-		}
-		opts = append(opts, WithHeaders(headers))
+	if redisClient == nil && config.BackendOptions.SharedRateLimit.Enabled {
+		return nil, nil, errors.New("must specify a Redis URL if shared_rate_limit is enabled")
+	}
+	sharedRateLimits := NewSharedRateLimits(redisClient, config.Redis.Namespace, config.BackendOptions.SharedRateLimit)
 
-		tlsConfig, err := configureBackendTLS(cfg)
-		if err != nil {
-			return nil, nil, err
-		}
-		if tlsConfig != nil {
-			log.Info("using custom TLS config for backend", "name", name)
-			opts = append(opts, WithTLSConfig(tlsConfig))
-		}
-		if cfg.StripTrailingXFF {
-			opts = append(opts, WithStrippedTrailingXFF())
+	var spendTracker *SpendTracker
+	if hasBackendCosts(config) {
+		if redisClient == nil {
+			return nil, nil, errors.New("must specify a Redis URL if a backend has a cost")
 		}
-		opts = append(opts, WithProxydIP(os.Getenv("PROXYD_IP")))
-		opts = append(opts, WithConsensusSkipPeerCountCheck(cfg.ConsensusSkipPeerCountCheck))
-		opts = append(opts, WithConsensusForcedCandidate(cfg.ConsensusForcedCandidate))
-		opts = append(opts, WithWeight(cfg.Weight))
+		spendTracker = NewSpendTracker(redisClient, config.Redis.Namespace, time.Duration(config.BackendOptions.CostFlushInterval))
+	}
 
-		receiptsTarget, err := ReadFromEnvOrConfig(cfg.ConsensusReceiptsTarget)
-		if err != nil {
-			return nil, nil, err
-		}
-		receiptsTarget, err = validateReceiptsTarget(receiptsTarget)
+	backendNames := make([]string, 0)
+	backendsByName := make(map[string]*Backend)
+	for name, cfg := range config.Backends {
+		back, err := newBackendFromConfig(name, cfg, config.BackendOptions, rpcRequestSemaphore, sharedRateLimits, spendTracker)
 		if err != nil {
 			return nil, nil, err
 		}
-		opts = append(opts, WithConsensusReceiptTarget(receiptsTarget))
-
-		back := NewBackend(name, rpcURL, wsURL, rpcRequestSemaphore, opts...)
 		backendNames = append(backendNames, name)
 		backendsByName[name] = back
 		log.Info("configured backend",
 			"name", name,
 			"backend_names", backendNames,
-			"rpc_url", rpcURL,
-			"ws_url", wsURL)
+			"rpc_url", back.rpcURL,
+			"ws_url", back.wsURL)
+	}
+
+	var groupErrs []error
+	for _, bgName := range sortedKeys(config.BackendGroups) {
+		groupErrs = append(groupErrs, backendGroupConfigErrors(bgName, config.BackendGroups[bgName], config)...)
+	}
+	if len(groupErrs) > 0 {
+		return nil, nil, errors.Join(groupErrs...)
 	}
 
 	backendGroups := make(map[string]*BackendGroup)
 	for bgName, bg := range config.BackendGroups {
 		backends := make([]*Backend, 0)
 		fallbackBackends := make(map[string]bool)
-		fallbackCount := 0
 		for _, bName := range bg.Backends {
-			if backendsByName[bName] == nil {
-				return nil, nil, fmt.Errorf("backend %s is not defined", bName)
-			}
 			backends = append(backends, backendsByName[bName])
 
 			for _, fb := range bg.Fallbacks {
@@ -202,7 +151,6 @@ func Start(config *Config) (*Server, func(), error) {
 						"backend_name", bName,
 						"backend_group", bgName,
 					)
-					fallbackCount++
 				}
 			}
 
@@ -215,19 +163,32 @@ func Start(config *Config) (*Server, func(), error) {
 			}
 		}
 
-		if fallbackCount != len(bg.Fallbacks) {
-			return nil, nil,
-				fmt.Errorf(
-					"error: number of fallbacks instantiated (%d) did not match configured (%d) for backend group %s",
-					fallbackCount, len(bg.Fallbacks), bgName,
-				)
+		retryBudget, err := NewRetryBudget(bgName, bg.RetryBudget)
+		if err != nil {
+			return nil, nil, fmt.Errorf("backend group %s: %w", bgName, err)
 		}
 
-		backendGroups[bgName] = &BackendGroup{
+		group := &BackendGroup{
 			Name:             bgName,
 			Backends:         backends,
 			WeightedRouting:  bg.WeightedRouting,
 			FallbackBackends: fallbackBackends,
+			retryBudget:      retryBudget,
+		}
+		backendGroups[bgName] = group
+
+		if bg.Discovery != nil {
+			discovery, err := NewBackendDiscovery(group, bg.Discovery, func(name string, cfg *BackendConfig) (*Backend, error) {
+				return newBackendFromConfig(name, cfg, config.BackendOptions, rpcRequestSemaphore, sharedRateLimits, spendTracker)
+			})
+			if err != nil {
+				return nil, nil, err
+			}
+			// the first sync happens before serving, a failure is retried in the background
+			if err := discovery.Sync(context.Background()); err != nil {
+				log.Warn("error discovering backends", "backend_group", bgName, "err", err)
+			}
+			group.discovery = discovery
 		}
 	}
 
@@ -249,6 +210,12 @@ func Start(config *Config) (*Server, func(), error) {
 		}
 	}
 
+	for _, rule := range config.RoutingRules {
+		if rule.BackendGroup != "" && backendGroups[rule.BackendGroup] == nil {
+			return nil, nil, fmt.Errorf("undefined backend group %s in routing rule %s", rule.BackendGroup, rule.Name)
+		}
+	}
+
 	var resolvedAuth map[string]string
 
 	if config.Authentication != nil {
@@ -297,11 +264,17 @@ func Start(config *Config) (*Server, func(), error) {
 		config.Server.MaxRequestBodyLogLen,
 		config.BatchConfig.MaxSize,
 		redisClient,
+		config.RoutingRules,
 	)
 	if err != nil {
 		return nil, nil, fmt.Errorf("error creating server: %w", err)
 	}
 
+	srv.admission = admission
+	if srv.validator, err = NewRequestValidator(config.Validation); err != nil {
+		return nil, nil, fmt.Errorf("error creating request validator: %w", err)
+	}
+
 	// Enable to support browser websocket connections.
 	// See https://pkg.go.dev/github.com/gorilla/websocket#hdr-Origin_Considerations
 	if config.Server.AllowAllOrigins {
@@ -310,11 +283,77 @@ func Start(config *Config) (*Server, func(), error) {
 		}
 	}
 
+	if config.Server.EnableCompression {
+		srv.enableCompression = true
+		srv.compressionMinSize = config.Server.CompressionMinSizeBytes
+		if srv.compressionMinSize == 0 {
+			srv.compressionMinSize = defaultCompressionMinSize
+		}
+	}
+	srv.enableResponseStreaming = config.Server.EnableResponseStreaming
+	if config.Server.MaxUpstreamBatchParallelism > 0 {
+		srv.maxUpstreamBatchParallelism = config.Server.MaxUpstreamBatchParallelism
+	}
+
+	if config.Metering.Enabled {
+		aliases := []string{"none"}
+		for _, alias := range config.Authentication {
+			aliases = append(aliases, alias)
+		}
+		metering, err := NewMetering(redisClient, config.Redis.Namespace, config.Metering, aliases)
+		if err != nil {
+			return nil, nil, err
+		}
+		metering.Start()
+		srv.metering = metering
+	}
+
+	if spendTracker != nil {
+		// read the spend of the month before serving, so that the budgets are enforced right away
+		spendTracker.Flush(context.Background())
+		spendTracker.Start()
+		srv.spendTracker = spendTracker
+	}
+
+	reorgs, err := NewReorgTracker(config.Reorgs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating reorg tracker: %w", err)
+	}
+	srv.reorgs = reorgs
+
+	if config.AccessLog.Enabled {
+		accessLog, err := NewAccessLogger(config.AccessLog)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error creating access log: %w", err)
+		}
+		srv.accessLog = accessLog
+	}
+
+	if config.Capture.Enabled {
+		capture, err := NewTrafficCapture(config.Capture)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error creating traffic capture: %w", err)
+		}
+		srv.capture = capture
+	}
+
+	mirrors, err := NewMirrors(config.Mirrors, backendGroups)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating mirrors: %w", err)
+	}
+	srv.mirrors = mirrors
+
 	if config.Metrics.Enabled {
 		addr := fmt.Sprintf("%s:%d", config.Metrics.Host, config.Metrics.Port)
 		log.Info("starting metrics server", "addr", addr)
+		mux := http.NewServeMux()
+		mux.Handle("/", promhttp.Handler())
+		if srv.metering != nil {
+			mux.HandleFunc("/usage", srv.metering.HandleUsage)
+		}
+		mux.HandleFunc("/reorgs", srv.reorgs.HandleReorgs)
 		go func() {
-			if err := http.ListenAndServe(addr, promhttp.Handler()); err != nil {
+			if err := http.ListenAndServe(addr, mux); err != nil {
 				log.Error("error starting metrics server", "err", err)
 			}
 		}()
@@ -379,6 +418,34 @@ func Start(config *Config) (*Server, func(), error) {
 			if bgcfg.ConsensusPollerInterval > 0 {
 				copts = append(copts, WithPollerInterval(time.Duration(bgcfg.ConsensusPollerInterval)))
 			}
+			if bgcfg.ConsensusServeLagging {
+				copts = append(copts, WithServeLagging(true))
+			}
+			if bgcfg.FallbackMinPrimaries > 0 {
+				copts = append(copts, WithFallbackMinPrimaries(bgcfg.FallbackMinPrimaries))
+			}
+			if bgcfg.FallbackFailBackPeriod > 0 {
+				copts = append(copts, WithFallbackFailBackPeriod(time.Duration(bgcfg.FallbackFailBackPeriod)))
+			}
+			strategy, err := NewConsensusStrategy(bgcfg.ConsensusStrategy, bgcfg.ConsensusQuorum)
+			if err != nil {
+				return nil, nil, fmt.Errorf("backend group %s: %w", bgName, err)
+			}
+			copts = append(copts, WithStrategy(strategy))
+			copts = append(copts, WithReorgTracker(srv.reorgs))
+			if snapshotCfg := bgcfg.ConsensusSnapshot; snapshotCfg != nil {
+				store, err := NewConsensusSnapshotStore(snapshotCfg, bgName, redisClient, config.Redis.Namespace)
+				if err != nil {
+					return nil, nil, fmt.Errorf("backend group %s: %w", bgName, err)
+				}
+				copts = append(copts, WithSnapshotStore(store))
+				if snapshotCfg.Interval > 0 {
+					copts = append(copts, WithSnapshotInterval(time.Duration(snapshotCfg.Interval)))
+				}
+				if snapshotCfg.MaxAge > 0 {
+					copts = append(copts, WithSnapshotMaxAge(time.Duration(snapshotCfg.MaxAge)))
+				}
+			}
 
 			for _, be := range bgcfg.Backends {
 				if fallback, ok := bg.FallbackBackends[be]; !ok {
@@ -391,9 +458,6 @@ func Start(config *Config) (*Server, func(), error) {
 
 			var tracker ConsensusTracker
 			if bgcfg.ConsensusHA {
-				if bgcfg.ConsensusHARedis.URL == "" {
-					log.Crit("must specify a consensus_ha_redis config when consensus_ha is true")
-				}
 				topts := make([]RedisConsensusTrackerOpt, 0)
 				if bgcfg.ConsensusHALockPeriod > 0 {
 					topts = append(topts, WithLockPeriod(time.Duration(bgcfg.ConsensusHALockPeriod)))
@@ -401,7 +465,7 @@ func Start(config *Config) (*Server, func(), error) {
 				if bgcfg.ConsensusHAHeartbeatInterval > 0 {
 					topts = append(topts, WithHeartbeatInterval(time.Duration(bgcfg.ConsensusHAHeartbeatInterval)))
 				}
-				consensusHARedisClient, err := NewRedisClient(bgcfg.ConsensusHARedis.URL)
+				consensusHARedisClient, err := NewRedisClient(bgcfg.ConsensusHARedis)
 				if err != nil {
 					return nil, nil, err
 				}
@@ -419,18 +483,216 @@ func Start(config *Config) (*Server, func(), error) {
 		}
 	}
 
+	// discovery is started once the consensus pollers exist, so that they are kept in sync
+	for _, bg := range backendGroups {
+		if bg.discovery != nil {
+			bg.discovery.Start()
+		}
+	}
+
 	<-errTimer.C
 	log.Info("started proxyd")
 
 	shutdownFunc := func() {
 		log.Info("shutting down proxyd")
 		srv.Shutdown()
+		if tracingShutdown != nil {
+			if err := tracingShutdown(context.Background()); err != nil {
+				log.Error("error shutting down tracing", "err", err)
+			}
+		}
 		log.Info("goodbye")
 	}
 
 	return srv, shutdownFunc, nil
 }
 
+// newBackendFromConfig creates a backend, resolving the values read from environment variables
+func newBackendFromConfig(name string, cfg *BackendConfig, options BackendOptions, rpcRequestSemaphore RPCSemaphore, sharedRateLimits *SharedRateLimits, spendTracker *SpendTracker) (*Backend, error) {
+	rpcURL, wsURL, opts, errs := backendOptsFromConfig(name, cfg, options, sharedRateLimits, spendTracker)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("backend %s: %w", name, errors.Join(errs...))
+	}
+	if cfg.CAFile != "" {
+		log.Info("using custom TLS config for backend", "name", name)
+	}
+	return NewBackend(name, rpcURL, wsURL, rpcRequestSemaphore, opts...), nil
+}
+
+// backendOptsFromConfig resolves the URLs and options of a backend. It's shared by Start and
+// ValidateConfig, so it returns all the problems found, each prefixed with its config field
+func backendOptsFromConfig(name string, cfg *BackendConfig, options BackendOptions, sharedRateLimits *SharedRateLimits, spendTracker *SpendTracker) (string, string, []BackendOpt, []error) {
+	var errs []error
+	resolve := func(field string, value string) string {
+		resolved, err := ReadFromEnvOrConfig(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", field, err))
+		}
+		return resolved
+	}
+	opts := make([]BackendOpt, 0)
+
+	var rpcURL string
+	if cfg.RPCURL == "" {
+		errs = append(errs, errors.New("rpc_url: must define an RPC URL"))
+	} else {
+		rpcURL = resolve("rpc_url", cfg.RPCURL)
+	}
+	wsURL := resolve("ws_url", cfg.WSURL)
+
+	if options.ResponseTimeoutSeconds != 0 {
+		timeout := secondsToDuration(options.ResponseTimeoutSeconds)
+		opts = append(opts, WithTimeout(timeout))
+	}
+	if options.MaxRetries != 0 {
+		opts = append(opts, WithMaxRetries(options.MaxRetries))
+	}
+	if options.MaxResponseSizeBytes != 0 {
+		opts = append(opts, WithMaxResponseSize(options.MaxResponseSizeBytes))
+	}
+	if options.OutOfServiceSeconds != 0 {
+		opts = append(opts, WithOutOfServiceDuration(secondsToDuration(options.OutOfServiceSeconds)))
+	}
+	if options.MaxDegradedLatencyThreshold > 0 {
+		opts = append(opts, WithMaxDegradedLatencyThreshold(time.Duration(options.MaxDegradedLatencyThreshold)))
+	}
+	if options.MaxLatencyThreshold > 0 {
+		opts = append(opts, WithMaxLatencyThreshold(time.Duration(options.MaxLatencyThreshold)))
+	}
+	if options.MaxErrorRateThreshold > 0 {
+		opts = append(opts, WithMaxErrorRateThreshold(options.MaxErrorRateThreshold))
+	}
+	if cfg.MaxRPS != 0 {
+		opts = append(opts, WithMaxRPS(cfg.MaxRPS))
+	}
+	if rateLimiter := sharedRateLimits.Limiter(name, cfg.MaxRPS); rateLimiter != nil {
+		opts = append(opts, WithRateLimiter(rateLimiter))
+	}
+	if cfg.MaxWSConns != 0 {
+		opts = append(opts, WithMaxWSConns(cfg.MaxWSConns))
+	}
+	if cfg.Password != "" {
+		passwordVal := resolve("password", cfg.Password)
+		opts = append(opts, WithBasicAuth(cfg.Username, passwordVal))
+	}
+
+	headers := map[string]string{}
+	for _, headerName := range sortedKeys(cfg.Headers) {
+		headers[headerName] = resolve("headers."+headerName, cfg.Headers[headerName])
+	}
+	opts = append(opts, WithHeaders(headers))
+
+	tlsConfig, err := configureBackendTLS(cfg)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("tls: %w", err))
+	}
+	if tlsConfig != nil {
+		opts = append(opts, WithTLSConfig(tlsConfig))
+	}
+	if cfg.StripTrailingXFF {
+		opts = append(opts, WithStrippedTrailingXFF())
+	}
+	opts = append(opts, WithProxydIP(os.Getenv("PROXYD_IP")))
+	opts = append(opts, WithConsensusSkipPeerCountCheck(cfg.ConsensusSkipPeerCountCheck))
+	opts = append(opts, WithConsensusForcedCandidate(cfg.ConsensusForcedCandidate))
+	opts = append(opts, WithWeight(cfg.Weight))
+
+	receiptsTarget, err := validateReceiptsTarget(resolve("consensus_receipts_target", cfg.ConsensusReceiptsTarget))
+	if err != nil {
+		errs = append(errs, fmt.Errorf("consensus_receipts_target: %w", err))
+	}
+	opts = append(opts, WithConsensusReceiptTarget(receiptsTarget))
+
+	retryableErrors, err := NewRetryableErrorClassifier(options.RetryableErrors, cfg.RetryableErrors)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("retryable_errors: %w", err))
+	}
+	if retryableErrors != nil {
+		opts = append(opts, WithRetryableErrors(retryableErrors))
+	}
+
+	concurrencyLimiter, err := NewConcurrencyLimiter(name, cfg.ConcurrencyLimit)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("concurrency_limit: %w", err))
+	}
+	if concurrencyLimiter != nil {
+		opts = append(opts, WithConcurrencyLimiter(concurrencyLimiter))
+	}
+
+	costModel, err := NewCostModel(name, cfg.Cost, spendTracker)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("cost: %w", err))
+	}
+	if costModel != nil {
+		opts = append(opts, WithCostModel(costModel))
+	}
+
+	return rpcURL, wsURL, opts, errs
+}
+
+// backendGroupConfigErrors returns the problems of a backend group config. It's shared by
+// Start and ValidateConfig, the backend group constructors checking their own configs
+func backendGroupConfigErrors(name string, bg *BackendGroupConfig, config *Config) []error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("backend group %s: %s", name, fmt.Sprintf(format, args...)))
+	}
+
+	inGroup := make(map[string]bool, len(bg.Backends))
+	for _, backend := range bg.Backends {
+		inGroup[backend] = true
+		if config.Backends[backend] == nil {
+			fail("backend %s is not defined", backend)
+		}
+	}
+	for _, fallback := range bg.Fallbacks {
+		if !inGroup[fallback] {
+			fail("fallback %s is not in backends", fallback)
+		}
+	}
+	if bg.FallbackMinPrimaries < 0 {
+		fail("fallback_min_primaries must not be negative")
+	} else if primaries := len(bg.Backends) - len(bg.Fallbacks); bg.Discovery == nil && bg.FallbackMinPrimaries > primaries {
+		fail("fallback_min_primaries %d is more than the %d primaries", bg.FallbackMinPrimaries, primaries)
+	}
+
+	if bg.Discovery != nil {
+		if _, err := NewDiscoveryProvider(bg.Discovery); err != nil {
+			fail("%v", err)
+		}
+		template := discoveryTemplate(bg.Discovery)
+		_, _, _, templateErrs := backendOptsFromConfig(name, &template, config.BackendOptions, nil, nil)
+		for _, err := range templateErrs {
+			fail("discovery.template.%v", err)
+		}
+	}
+	if bg.RetryBudget != nil {
+		if err := validateRetryBudget(bg.RetryBudget); err != nil {
+			fail("%v", err)
+		}
+	}
+	if _, err := NewConsensusStrategy(bg.ConsensusStrategy, bg.ConsensusQuorum); err != nil {
+		fail("%v", err)
+	}
+	if snapshotCfg := bg.ConsensusSnapshot; snapshotCfg != nil {
+		if bg.ConsensusHA {
+			fail("consensus_snapshot can't be used with consensus_ha")
+		}
+		// the redis store only needs the [redis] config, the client is created by Start
+		if snapshotCfg.Type != ConsensusSnapshotTypeRedis {
+			if _, err := NewConsensusSnapshotStore(snapshotCfg, name, nil, ""); err != nil {
+				fail("%v", err)
+			}
+		} else if config.Redis.URL == "" {
+			fail("must specify a Redis URL for a redis consensus snapshot")
+		}
+	}
+	if bg.ConsensusHA && bg.ConsensusHARedis.URL == "" {
+		fail("must specify a consensus_ha_redis config when consensus_ha is true")
+	}
+	return errs
+}
+
 func validateReceiptsTarget(val string) (string, error) {
 	if val == "" {
 		val = ReceiptsTargetDebugGetRawReceipts
@@ -2,21 +2,77 @@ package proxyd
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
-func NewRedisClient(url string) (*redis.Client, error) {
-	opts, err := redis.ParseURL(url)
+const (
+	RedisModeSingle   = "single"
+	RedisModeSentinel = "sentinel"
+	RedisModeCluster  = "cluster"
+)
+
+// NewRedisClient connects to a single Redis node, to the master of a Sentinel deployment or to a Redis Cluster
+func NewRedisClient(cfg RedisConfig) (redis.UniversalClient, error) {
+	client, err := newUniversalRedisClient(cfg)
 	if err != nil {
 		return nil, err
 	}
-	client := redis.NewClient(opts)
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
 		return nil, wrapErr(err, "error connecting to redis")
 	}
 	return client, nil
 }
+
+// newUniversalRedisClient creates the client for the configured mode, without connecting to Redis
+func newUniversalRedisClient(cfg RedisConfig) (redis.UniversalClient, error) {
+	url, err := ReadFromEnvOrConfig(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.Mode {
+	case "", RedisModeSingle:
+		opts, err := redis.ParseURL(url)
+		if err != nil {
+			return nil, err
+		}
+		return redis.NewClient(opts), nil
+	case RedisModeSentinel:
+		if cfg.MasterName == "" {
+			return nil, errors.New("redis master_name must be set in sentinel mode")
+		}
+		opts, err := redis.ParseURL(url)
+		if err != nil {
+			return nil, err
+		}
+		sentinelPassword, err := ReadFromEnvOrConfig(cfg.SentinelPassword)
+		if err != nil {
+			return nil, err
+		}
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       cfg.MasterName,
+			SentinelAddrs:    append([]string{opts.Addr}, cfg.Addrs...),
+			SentinelPassword: sentinelPassword,
+			Username:         opts.Username,
+			Password:         opts.Password,
+			DB:               opts.DB,
+			TLSConfig:        opts.TLSConfig,
+		}), nil
+	case RedisModeCluster:
+		opts, err := redis.ParseClusterURL(url)
+		if err != nil {
+			return nil, err
+		}
+		opts.Addrs = append(opts.Addrs, cfg.Addrs...)
+		return redis.NewClusterClient(opts), nil
+	default:
+		return nil, fmt.Errorf("unknown redis mode: %s", cfg.Mode)
+	}
+}
@@ -0,0 +1,88 @@
+package proxyd
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/alicebob/miniredis"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRedisClient(t *testing.T) {
+	redisServer, err := miniredis.Run()
+	require.NoError(t, err)
+	defer redisServer.Close()
+
+	client, err := NewRedisClient(RedisConfig{URL: fmt.Sprintf("redis://127.0.0.1:%s", redisServer.Port())})
+	require.NoError(t, err)
+	defer client.Close()
+	require.IsType(t, &redis.Client{}, client)
+	require.NoError(t, client.Set(context.Background(), "foo", "bar", 0).Err())
+}
+
+func TestNewUniversalRedisClient(t *testing.T) {
+	t.Setenv("TEST_PROXYD_SENTINEL_PASSWORD", "secret")
+
+	tests := []struct {
+		name    string
+		cfg     RedisConfig
+		check   func(t *testing.T, client redis.UniversalClient)
+		wantErr string
+	}{
+		{
+			name: "sentinel",
+			cfg: RedisConfig{
+				URL:              "redis://:pass@sentinel-0:26379/2",
+				Mode:             RedisModeSentinel,
+				Addrs:            []string{"sentinel-1:26379"},
+				MasterName:       "mymaster",
+				SentinelPassword: "$TEST_PROXYD_SENTINEL_PASSWORD",
+			},
+			check: func(t *testing.T, client redis.UniversalClient) {
+				// failover clients are plain clients dialing the master found by the sentinels
+				require.IsType(t, &redis.Client{}, client)
+				opts := client.(*redis.Client).Options()
+				require.Equal(t, "pass", opts.Password)
+				require.Equal(t, 2, opts.DB)
+				require.Equal(t, "FailoverClient", opts.Addr)
+			},
+		},
+		{
+			name: "cluster",
+			cfg: RedisConfig{
+				URL:   "redis://node-0:6379?addr=node-1:6379",
+				Mode:  RedisModeCluster,
+				Addrs: []string{"node-2:6379"},
+			},
+			check: func(t *testing.T, client redis.UniversalClient) {
+				require.IsType(t, &redis.ClusterClient{}, client)
+				opts := client.(*redis.ClusterClient).Options()
+				require.Equal(t, []string{"node-0:6379", "node-1:6379", "node-2:6379"}, opts.Addrs)
+			},
+		},
+		{
+			name:    "sentinel without master name",
+			cfg:     RedisConfig{URL: "redis://sentinel-0:26379", Mode: RedisModeSentinel},
+			wantErr: "redis master_name must be set in sentinel mode",
+		},
+		{
+			name:    "unknown mode",
+			cfg:     RedisConfig{URL: "redis://localhost:6379", Mode: "replicated"},
+			wantErr: "unknown redis mode: replicated",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := newUniversalRedisClient(tt.cfg)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			defer client.Close()
+			tt.check(t, client)
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package proxyd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+const (
+	defaultReorgHistorySize    = 100
+	defaultReorgWebhookTimeout = 5 * time.Second
+	reorgWebhookQueueSize      = 64
+)
+
+var errReorgWebhookURL = errors.New("reorgs.webhook_url must be an http or https URL")
+
+// ReorgHead is a consensus head before or after a reorg
+type ReorgHead struct {
+	Number hexutil.Uint64 `json:"number"`
+	// Hash is empty when unknown, e.g. when the head was set by another instance with consensus HA
+	Hash string `json:"hash,omitempty"`
+}
+
+// ReorgEvent is a break of the consensus of a backend group, where blocks already served
+// to the clients are replaced
+type ReorgEvent struct {
+	BackendGroup string    `json:"backend_group"`
+	Time         time.Time `json:"time"`
+	OldHead      ReorgHead `json:"old_head"`
+	NewHead      ReorgHead `json:"new_head"`
+	// Depth is the number of consensus blocks replaced
+	Depth uint64 `json:"depth"`
+	// Backends are the backends that broke the consensus
+	Backends []string `json:"backends"`
+}
+
+// ReorgTracker keeps a bounded history of the reorg events of all the backend groups,
+// and notifies them to a webhook
+type ReorgTracker struct {
+	mu      sync.Mutex
+	history []*ReorgEvent
+	next    int
+	size    int
+
+	webhookURL      string
+	webhookHeaders  map[string]string
+	webhookMinDepth uint64
+	client          *http.Client
+	queue           chan *ReorgEvent
+	ctx             context.Context
+	cancelFunc      context.CancelFunc
+	done            chan struct{}
+}
+
+func NewReorgTracker(cfg ReorgsConfig) (*ReorgTracker, error) {
+	size := cfg.HistorySize
+	if size == 0 {
+		size = defaultReorgHistorySize
+	}
+	if size < 0 {
+		return nil, errors.New("reorgs.history_size must not be negative")
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	rt := &ReorgTracker{
+		history:    make([]*ReorgEvent, 0, size),
+		size:       size,
+		ctx:        ctx,
+		cancelFunc: cancelFunc,
+		done:       make(chan struct{}),
+	}
+
+	if cfg.WebhookURL == "" {
+		close(rt.done)
+		return rt, nil
+	}
+
+	webhookURL, err := ReadFromEnvOrConfig(cfg.WebhookURL)
+	if err != nil {
+		return nil, err
+	}
+	if !isHTTPURL(webhookURL) {
+		return nil, errReorgWebhookURL
+	}
+	headers := make(map[string]string, len(cfg.WebhookHeaders))
+	for name, value := range cfg.WebhookHeaders {
+		value, err := ReadFromEnvOrConfig(value)
+		if err != nil {
+			return nil, err
+		}
+		headers[name] = value
+	}
+	timeout := time.Duration(cfg.WebhookTimeout)
+	if timeout == 0 {
+		timeout = defaultReorgWebhookTimeout
+	}
+
+	rt.webhookURL = webhookURL
+	rt.webhookHeaders = headers
+	rt.webhookMinDepth = cfg.WebhookMinDepth
+	rt.client = &http.Client{Timeout: timeout}
+	rt.queue = make(chan *ReorgEvent, reorgWebhookQueueSize)
+	go rt.notifyLoop()
+	return rt, nil
+}
+
+// Record adds a reorg event to the history, and queues its notification.
+// It never blocks on the webhook, events are dropped when the queue is full.
+func (rt *ReorgTracker) Record(event *ReorgEvent) {
+	RecordConsensusReorg(event)
+	log.Warn("consensus reorg",
+		"backend_group", event.BackendGroup,
+		"depth", event.Depth,
+		"oldHead", event.OldHead.Number,
+		"oldHeadHash", event.OldHead.Hash,
+		"newHead", event.NewHead.Number,
+		"newHeadHash", event.NewHead.Hash,
+		"backends", event.Backends)
+
+	rt.mu.Lock()
+	if len(rt.history) < rt.size {
+		rt.history = append(rt.history, event)
+	} else {
+		rt.history[rt.next] = event
+	}
+	rt.next = (rt.next + 1) % rt.size
+	rt.mu.Unlock()
+
+	if rt.queue == nil || event.Depth < rt.webhookMinDepth {
+		return
+	}
+	select {
+	case rt.queue <- event:
+	default:
+		log.Warn("dropping reorg notification, the webhook is falling behind", "backend_group", event.BackendGroup)
+		RecordReorgNotification(false)
+	}
+}
+
+// Events returns the reorg events in the history, the most recent first
+func (rt *ReorgTracker) Events() []*ReorgEvent {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	events := make([]*ReorgEvent, 0, len(rt.history))
+	for i := 1; i <= len(rt.history); i++ {
+		events = append(events, rt.history[(rt.next-i+len(rt.history))%len(rt.history)])
+	}
+	return events
+}
+
+// HandleReorgs serves the history as JSON, optionally filtered by the backend_group query parameter
+func (rt *ReorgTracker) HandleReorgs(w http.ResponseWriter, r *http.Request) {
+	events := rt.Events()
+	if group := r.URL.Query().Get("backend_group"); group != "" {
+		filtered := make([]*ReorgEvent, 0, len(events))
+		for _, event := range events {
+			if event.BackendGroup == group {
+				filtered = append(filtered, event)
+			}
+		}
+		events = filtered
+	}
+	w.Header().Set("content-type", "application/json")
+	if err := json.NewEncoder(w).Encode(events); err != nil {
+		log.Error("error writing reorg events", "err", err)
+	}
+}
+
+// Shutdown stops the notifications, waiting for the one in flight
+func (rt *ReorgTracker) Shutdown() {
+	rt.cancelFunc()
+	<-rt.done
+}
+
+func (rt *ReorgTracker) notifyLoop() {
+	defer close(rt.done)
+	for {
+		select {
+		case event := <-rt.queue:
+			err := rt.notify(event)
+			if err != nil {
+				log.Error("error notifying reorg", "backend_group", event.BackendGroup, "err", err)
+			}
+			RecordReorgNotification(err == nil)
+		case <-rt.ctx.Done():
+			return
+		}
+	}
+}
+
+func (rt *ReorgTracker) notify(event *ReorgEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(rt.ctx, http.MethodPost, rt.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("content-type", "application/json")
+	for name, value := range rt.webhookHeaders {
+		req.Header.Set(name, value)
+	}
+	res, err := rt.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", res.StatusCode)
+	}
+	return nil
+}
+
+func isHTTPURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
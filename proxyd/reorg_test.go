@@ -0,0 +1,79 @@
+package proxyd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReorgTrackerHistory(t *testing.T) {
+	rt, err := NewReorgTracker(ReorgsConfig{HistorySize: 2})
+	require.NoError(t, err)
+	defer rt.Shutdown()
+
+	for i := 1; i <= 3; i++ {
+		group := "main"
+		if i == 2 {
+			group = "other"
+		}
+		rt.Record(&ReorgEvent{BackendGroup: group, Depth: uint64(i), Time: time.Now()})
+	}
+
+	// the oldest event is evicted, the most recent comes first
+	events := rt.Events()
+	require.Len(t, events, 2)
+	require.Equal(t, uint64(3), events[0].Depth)
+	require.Equal(t, uint64(2), events[1].Depth)
+
+	rec := httptest.NewRecorder()
+	rt.HandleReorgs(rec, httptest.NewRequest(http.MethodGet, "/reorgs?backend_group=main", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var served []*ReorgEvent
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&served))
+	require.Len(t, served, 1)
+	require.Equal(t, uint64(3), served[0].Depth)
+}
+
+func TestReorgTrackerWebhook(t *testing.T) {
+	t.Setenv("TEST_PROXYD_WEBHOOK_TOKEN", "token")
+
+	received := make(chan *ReorgEvent, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "token", r.Header.Get("Authorization"))
+		event := new(ReorgEvent)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(event))
+		received <- event
+	}))
+	defer webhook.Close()
+
+	rt, err := NewReorgTracker(ReorgsConfig{
+		WebhookURL:      webhook.URL,
+		WebhookHeaders:  map[string]string{"Authorization": "$TEST_PROXYD_WEBHOOK_TOKEN"},
+		WebhookMinDepth: 2,
+	})
+	require.NoError(t, err)
+	defer rt.Shutdown()
+
+	// shallow reorgs are only kept in the history
+	rt.Record(&ReorgEvent{BackendGroup: "main", Depth: 1, Backends: []string{"node1"}})
+	rt.Record(&ReorgEvent{BackendGroup: "main", Depth: 3, Backends: []string{"node2"}})
+
+	select {
+	case event := <-received:
+		require.Equal(t, uint64(3), event.Depth)
+		require.Equal(t, []string{"node2"}, event.Backends)
+	case <-time.After(5 * time.Second):
+		t.Fatal("reorg was not notified")
+	}
+	require.Len(t, rt.Events(), 2)
+}
+
+func TestReorgTrackerInvalidWebhookURL(t *testing.T) {
+	_, err := NewReorgTracker(ReorgsConfig{WebhookURL: "localhost:8080/reorgs"})
+	require.ErrorIs(t, err, errReorgWebhookURL)
+}
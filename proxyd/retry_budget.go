@@ -0,0 +1,109 @@
+package proxyd
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+const (
+	ContextKeyRetryBudget = "retry_budget"
+
+	DefaultRetryBudgetRatio     = 0.1
+	DefaultRetryBudgetMaxTokens = 10
+)
+
+// RetryBudget limits the retries of a backend group to a ratio of its recent requests, so that retries
+// help with isolated failures without amplifying a broad outage.
+// Every request deposits `ratio` tokens, up to `max_tokens`, and every retry withdraws one token.
+// A nil budget allows every retry
+type RetryBudget struct {
+	group     string
+	ratio     float64
+	maxTokens float64
+
+	mtx    sync.Mutex
+	tokens float64
+}
+
+var (
+	errRetryBudgetRatio     = errors.New("retry budget ratio must not be negative")
+	errRetryBudgetMaxTokens = errors.New("retry budget max_tokens must be at least 1")
+)
+
+// NewRetryBudget creates a full budget, or returns nil if the config is nil
+func NewRetryBudget(group string, cfg *RetryBudgetConfig) (*RetryBudget, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if err := validateRetryBudget(cfg); err != nil {
+		return nil, err
+	}
+	rb := &RetryBudget{
+		group:     group,
+		ratio:     cfg.Ratio,
+		maxTokens: cfg.MaxTokens,
+	}
+	if rb.ratio == 0 {
+		rb.ratio = DefaultRetryBudgetRatio
+	}
+	if rb.maxTokens == 0 {
+		rb.maxTokens = DefaultRetryBudgetMaxTokens
+	}
+	rb.tokens = rb.maxTokens
+	RecordRetryBudgetTokens(rb.group, rb.tokens)
+	return rb, nil
+}
+
+func validateRetryBudget(cfg *RetryBudgetConfig) error {
+	if cfg.Ratio < 0 {
+		return errRetryBudgetRatio
+	}
+	if cfg.MaxTokens != 0 && cfg.MaxTokens < 1 {
+		return errRetryBudgetMaxTokens
+	}
+	return nil
+}
+
+// Deposit accounts for a new request
+func (rb *RetryBudget) Deposit() {
+	if rb == nil {
+		return
+	}
+	rb.mtx.Lock()
+	defer rb.mtx.Unlock()
+	rb.tokens = min(rb.tokens+rb.ratio, rb.maxTokens)
+	RecordRetryBudgetTokens(rb.group, rb.tokens)
+}
+
+// Withdraw accounts for a retry, and returns false if the budget is exhausted
+func (rb *RetryBudget) Withdraw() bool {
+	if rb == nil {
+		return true
+	}
+	rb.mtx.Lock()
+	defer rb.mtx.Unlock()
+	allowed := rb.tokens >= 1
+	if allowed {
+		rb.tokens--
+	}
+	RecordRetryBudgetTokens(rb.group, rb.tokens)
+	RecordRetryBudgetRetry(rb.group, allowed)
+	return allowed
+}
+
+func withRetryBudget(ctx context.Context, rb *RetryBudget) context.Context {
+	if rb == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, ContextKeyRetryBudget, rb) // nolint:staticcheck
+}
+
+// allowRetry withdraws a retry from the budget of the context, if any
+func allowRetry(ctx context.Context) bool {
+	rb, ok := ctx.Value(ContextKeyRetryBudget).(*RetryBudget)
+	if !ok {
+		return true
+	}
+	return rb.Withdraw()
+}
@@ -0,0 +1,45 @@
+package proxyd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryBudget(t *testing.T) {
+	rb, err := NewRetryBudget("main", &RetryBudgetConfig{Ratio: 0.5, MaxTokens: 2})
+	require.NoError(t, err)
+
+	// the budget starts full, and deposits are capped
+	rb.Deposit()
+	require.True(t, rb.Withdraw())
+	require.True(t, rb.Withdraw())
+	require.False(t, rb.Withdraw())
+
+	// two requests earn a retry
+	rb.Deposit()
+	require.False(t, rb.Withdraw())
+	rb.Deposit()
+	require.True(t, rb.Withdraw())
+
+	// the budget is shared through the context
+	ctx := withRetryBudget(context.Background(), rb)
+	require.False(t, allowRetry(ctx))
+	require.True(t, allowRetry(context.Background()))
+
+	// no budget allows every retry
+	var none *RetryBudget
+	none.Deposit()
+	require.True(t, none.Withdraw())
+
+	rb, err = NewRetryBudget("main", &RetryBudgetConfig{})
+	require.NoError(t, err)
+	require.Equal(t, DefaultRetryBudgetRatio, rb.ratio)
+	require.Equal(t, float64(DefaultRetryBudgetMaxTokens), rb.maxTokens)
+
+	_, err = NewRetryBudget("main", &RetryBudgetConfig{Ratio: -1})
+	require.ErrorIs(t, err, errRetryBudgetRatio)
+	_, err = NewRetryBudget("main", &RetryBudgetConfig{MaxTokens: 0.5})
+	require.ErrorIs(t, err, errRetryBudgetMaxTokens)
+}
@@ -0,0 +1,73 @@
+package proxyd
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// RetryableErrorClassifier tells apart the JSON-RPC errors that another backend could answer,
+// e.g. a node missing a block or state it hasn't synced yet, or a provider over its capacity
+type RetryableErrorClassifier struct {
+	rules []*retryableErrorRule
+}
+
+type retryableErrorRule struct {
+	name    string
+	code    int
+	message *regexp.Regexp
+}
+
+var errRetryableErrorRule = errors.New("retryable error rule must set a code or a message")
+
+// NewRetryableErrorClassifier compiles the rules in order. It returns nil if there are no rules
+func NewRetryableErrorClassifier(cfgs ...[]RetryableErrorConfig) (*RetryableErrorClassifier, error) {
+	c := &RetryableErrorClassifier{}
+	for _, rules := range cfgs {
+		for _, cfg := range rules {
+			if cfg.Code == 0 && cfg.Message == "" {
+				return nil, errRetryableErrorRule
+			}
+			rule := &retryableErrorRule{
+				name: cfg.Name,
+				code: cfg.Code,
+			}
+			if cfg.Message != "" {
+				re, err := regexp.Compile(cfg.Message)
+				if err != nil {
+					return nil, fmt.Errorf("invalid retryable error message %q: %w", cfg.Message, err)
+				}
+				rule.message = re
+			}
+			if rule.name == "" {
+				rule.name = cfg.Message
+				if rule.name == "" {
+					rule.name = strconv.Itoa(cfg.Code)
+				}
+			}
+			c.rules = append(c.rules, rule)
+		}
+	}
+	if len(c.rules) == 0 {
+		return nil, nil
+	}
+	return c, nil
+}
+
+// Classify returns the name of the first rule matching the error, and whether it is retryable
+func (c *RetryableErrorClassifier) Classify(rpcErr *RPCErr) (string, bool) {
+	if rpcErr == nil {
+		return "", false
+	}
+	for _, rule := range c.rules {
+		if rule.code != 0 && rule.code != rpcErr.Code {
+			continue
+		}
+		if rule.message != nil && !rule.message.MatchString(rpcErr.Message) {
+			continue
+		}
+		return rule.name, true
+	}
+	return "", false
+}
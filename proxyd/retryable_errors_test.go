@@ -0,0 +1,48 @@
+package proxyd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryableErrorClassifier(t *testing.T) {
+	c, err := NewRetryableErrorClassifier(
+		[]RetryableErrorConfig{
+			{Message: "^header not found$"},
+			{Name: "missing_trie_node", Code: -32000, Message: "missing trie node"},
+		},
+		[]RetryableErrorConfig{
+			{Code: -32005},
+		},
+	)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		err       *RPCErr
+		rule      string
+		retryable bool
+	}{
+		{"message only", &RPCErr{Code: -32000, Message: "header not found"}, "^header not found$", true},
+		{"code and message", &RPCErr{Code: -32000, Message: "missing trie node 0x1234"}, "missing_trie_node", true},
+		{"code mismatch", &RPCErr{Code: -32603, Message: "missing trie node 0x1234"}, "", false},
+		{"code only", &RPCErr{Code: -32005, Message: "capacity exceeded"}, "-32005", true},
+		{"no match", &RPCErr{Code: -32000, Message: "execution reverted"}, "", false},
+		{"no error", nil, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, retryable := c.Classify(tt.err)
+			require.Equal(t, tt.retryable, retryable)
+			require.Equal(t, tt.rule, rule)
+		})
+	}
+
+	c, err = NewRetryableErrorClassifier(nil)
+	require.NoError(t, err)
+	require.Nil(t, c)
+
+	_, err = NewRetryableErrorClassifier([]RetryableErrorConfig{{Name: "empty"}})
+	require.ErrorIs(t, err, errRetryableErrorRule)
+}
@@ -308,3 +308,106 @@ func rewriteTagBlockNumberOrHash(rctx RewriteContext, current *rpc.BlockNumberOr
 
 	return current, false, nil
 }
+
+// RequestBlockNumber returns the highest block number the request reads, resolving the `safe`
+// and `finalized` tags with the rewrite context.
+// It returns false when the request follows the head of the chain, i.e. it uses the `latest`
+// or `pending` tags, a block hash, or isn't a block-specific method
+func RequestBlockNumber(rctx RewriteContext, req *RPCReq) (hexutil.Uint64, bool) {
+	switch req.Method {
+	case "eth_getLogs":
+		return rangeBlockNumber(rctx, req, 0)
+	case "debug_getRawReceipts", "consensus_getReceipts":
+		return paramBlockNumber(rctx, req, 0)
+	case "eth_getBalance",
+		"eth_getCode",
+		"eth_getTransactionCount",
+		"eth_call":
+		return paramBlockNumber(rctx, req, 1)
+	case "eth_getStorageAt",
+		"eth_getProof":
+		return paramBlockNumber(rctx, req, 2)
+	case "eth_getBlockTransactionCountByNumber",
+		"eth_getUncleCountByBlockNumber",
+		"eth_getBlockByNumber",
+		"eth_getTransactionByBlockNumberAndIndex",
+		"eth_getUncleByBlockNumberAndIndex":
+		return paramBlockNumber(rctx, req, 0)
+	}
+	return 0, false
+}
+
+// requestsBlockNumber returns the highest block number read by the requests,
+// or false if any of them follows the head of the chain
+func requestsBlockNumber(rctx RewriteContext, rpcReqs []*RPCReq) (hexutil.Uint64, bool) {
+	var highest hexutil.Uint64
+	for _, req := range rpcReqs {
+		block, ok := RequestBlockNumber(rctx, req)
+		if !ok {
+			return 0, false
+		}
+		if block > highest {
+			highest = block
+		}
+	}
+	return highest, len(rpcReqs) > 0
+}
+
+func paramBlockNumber(rctx RewriteContext, req *RPCReq, pos int) (hexutil.Uint64, bool) {
+	var p []interface{}
+	if err := json.Unmarshal(req.Params, &p); err != nil {
+		return 0, false
+	}
+	// a missing block param defaults to latest
+	if len(p) <= pos {
+		return 0, false
+	}
+	return resolveBlockNumber(rctx, p[pos])
+}
+
+func rangeBlockNumber(rctx RewriteContext, req *RPCReq, pos int) (hexutil.Uint64, bool) {
+	var p []map[string]interface{}
+	if err := json.Unmarshal(req.Params, &p); err != nil {
+		return 0, false
+	}
+	// a missing fromBlock or toBlock defaults to latest, and a filter by block hash
+	// doesn't tell its block number
+	if len(p) <= pos || p[pos]["fromBlock"] == nil || p[pos]["toBlock"] == nil {
+		return 0, false
+	}
+	from, ok := resolveBlockNumber(rctx, p[pos]["fromBlock"])
+	if !ok {
+		return 0, false
+	}
+	to, ok := resolveBlockNumber(rctx, p[pos]["toBlock"])
+	if !ok {
+		return 0, false
+	}
+	if from > to {
+		return from, true
+	}
+	return to, true
+}
+
+func resolveBlockNumber(rctx RewriteContext, current interface{}) (hexutil.Uint64, bool) {
+	bnh, err := remarshalBlockNumberOrHash(current)
+	if err != nil || bnh.BlockNumber == nil {
+		return 0, false
+	}
+
+	switch *bnh.BlockNumber {
+	case rpc.LatestBlockNumber,
+		rpc.PendingBlockNumber:
+		return 0, false
+	case rpc.EarliestBlockNumber:
+		return 0, true
+	case rpc.FinalizedBlockNumber:
+		return rctx.finalized, true
+	case rpc.SafeBlockNumber:
+		return rctx.safe, true
+	}
+	if bnh.BlockNumber.Int64() < 0 {
+		return 0, false
+	}
+	return hexutil.Uint64(bnh.BlockNumber.Int64()), true
+}
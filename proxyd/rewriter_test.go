@@ -638,6 +638,101 @@ func TestRewriteRequest(t *testing.T) {
 	}
 }
 
+func TestRequestBlockNumber(t *testing.T) {
+	rctx := RewriteContext{latest: hexutil.Uint64(100), safe: hexutil.Uint64(90), finalized: hexutil.Uint64(80)}
+	tests := []struct {
+		name     string
+		req      *RPCReq
+		expected hexutil.Uint64
+		pinned   bool
+	}{
+		{
+			name:     "block number",
+			req:      &RPCReq{Method: "eth_getBlockByNumber", Params: mustMarshalJSON([]interface{}{"0x37", false})},
+			expected: 55,
+			pinned:   true,
+		},
+		{
+			name:     "finalized tag",
+			req:      &RPCReq{Method: "eth_getBlockByNumber", Params: mustMarshalJSON([]interface{}{"finalized", false})},
+			expected: 80,
+			pinned:   true,
+		},
+		{
+			name:     "earliest tag",
+			req:      &RPCReq{Method: "eth_getBalance", Params: mustMarshalJSON([]interface{}{"0x123", "earliest"})},
+			expected: 0,
+			pinned:   true,
+		},
+		{
+			name:   "latest tag",
+			req:    &RPCReq{Method: "eth_getBlockByNumber", Params: mustMarshalJSON([]interface{}{"latest", false})},
+			pinned: false,
+		},
+		{
+			name:   "pending tag",
+			req:    &RPCReq{Method: "eth_getTransactionCount", Params: mustMarshalJSON([]interface{}{"0x123", "pending"})},
+			pinned: false,
+		},
+		{
+			name:   "missing block param defaults to latest",
+			req:    &RPCReq{Method: "eth_getBalance", Params: mustMarshalJSON([]interface{}{"0x123"})},
+			pinned: false,
+		},
+		{
+			name: "block hash",
+			req: &RPCReq{Method: "eth_call", Params: mustMarshalJSON([]interface{}{
+				map[string]interface{}{},
+				map[string]interface{}{"blockHash": common.HexToHash("0x123").Hex()},
+			})},
+			pinned: false,
+		},
+		{
+			name: "block number object",
+			req: &RPCReq{Method: "eth_getStorageAt", Params: mustMarshalJSON([]interface{}{
+				"0x123", "0x0", map[string]interface{}{"blockNumber": "0x42"},
+			})},
+			expected: 66,
+			pinned:   true,
+		},
+		{
+			name:     "eth_getLogs range",
+			req:      &RPCReq{Method: "eth_getLogs", Params: mustMarshalJSON([]map[string]interface{}{{"fromBlock": "0x10", "toBlock": "safe"}})},
+			expected: 90,
+			pinned:   true,
+		},
+		{
+			name:   "eth_getLogs open range",
+			req:    &RPCReq{Method: "eth_getLogs", Params: mustMarshalJSON([]map[string]interface{}{{"fromBlock": "0x10"}})},
+			pinned: false,
+		},
+		{
+			name:   "not block specific",
+			req:    &RPCReq{Method: "eth_getTransactionReceipt", Params: mustMarshalJSON([]interface{}{"0x123"})},
+			pinned: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block, pinned := RequestBlockNumber(rctx, tt.req)
+			require.Equal(t, tt.pinned, pinned)
+			require.Equal(t, tt.expected, block)
+		})
+	}
+
+	// a call is pinned to the highest block of its requests, unless one of them follows the head
+	reqs := []*RPCReq{
+		{Method: "eth_getBlockByNumber", Params: mustMarshalJSON([]interface{}{"0x37", false})},
+		{Method: "eth_getBlockByNumber", Params: mustMarshalJSON([]interface{}{"safe", false})},
+	}
+	block, pinned := requestsBlockNumber(rctx, reqs)
+	require.True(t, pinned)
+	require.Equal(t, hexutil.Uint64(90), block)
+	reqs = append(reqs, &RPCReq{Method: "eth_getBlockByNumber", Params: mustMarshalJSON([]interface{}{"latest", false})})
+	_, pinned = requestsBlockNumber(rctx, reqs)
+	require.False(t, pinned)
+}
+
 func generalize(tests []rewriteTest, baseMethod string, generalizedMethod string) []rewriteTest {
 	newCases := make([]rewriteTest, 0)
 	for _, t := range tests {
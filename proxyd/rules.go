@@ -0,0 +1,278 @@
+package proxyd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+const (
+	RoutingActionRoute     = "route"
+	RoutingActionReject    = "reject"
+	RoutingActionRateLimit = "rate_limit"
+)
+
+type limiterFactoryFunc func(dur time.Duration, max int, prefix string) FrontendRateLimiter
+
+// RoutingRule is a compiled RoutingRuleConfig
+type RoutingRule struct {
+	Name         string
+	Action       string
+	BackendGroup string
+	Priority     string
+
+	rpcErr *RPCErr
+	lim    FrontendRateLimiter
+
+	auth      map[string]bool
+	origin    *regexp.Regexp
+	userAgent *regexp.Regexp
+	methods   map[string]bool
+	params    []*paramPredicate
+	minSize   int
+	maxSize   int
+}
+
+type paramPredicate struct {
+	path    []string
+	values  []string
+	pattern *regexp.Regexp
+}
+
+// NewRoutingRules compiles the routing rules, preserving their order
+func NewRoutingRules(cfgs []*RoutingRuleConfig, limiterFactory limiterFactoryFunc) ([]*RoutingRule, error) {
+	rules := make([]*RoutingRule, 0, len(cfgs))
+	for i, cfg := range cfgs {
+		rule, err := newRoutingRule(cfg, limiterFactory)
+		if err != nil {
+			return nil, fmt.Errorf("invalid routing rule %d (%s): %w", i, cfg.Name, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func newRoutingRule(cfg *RoutingRuleConfig, limiterFactory limiterFactoryFunc) (*RoutingRule, error) {
+	name := cfg.Name
+	if name == "" {
+		return nil, errors.New("must specify a name")
+	}
+
+	rule := &RoutingRule{
+		Name:         name,
+		Action:       cfg.Action,
+		BackendGroup: cfg.BackendGroup,
+		Priority:     cfg.Priority,
+		minSize:      cfg.Match.MinRequestSizeBytes,
+		maxSize:      cfg.Match.MaxRequestSizeBytes,
+	}
+
+	switch cfg.Action {
+	case RoutingActionRoute:
+		if cfg.BackendGroup == "" && cfg.Priority == "" {
+			return nil, errors.New("must specify a backend_group or a priority for the route action")
+		}
+	case RoutingActionReject:
+		rule.rpcErr = ErrRejectedByRule.Clone()
+		if cfg.ErrorCode != 0 {
+			rule.rpcErr.Code = cfg.ErrorCode
+		}
+		if cfg.ErrorMessage != "" {
+			rule.rpcErr.Message = cfg.ErrorMessage
+		}
+	case RoutingActionRateLimit:
+		if cfg.Limit <= 0 {
+			return nil, errors.New("limit must be > 0 for the rate_limit action")
+		}
+		if cfg.Interval <= 0 {
+			return nil, errors.New("must specify an interval for the rate_limit action")
+		}
+		rule.lim = limiterFactory(time.Duration(cfg.Interval), cfg.Limit, "rule:"+name)
+	default:
+		return nil, fmt.Errorf("unknown action %q", cfg.Action)
+	}
+
+	if len(cfg.Match.Auth) > 0 {
+		rule.auth = make(map[string]bool, len(cfg.Match.Auth))
+		for _, alias := range cfg.Match.Auth {
+			rule.auth[alias] = true
+		}
+	}
+	if len(cfg.Match.Methods) > 0 {
+		rule.methods = make(map[string]bool, len(cfg.Match.Methods))
+		for _, method := range cfg.Match.Methods {
+			rule.methods[method] = true
+		}
+	}
+
+	var err error
+	if cfg.Match.Origin != "" {
+		if rule.origin, err = regexp.Compile(cfg.Match.Origin); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Match.UserAgent != "" {
+		if rule.userAgent, err = regexp.Compile(cfg.Match.UserAgent); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, p := range cfg.Match.Params {
+		if p.Path == "" {
+			return nil, errors.New("must specify a path for param predicates")
+		}
+		if len(p.Values) == 0 && p.Pattern == "" {
+			return nil, fmt.Errorf("param predicate %s must specify values or a pattern", p.Path)
+		}
+		pred := &paramPredicate{
+			path:   strings.Split(p.Path, "."),
+			values: p.Values,
+		}
+		if p.Pattern != "" {
+			if pred.pattern, err = regexp.Compile(p.Pattern); err != nil {
+				return nil, err
+			}
+		}
+		rule.params = append(rule.params, pred)
+	}
+
+	return rule, nil
+}
+
+// Matches checks whether all the predicates of the rule match the request
+func (r *RoutingRule) Matches(ctx context.Context, req *RPCReq, size int) bool {
+	if r.methods != nil && !r.methods[req.Method] {
+		return false
+	}
+	if r.auth != nil && !r.auth[GetAuthCtx(ctx)] {
+		return false
+	}
+	if r.origin != nil && !r.origin.MatchString(GetOrigin(ctx)) {
+		return false
+	}
+	if r.userAgent != nil && !r.userAgent.MatchString(GetUserAgent(ctx)) {
+		return false
+	}
+	if r.minSize > 0 && size < r.minSize {
+		return false
+	}
+	if r.maxSize > 0 && size > r.maxSize {
+		return false
+	}
+	if len(r.params) > 0 {
+		var params interface{}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return false
+		}
+		for _, pred := range r.params {
+			if !pred.matches(params) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (p *paramPredicate) matches(params interface{}) bool {
+	val, ok := lookupParam(params, p.path)
+	if !ok {
+		return false
+	}
+	if p.pattern != nil && !p.pattern.MatchString(val) {
+		return false
+	}
+	if len(p.values) == 0 {
+		return true
+	}
+	for _, v := range p.values {
+		// hex values such as addresses are compared case-insensitively
+		if strings.EqualFold(v, val) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupParam walks the params following the path, and returns the value found as a string
+func lookupParam(params interface{}, path []string) (string, bool) {
+	cur := params
+	for _, seg := range path {
+		switch typed := cur.(type) {
+		case []interface{}:
+			idx, err := strconv.Atoi(seg)
+			if err != nil || idx < 0 || idx >= len(typed) {
+				return "", false
+			}
+			cur = typed[idx]
+		case map[string]interface{}:
+			v, ok := typed[seg]
+			if !ok {
+				return "", false
+			}
+			cur = v
+		default:
+			return "", false
+		}
+	}
+
+	switch typed := cur.(type) {
+	case nil:
+		return "", false
+	case string:
+		return typed, true
+	default:
+		return string(mustMarshalJSON(typed)), true
+	}
+}
+
+// matchRoutingRule returns the first rule matching the request, if any
+func (s *Server) matchRoutingRule(ctx context.Context, req *RPCReq, size int) *RoutingRule {
+	for _, rule := range s.routingRules {
+		if rule.Matches(ctx, req, size) {
+			RecordRoutingRuleMatch(rule)
+			return rule
+		}
+	}
+	return nil
+}
+
+// applyRoutingRule enforces the action of a rule. It returns the backend group the
+// request must be routed to (empty to use the method mappings), or an error if
+// the request must be rejected.
+func (s *Server) applyRoutingRule(ctx context.Context, rule *RoutingRule, req *RPCReq) (string, error) {
+	switch rule.Action {
+	case RoutingActionReject:
+		log.Info(
+			"rejected request by routing rule",
+			"source", "rpc",
+			"req_id", GetReqID(ctx),
+			"rule", rule.Name,
+			"method", req.Method,
+		)
+		return "", rule.rpcErr
+	case RoutingActionRateLimit:
+		ok, err := rule.lim.Take(ctx, stripXFF(GetXForwardedFor(ctx)))
+		if err != nil {
+			log.Warn("error taking rate limit", "rule", rule.Name, "err", err)
+			return "", ErrOverRateLimit
+		}
+		if !ok {
+			log.Info(
+				"rate limited request by routing rule",
+				"source", "rpc",
+				"req_id", GetReqID(ctx),
+				"rule", rule.Name,
+				"method", req.Method,
+			)
+			return "", ErrOverRateLimit
+		}
+	}
+	return rule.BackendGroup, nil
+}
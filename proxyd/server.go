@@ -24,16 +24,24 @@ import (
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
+	"github.com/klauspost/compress/gzip"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/cors"
 	"github.com/syndtr/goleveldb/leveldb/opt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/errgroup"
 )
 
 const (
 	ContextKeyAuth               = "authorization"
 	ContextKeyReqID              = "req_id"
 	ContextKeyXForwardedFor      = "x_forwarded_for"
+	ContextKeyOrigin             = "origin"
+	ContextKeyUserAgent          = "user_agent"
 	DefaultMaxBatchRPCCallsLimit = 100
 	MaxBatchRPCCallsHardLimit    = 1000
 	cacheStatusHdr               = "X-Proxyd-Cache-Status"
@@ -45,37 +53,52 @@ const (
 	defaultCacheTtl              = 1 * time.Hour
 	maxRequestBodyLogLen         = 2000
 	defaultMaxUpstreamBatchSize  = 10
-	defaultRateLimitHeader       = "X-Forwarded-For"
+	// defaultMaxUpstreamBatchParallelism is the number of upstream batches of a request forwarded at once
+	defaultMaxUpstreamBatchParallelism = 4
+	defaultRateLimitHeader             = "X-Forwarded-For"
 )
 
 var emptyArrayResponse = json.RawMessage("[]")
 
 type Server struct {
-	BackendGroups          map[string]*BackendGroup
-	wsBackendGroup         *BackendGroup
-	wsMethodWhitelist      *StringSet
-	rpcMethodMappings      map[string]string
-	maxBodySize            int64
-	enableRequestLog       bool
-	maxRequestBodyLogLen   int
-	authenticatedPaths     map[string]string
-	timeout                time.Duration
-	maxUpstreamBatchSize   int
-	maxBatchSize           int
-	enableServedByHeader   bool
-	upgrader               *websocket.Upgrader
-	mainLim                FrontendRateLimiter
-	overrideLims           map[string]FrontendRateLimiter
-	senderLim              FrontendRateLimiter
-	allowedChainIds        []*big.Int
-	limExemptOrigins       []*regexp.Regexp
-	limExemptUserAgents    []*regexp.Regexp
-	globallyLimitedMethods map[string]bool
-	rpcServer              *http.Server
-	wsServer               *http.Server
-	cache                  RPCCache
-	srvMu                  sync.Mutex
-	rateLimitHeader        string
+	BackendGroups               map[string]*BackendGroup
+	wsBackendGroup              *BackendGroup
+	wsMethodWhitelist           *StringSet
+	rpcMethodMappings           map[string]string
+	maxBodySize                 int64
+	enableRequestLog            bool
+	maxRequestBodyLogLen        int
+	authenticatedPaths          map[string]string
+	timeout                     time.Duration
+	maxUpstreamBatchSize        int
+	maxUpstreamBatchParallelism int
+	maxBatchSize                int
+	enableServedByHeader        bool
+	upgrader                    *websocket.Upgrader
+	mainLim                     FrontendRateLimiter
+	overrideLims                map[string]FrontendRateLimiter
+	senderLim                   FrontendRateLimiter
+	allowedChainIds             []*big.Int
+	limExemptOrigins            []*regexp.Regexp
+	limExemptUserAgents         []*regexp.Regexp
+	globallyLimitedMethods      map[string]bool
+	rpcServer                   *http.Server
+	wsServer                    *http.Server
+	cache                       RPCCache
+	srvMu                       sync.Mutex
+	rateLimitHeader             string
+	routingRules                []*RoutingRule
+	validator                   *RequestValidator
+	admission                   *AdmissionController
+	accessLog                   *AccessLogger
+	capture                     *TrafficCapture
+	mirrors                     []*Mirror
+	enableCompression           bool
+	compressionMinSize          int
+	enableResponseStreaming     bool
+	metering                    *Metering
+	spendTracker                *SpendTracker
+	reorgs                      *ReorgTracker
 }
 
 type limiterFunc func(method string) bool
@@ -96,7 +119,8 @@ func NewServer(
 	enableRequestLog bool,
 	maxRequestBodyLogLen int,
 	maxBatchSize int,
-	redisClient *redis.Client,
+	redisClient redis.UniversalClient,
+	routingRuleConfigs []*RoutingRuleConfig,
 ) (*Server, error) {
 	if cache == nil {
 		cache = &NoopRPCCache{}
@@ -167,25 +191,31 @@ func NewServer(
 		senderLim = limiterFactory(time.Duration(senderRateLimitConfig.Interval), senderRateLimitConfig.Limit, "senders")
 	}
 
+	routingRules, err := NewRoutingRules(routingRuleConfigs, limiterFactory)
+	if err != nil {
+		return nil, err
+	}
+
 	rateLimitHeader := defaultRateLimitHeader
 	if rateLimitConfig.IPHeaderOverride != "" {
 		rateLimitHeader = rateLimitConfig.IPHeaderOverride
 	}
 
 	return &Server{
-		BackendGroups:        backendGroups,
-		wsBackendGroup:       wsBackendGroup,
-		wsMethodWhitelist:    wsMethodWhitelist,
-		rpcMethodMappings:    rpcMethodMappings,
-		maxBodySize:          maxBodySize,
-		authenticatedPaths:   authenticatedPaths,
-		timeout:              timeout,
-		maxUpstreamBatchSize: maxUpstreamBatchSize,
-		enableServedByHeader: enableServedByHeader,
-		cache:                cache,
-		enableRequestLog:     enableRequestLog,
-		maxRequestBodyLogLen: maxRequestBodyLogLen,
-		maxBatchSize:         maxBatchSize,
+		BackendGroups:               backendGroups,
+		wsBackendGroup:              wsBackendGroup,
+		wsMethodWhitelist:           wsMethodWhitelist,
+		rpcMethodMappings:           rpcMethodMappings,
+		maxBodySize:                 maxBodySize,
+		authenticatedPaths:          authenticatedPaths,
+		timeout:                     timeout,
+		maxUpstreamBatchSize:        maxUpstreamBatchSize,
+		maxUpstreamBatchParallelism: defaultMaxUpstreamBatchParallelism,
+		enableServedByHeader:        enableServedByHeader,
+		cache:                       cache,
+		enableRequestLog:            enableRequestLog,
+		maxRequestBodyLogLen:        maxRequestBodyLogLen,
+		maxBatchSize:                maxBatchSize,
 		upgrader: &websocket.Upgrader{
 			HandshakeTimeout: defaultWSHandshakeTimeout,
 		},
@@ -197,6 +227,7 @@ func NewServer(
 		limExemptOrigins:       limExemptOrigins,
 		limExemptUserAgents:    limExemptUserAgents,
 		rateLimitHeader:        rateLimitHeader,
+		routingRules:           routingRules,
 	}, nil
 }
 
@@ -249,6 +280,21 @@ func (s *Server) Shutdown() {
 	for _, bg := range s.BackendGroups {
 		bg.Shutdown()
 	}
+	if s.accessLog != nil {
+		_ = s.accessLog.Close()
+	}
+	if s.capture != nil {
+		_ = s.capture.Close()
+	}
+	if s.metering != nil {
+		s.metering.Shutdown()
+	}
+	if s.spendTracker != nil {
+		s.spendTracker.Shutdown()
+	}
+	if s.reorgs != nil {
+		s.reorgs.Shutdown()
+	}
 }
 
 func (s *Server) HandleHealthz(w http.ResponseWriter, r *http.Request) {
@@ -264,6 +310,24 @@ func (s *Server) HandleRPC(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel = context.WithTimeout(ctx, s.timeout)
 	defer cancel()
 
+	// continue the trace of the client, if any
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(r.Header))
+	ctx, span := startSpan(ctx, "Server.HandleRPC")
+	defer span.End()
+
+	rec := s.accessLog.NewRecord(ctx)
+	ctx = withAccessLogRecord(ctx, rec)
+	defer s.accessLog.Write(rec)
+
+	capture := s.capture.start(r, GetReqID(ctx), GetAuthCtx(ctx))
+	w = capture.Wrap(w)
+	defer s.capture.finish(capture)
+
+	if s.enableCompression {
+		w.Header().Add("Vary", "Accept-Encoding")
+		ctx = withResponseEncoding(ctx, negotiateEncoding(r.Header.Get("Accept-Encoding")), s.compressionMinSize)
+	}
+
 	origin := r.Header.Get("Origin")
 	userAgent := r.Header.Get("User-Agent")
 	// Use XFF in context since it will automatically be replaced by the remote IP
@@ -315,6 +379,24 @@ func (s *Server) HandleRPC(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if s.metering != nil {
+		warning, err := s.metering.Check(GetAuthCtx(ctx))
+		if err != nil {
+			RecordRPCError(ctx, BackendProxyd, MethodUnknown, err)
+			log.Warn(
+				"request over quota",
+				"req_id", GetReqID(ctx),
+				"auth", GetAuthCtx(ctx),
+			)
+			writeRPCError(ctx, w, nil, err)
+			return
+		}
+		if warning {
+			w.Header().Set(quotaWarningHdr, "true")
+		}
+		ctx = withRequestUsage(ctx, &requestUsage{metering: s.metering, alias: GetAuthCtx(ctx)})
+	}
+
 	log.Info(
 		"received RPC request",
 		"req_id", GetReqID(ctx),
@@ -324,19 +406,48 @@ func (s *Server) HandleRPC(w http.ResponseWriter, r *http.Request) {
 		"remote_ip", xff,
 	)
 
-	body, err := io.ReadAll(LimitReader(r.Body, s.maxBodySize))
+	var reqBody io.Reader = r.Body
+	compressedBody := &countingReader{Reader: r.Body}
+	contentEncoding := strings.ToLower(r.Header.Get("Content-Encoding"))
+	switch contentEncoding {
+	case "", "identity":
+	case EncodingGzip:
+		// the decompressed body is limited below, so that small payloads can't expand past maxBodySize
+		gr, err := gzip.NewReader(LimitReader(compressedBody, s.maxBodySize))
+		if err != nil {
+			log.Info("error reading gzip request body", "req_id", GetReqID(ctx), "err", err)
+			writeRPCError(ctx, w, nil, ErrInvalidRequest("invalid gzip request body"))
+			return
+		}
+		defer gr.Close()
+		reqBody = gr
+	default:
+		writeRPCError(ctx, w, nil, ErrUnsupportedContentEncoding)
+		return
+	}
+
+	body, err := io.ReadAll(LimitReader(reqBody, s.maxBodySize))
 	if errors.Is(err, ErrLimitReaderOverLimit) {
 		log.Error("request body too large", "req_id", GetReqID(ctx))
 		RecordRPCError(ctx, BackendProxyd, MethodUnknown, ErrRequestBodyTooLarge)
 		writeRPCError(ctx, w, nil, ErrRequestBodyTooLarge)
 		return
 	}
+	if err != nil && contentEncoding == EncodingGzip {
+		log.Info("error reading gzip request body", "req_id", GetReqID(ctx), "err", err)
+		writeRPCError(ctx, w, nil, ErrInvalidRequest("invalid gzip request body"))
+		return
+	}
 	if err != nil {
 		log.Error("error reading request body", "err", err)
 		writeRPCError(ctx, w, nil, ErrInternal)
 		return
 	}
+	if contentEncoding == EncodingGzip {
+		RecordCompression(CompressionDirectionRequest, contentEncoding, len(body), compressedBody.N)
+	}
 	RecordRequestPayloadSize(ctx, len(body))
+	capture.SetBody(body)
 
 	if s.enableRequestLog {
 		log.Info("Raw RPC request",
@@ -356,6 +467,7 @@ func (s *Server) HandleRPC(w http.ResponseWriter, r *http.Request) {
 		}
 
 		RecordBatchSize(len(reqs))
+		span.SetAttributes(AttrBatchSize.Int(len(reqs)))
 
 		if len(reqs) > s.maxBatchSize {
 			RecordRPCError(ctx, BackendProxyd, MethodUnknown, ErrTooManyBatchRequests)
@@ -390,8 +502,22 @@ func (s *Server) HandleRPC(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	span.SetAttributes(AttrBatchSize.Int(1))
 	rawBody := json.RawMessage(body)
+	var stream *responseStream
+	if s.enableResponseStreaming {
+		stream = &responseStream{w: w, servedByHeader: s.enableServedByHeader}
+		ctx = withResponseStream(ctx, stream)
+	}
 	backendRes, cached, servedBy, err := s.handleBatchRPC(ctx, []json.RawMessage{rawBody}, isLimited, false)
+	if stream.Committed() {
+		getRequestUsage(ctx).record([]int{stream.body.Len})
+		if stream.interrupted {
+			// abort the connection, so that the client doesn't take the partial response for a complete one
+			panic(http.ErrAbortHandler)
+		}
+		return
+	}
 	if err != nil {
 		if errors.Is(err, ErrConsensusGetReceiptsCantBeBatched) ||
 			errors.Is(err, ErrConsensusGetReceiptsInvalidTarget) {
@@ -418,12 +544,18 @@ func (s *Server) handleBatchRPC(ctx context.Context, reqs []json.RawMessage, isL
 	type batchGroup struct {
 		groupID      int
 		backendGroup string
+		priority     string
 	}
 
 	responses := make([]*RPCRes, len(reqs))
 	batches := make(map[batchGroup][]batchElem)
 	ids := make(map[string]int, len(reqs))
 
+	methods := make([]string, len(reqs))
+
+	rec := getAccessLogRecord(ctx)
+	rec.initCalls(len(reqs), isBatch)
+
 	for i := range reqs {
 		parsedReq, err := ParseRPCReq(reqs[i])
 		if err != nil {
@@ -432,6 +564,15 @@ func (s *Server) handleBatchRPC(ctx context.Context, reqs []json.RawMessage, isL
 			continue
 		}
 
+		if !isBatch {
+			trace.SpanFromContext(ctx).SetAttributes(AttrMethod.String(parsedReq.Method))
+		}
+		rec.setMethod(i, parsedReq.Method)
+		// unmapped methods are metered as unknown, so that clients can't create arbitrary usage keys
+		if _, ok := s.rpcMethodMappings[parsedReq.Method]; ok {
+			methods[i] = parsedReq.Method
+		}
+
 		// Simple health check
 		if len(reqs) == 1 && parsedReq.Method == proxydHealthzMethod {
 			res := &RPCRes{
@@ -455,6 +596,24 @@ func (s *Server) handleBatchRPC(ctx context.Context, reqs []json.RawMessage, isL
 		}
 
 		group := s.rpcMethodMappings[parsedReq.Method]
+		rule := s.matchRoutingRule(ctx, parsedReq, len(reqs[i]))
+		if rule != nil {
+			ruleGroup, err := s.applyRoutingRule(ctx, rule, parsedReq)
+			if err != nil {
+				// rules run before the whitelist check, so unmapped methods are recorded as unknown
+				method := parsedReq.Method
+				if group == "" {
+					method = MethodUnknown
+				}
+				RecordRPCError(ctx, BackendProxyd, method, err)
+				responses[i] = NewRPCErrorRes(parsedReq.ID, err)
+				continue
+			}
+			// rules only reroute whitelisted methods, they can't expose the other ones
+			if ruleGroup != "" && group != "" {
+				group = ruleGroup
+			}
+		}
 		if group == "" {
 			// use unknown below to prevent DOS vector that fills up memory
 			// with arbitrary method names.
@@ -469,6 +628,19 @@ func (s *Server) handleBatchRPC(ctx context.Context, reqs []json.RawMessage, isL
 			continue
 		}
 
+		if err := s.validator.Validate(parsedReq); err != nil {
+			log.Info(
+				"rejected invalid request",
+				"source", "rpc",
+				"req_id", GetReqID(ctx),
+				"method", parsedReq.Method,
+				"err", err,
+			)
+			RecordRPCError(ctx, BackendProxyd, parsedReq.Method, err)
+			responses[i] = NewRPCErrorRes(parsedReq.ID, err)
+			continue
+		}
+
 		// Take rate limit for specific methods.
 		// NOTE: eventually, this should apply to all batch requests. However,
 		// since we don't have data right now on the size of each batch, we
@@ -500,12 +672,20 @@ func (s *Server) handleBatchRPC(ctx context.Context, reqs []json.RawMessage, isL
 		// If this is a duplicate Request ID, move the Request to a new batchGroup
 		ids[id]++
 		batchGroupID := ids[id]
-		batchGroup := batchGroup{groupID: batchGroupID, backendGroup: group}
+		// calls of different priority classes are forwarded in different batches
+		priority := s.admission.Classify(ctx, parsedReq.Method, rule)
+		batchGroup := batchGroup{groupID: batchGroupID, backendGroup: group, priority: priority}
 		batches[batchGroup] = append(batches[batchGroup], batchElem{parsedReq, i})
+		rec.setBackendGroup(i, group)
 	}
 
+	// the minibatches of all the groups are forwarded concurrently, up to maxUpstreamBatchParallelism at once.
+	// They write the responses at their own indexes, the rest of the shared state is guarded by mtx
+	var mtx sync.Mutex
 	servedBy := make(map[string]bool, 0)
 	var cached bool
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(s.maxUpstreamBatchParallelism)
 	for group, batch := range batches {
 		var cacheMisses []batchElem
 
@@ -514,6 +694,7 @@ func (s *Server) handleBatchRPC(ctx context.Context, reqs []json.RawMessage, isL
 			if backendRes != nil {
 				responses[req.Index] = backendRes
 				cached = true
+				rec.setCacheHit(req.Index)
 			} else {
 				cacheMisses = append(cacheMisses, req)
 			}
@@ -522,55 +703,78 @@ func (s *Server) handleBatchRPC(ctx context.Context, reqs []json.RawMessage, isL
 		// Create minibatches - each minibatch must be no larger than the maxUpstreamBatchSize
 		numBatches := int(math.Ceil(float64(len(cacheMisses)) / float64(s.maxUpstreamBatchSize)))
 		for i := 0; i < numBatches; i++ {
-			if ctx.Err() == context.DeadlineExceeded {
-				log.Info("short-circuiting batch RPC",
-					"req_id", GetReqID(ctx),
-					"auth", GetAuthCtx(ctx),
-					"batch_index", i,
-				)
-				batchRPCShortCircuitsTotal.Inc()
-				return nil, false, "", context.DeadlineExceeded
-			}
-
 			start := i * s.maxUpstreamBatchSize
 			end := int(math.Min(float64(start+s.maxUpstreamBatchSize), float64(len(cacheMisses))))
-			elems := cacheMisses[start:end]
-			res, sb, err := s.BackendGroups[group.backendGroup].Forward(ctx, createBatchRequest(elems), isBatch)
-			servedBy[sb] = true
-			if err != nil {
-				if errors.Is(err, ErrConsensusGetReceiptsCantBeBatched) ||
-					errors.Is(err, ErrConsensusGetReceiptsInvalidTarget) {
-					return nil, false, "", err
+			group, batchIndex, elems := group, i, cacheMisses[start:end]
+			g.Go(func() error {
+				if ctx.Err() == context.DeadlineExceeded {
+					log.Info("short-circuiting batch RPC",
+						"req_id", GetReqID(ctx),
+						"auth", GetAuthCtx(ctx),
+						"batch_index", batchIndex,
+					)
+					batchRPCShortCircuitsTotal.Inc()
+					return context.DeadlineExceeded
+				}
+				// another minibatch failed the whole request
+				if err := gctx.Err(); err != nil {
+					return err
 				}
-				log.Error(
-					"error forwarding RPC batch",
-					"batch_size", len(elems),
-					"backend_group", group,
-					"req_id", GetReqID(ctx),
-					"err", err,
-				)
-				res = nil
+
+				stats := &forwardStats{}
+				fctx := withPriorityClass(withForwardStats(gctx, stats), group.priority)
+				if !isBatch && s.isStreamable(group.backendGroup, elems[0].Req) {
+					getResponseStream(ctx).enable(elems[0].Req.ID)
+				}
+				res, sb, err := s.BackendGroups[group.backendGroup].Forward(fctx, createBatchRequest(elems), isBatch)
+				mtx.Lock()
+				servedBy[sb] = true
+				mtx.Unlock()
 				for _, elem := range elems {
-					res = append(res, NewRPCErrorRes(elem.Req.ID, err))
+					rec.setForward(elem.Index, sb, stats)
+				}
+				if err == nil {
+					s.mirrorRequests(ctx, group.backendGroup, elems, res)
+				}
+				if err != nil {
+					if errors.Is(err, ErrConsensusGetReceiptsCantBeBatched) ||
+						errors.Is(err, ErrConsensusGetReceiptsInvalidTarget) {
+						return err
+					}
+					log.Error(
+						"error forwarding RPC batch",
+						"batch_size", len(elems),
+						"backend_group", group,
+						"req_id", GetReqID(ctx),
+						"err", err,
+					)
+					res = nil
+					for _, elem := range elems {
+						res = append(res, NewRPCErrorRes(elem.Req.ID, err))
+					}
 				}
-			}
 
-			for i := range elems {
-				responses[elems[i].Index] = res[i]
-
-				// TODO(inphi): batch put these
-				if res[i].Error == nil && res[i].Result != nil {
-					if err := s.cache.PutRPC(ctx, elems[i].Req, res[i]); err != nil {
-						log.Warn(
-							"cache put error",
-							"req_id", GetReqID(ctx),
-							"err", err,
-						)
+				for i := range elems {
+					responses[elems[i].Index] = res[i]
+
+					// TODO(inphi): batch put these
+					if res[i].Error == nil && res[i].Result != nil {
+						if err := s.cache.PutRPC(ctx, elems[i].Req, res[i]); err != nil {
+							log.Warn(
+								"cache put error",
+								"req_id", GetReqID(ctx),
+								"err", err,
+							)
+						}
 					}
 				}
-			}
+				return nil
+			})
 		}
 	}
+	if err := g.Wait(); err != nil {
+		return nil, false, "", err
+	}
 
 	servedByString := ""
 	for sb := range servedBy {
@@ -580,6 +784,8 @@ func (s *Server) handleBatchRPC(ctx context.Context, reqs []json.RawMessage, isL
 		servedByString += sb
 	}
 
+	rec.setResponses(responses)
+	getRequestUsage(ctx).setMethods(methods)
 	return responses, cached, servedByString, nil
 }
 
@@ -608,6 +814,8 @@ func (s *Server) HandleWS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	proxier.validator = s.validator
+
 	activeClientWsConnsGauge.WithLabelValues(GetAuthCtx(ctx)).Inc()
 	go func() {
 		// Below call blocks so run it in a goroutine.
@@ -630,7 +838,9 @@ func (s *Server) populateContext(w http.ResponseWriter, r *http.Request) context
 			xff = ipPort[0]
 		}
 	}
-	ctx := context.WithValue(r.Context(), ContextKeyXForwardedFor, xff) // nolint:staticcheck
+	ctx := context.WithValue(r.Context(), ContextKeyXForwardedFor, xff)           // nolint:staticcheck
+	ctx = context.WithValue(ctx, ContextKeyOrigin, r.Header.Get("Origin"))        // nolint:staticcheck
+	ctx = context.WithValue(ctx, ContextKeyUserAgent, r.Header.Get("User-Agent")) // nolint:staticcheck
 
 	if len(s.authenticatedPaths) > 0 {
 		if authorization == "" || s.authenticatedPaths[authorization] == "" {
@@ -756,6 +966,10 @@ func setCacheHeader(w http.ResponseWriter, cached bool) {
 }
 
 func writeRPCError(ctx context.Context, w http.ResponseWriter, id json.RawMessage, err error) {
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+
 	var res *RPCRes
 	if r, ok := err.(*RPCErr); ok {
 		res = NewRPCErrorRes(id, r)
@@ -771,30 +985,77 @@ func writeRPCRes(ctx context.Context, w http.ResponseWriter, res *RPCRes) {
 		statusCode = res.Error.HTTPErrorCode
 	}
 
-	w.Header().Set("content-type", "application/json")
-	w.WriteHeader(statusCode)
-	ww := &recordLenWriter{Writer: w}
-	enc := json.NewEncoder(ww)
-	if err := enc.Encode(res); err != nil {
+	body, err := json.Marshal(res)
+	if err != nil {
+		log.Error("error writing rpc response", "err", err)
+		RecordRPCError(ctx, BackendProxyd, MethodUnknown, err)
+		return
+	}
+	n, err := writeJSONBody(ctx, w, statusCode, body)
+	if err != nil {
 		log.Error("error writing rpc response", "err", err)
 		RecordRPCError(ctx, BackendProxyd, MethodUnknown, err)
 		return
 	}
 	httpResponseCodesTotal.WithLabelValues(strconv.Itoa(statusCode)).Inc()
-	RecordResponsePayloadSize(ctx, ww.Len)
+	RecordResponsePayloadSize(ctx, len(body)+1)
+	getRequestUsage(ctx).record([]int{len(body)})
+
+	errorCode := 0
+	if res.IsError() {
+		errorCode = res.Error.Code
+	}
+	getAccessLogRecord(ctx).setResult(statusCode, n, errorCode)
 }
 
 func writeBatchRPCRes(ctx context.Context, w http.ResponseWriter, res []*RPCRes) {
-	w.Header().Set("content-type", "application/json")
-	w.WriteHeader(200)
-	ww := &recordLenWriter{Writer: w}
-	enc := json.NewEncoder(ww)
-	if err := enc.Encode(res); err != nil {
+	// the responses are encoded one by one, so that their sizes can be metered
+	body := []byte{'['}
+	sizes := make([]int, len(res))
+	for i, r := range res {
+		b, err := json.Marshal(r)
+		if err != nil {
+			log.Error("error writing batch rpc response", "err", err)
+			RecordRPCError(ctx, BackendProxyd, MethodUnknown, err)
+			return
+		}
+		if i > 0 {
+			body = append(body, ',')
+		}
+		body = append(body, b...)
+		sizes[i] = len(b)
+	}
+	body = append(body, ']')
+	n, err := writeJSONBody(ctx, w, 200, body)
+	if err != nil {
 		log.Error("error writing batch rpc response", "err", err)
 		RecordRPCError(ctx, BackendProxyd, MethodUnknown, err)
 		return
 	}
-	RecordResponsePayloadSize(ctx, ww.Len)
+	RecordResponsePayloadSize(ctx, len(body)+1)
+	getAccessLogRecord(ctx).setResult(200, n, 0)
+	getRequestUsage(ctx).record(sizes)
+}
+
+// writeJSONBody writes a newline terminated JSON body, compressed with the encoding negotiated
+// with the client if it is large enough. It returns the number of bytes written.
+func writeJSONBody(ctx context.Context, w http.ResponseWriter, statusCode int, body []byte) (int, error) {
+	body = append(body, '\n')
+	w.Header().Set("content-type", "application/json")
+
+	if enc := getResponseEncoding(ctx); enc != nil && len(body) >= enc.minSize {
+		compressed, err := compress(enc.encoding, body)
+		if err == nil {
+			RecordCompression(CompressionDirectionResponse, enc.encoding, len(body), len(compressed))
+			w.Header().Set("Content-Encoding", enc.encoding)
+			w.WriteHeader(statusCode)
+			return w.Write(compressed)
+		}
+		log.Warn("error compressing response", "req_id", GetReqID(ctx), "encoding", enc.encoding, "err", err)
+	}
+
+	w.WriteHeader(statusCode)
+	return w.Write(body)
 }
 
 func instrumentedHdlr(h http.Handler) http.HandlerFunc {
@@ -830,6 +1091,22 @@ func GetXForwardedFor(ctx context.Context) string {
 	return xff
 }
 
+func GetOrigin(ctx context.Context) string {
+	origin, ok := ctx.Value(ContextKeyOrigin).(string)
+	if !ok {
+		return ""
+	}
+	return origin
+}
+
+func GetUserAgent(ctx context.Context) string {
+	userAgent, ok := ctx.Value(ContextKeyUserAgent).(string)
+	if !ok {
+		return ""
+	}
+	return userAgent
+}
+
 type recordLenWriter struct {
 	io.Writer
 	Len int
@@ -847,6 +1124,10 @@ func (n *NoopRPCCache) GetRPC(context.Context, *RPCReq) (*RPCRes, error) {
 	return nil, nil
 }
 
+func (n *NoopRPCCache) IsCacheable(string) bool {
+	return false
+}
+
 func (n *NoopRPCCache) PutRPC(context.Context, *RPCReq, *RPCRes) error {
 	return nil
 }
@@ -0,0 +1,405 @@
+package proxyd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+const (
+	ContextKeyResponseStream = "response_stream"
+
+	StreamResultCompleted   = "completed"
+	StreamResultInterrupted = "interrupted"
+
+	streamBufferSize = 32 * 1024
+)
+
+var (
+	// ErrResponseStreamInterrupted is returned once a streamed response failed after it started being
+	// written to the client, so that it can neither be retried nor answered with an error
+	ErrResponseStreamInterrupted = errors.New("response stream interrupted")
+
+	errStreamUnexpectedJSON = errors.New("unexpected JSON in streamed response")
+)
+
+// responseStream copies the response of a single request from the backend to the client as it is read,
+// rewriting only its id, instead of buffering, decoding and encoding it again.
+// Only the members preceding the result are buffered, so that error responses are handled as usual,
+// e.g. retried on another backend
+type responseStream struct {
+	w              http.ResponseWriter
+	servedByHeader bool
+
+	// id is the ID of the client request, set once the request is known to be streamable
+	id       json.RawMessage
+	servedBy string
+
+	committed   bool
+	interrupted bool
+	body        *recordLenWriter
+	wire        *recordLenWriter
+	compressor  io.WriteCloser
+	encoding    string
+}
+
+func withResponseStream(ctx context.Context, stream *responseStream) context.Context {
+	return context.WithValue(ctx, ContextKeyResponseStream, stream) // nolint:staticcheck
+}
+
+func getResponseStream(ctx context.Context) *responseStream {
+	stream, ok := ctx.Value(ContextKeyResponseStream).(*responseStream)
+	if !ok {
+		return nil
+	}
+	return stream
+}
+
+// streamFor returns the stream the response to the requests should be copied to, if any
+func streamFor(ctx context.Context, reqs []*RPCReq, isBatch bool) *responseStream {
+	stream := getResponseStream(ctx)
+	if stream == nil || stream.id == nil || stream.committed || isBatch || len(reqs) != 1 {
+		return nil
+	}
+	return stream
+}
+
+// isStreamable returns whether the response to a single request can be streamed, i.e. whether
+// it doesn't need to be decoded to be cached, compared with a mirror or translated
+func (s *Server) isStreamable(group string, req *RPCReq) bool {
+	if req.Method == ConsensusGetReceiptsMethod || s.cache.IsCacheable(req.Method) {
+		return false
+	}
+	for _, m := range s.mirrors {
+		if m.matches(group, req.Method) {
+			return false
+		}
+	}
+	return true
+}
+
+// enable makes the response to the request with the id streamable
+func (s *responseStream) enable(id json.RawMessage) {
+	if s == nil {
+		return
+	}
+	s.id = id
+}
+
+func (s *responseStream) setServedBy(servedBy string) {
+	if s == nil {
+		return
+	}
+	s.servedBy = servedBy
+}
+
+// Committed returns whether the response was written to the client
+func (s *responseStream) Committed() bool {
+	return s != nil && s.committed
+}
+
+// copy streams the response of the backend to the client if it has a result. Otherwise, it returns
+// the whole response body, to be handled as a buffered one
+func (s *responseStream) copy(ctx context.Context, httpRes *http.Response, maxSize int64) ([]byte, error) {
+	// LimitReader fails once maxSize bytes are read, so a body of maxSize bytes is too large already
+	if httpRes.ContentLength >= maxSize {
+		return nil, ErrBackendResponseTooLarge
+	}
+	body := LimitReader(httpRes.Body, maxSize)
+	head := &headRecorder{}
+	sc := &jsonScanner{r: bufio.NewReaderSize(io.TeeReader(body, head), streamBufferSize)}
+
+	members, ok := sc.readHead()
+	if !ok {
+		// the bytes read by the scanner are replayed, followed by the rest of the body
+		return io.ReadAll(io.MultiReader(bytes.NewReader(head.buf.Bytes()), body))
+	}
+	head.stop()
+
+	s.commit(ctx, httpRes.ContentLength)
+	err := s.writeObject(sc, members)
+	if s.compressor != nil {
+		if cerr := s.compressor.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		s.interrupted = true
+		RecordStreamedResponse(StreamResultInterrupted)
+		log.Warn(
+			"response stream interrupted",
+			"req_id", GetReqID(ctx),
+			"served_by", s.servedBy,
+			"streamed_bytes", s.body.Len,
+			"err", err,
+		)
+		return nil, ErrResponseStreamInterrupted
+	}
+
+	if s.compressor != nil {
+		RecordCompression(CompressionDirectionResponse, s.encoding, s.body.Len, s.wire.Len)
+	}
+	httpResponseCodesTotal.WithLabelValues("200").Inc()
+	RecordResponsePayloadSize(ctx, s.body.Len)
+	RecordStreamedResponse(StreamResultCompleted)
+	getAccessLogRecord(ctx).setResult(200, s.wire.Len, 0)
+	return nil, nil
+}
+
+// commit writes the headers of the response, which can't be changed afterwards
+func (s *responseStream) commit(ctx context.Context, contentLength int64) {
+	s.committed = true
+	s.wire = &recordLenWriter{Writer: s.w}
+	s.body = &recordLenWriter{Writer: s.wire}
+
+	s.w.Header().Set("content-type", "application/json")
+	setCacheHeader(s.w, false)
+	if s.servedByHeader {
+		s.w.Header().Set("x-served-by", s.servedBy)
+	}
+	// the size of the response is only known upfront if the backend sent it
+	if enc := getResponseEncoding(ctx); enc != nil && (contentLength < 0 || contentLength >= int64(enc.minSize)) {
+		compressor, err := newCompressWriter(enc.encoding, s.wire)
+		if err == nil {
+			s.compressor = compressor
+			s.encoding = enc.encoding
+			s.body.Writer = compressor
+			s.w.Header().Set("Content-Encoding", enc.encoding)
+		} else {
+			log.Warn("error compressing response", "req_id", GetReqID(ctx), "encoding", enc.encoding, "err", err)
+		}
+	}
+	s.w.WriteHeader(200)
+}
+
+// writeObject writes the response object with the id of the client, copying its result
+// and the members following it from the scanner
+func (s *responseStream) writeObject(sc *jsonScanner, members [][]byte) error {
+	var prefix bytes.Buffer
+	prefix.WriteByte('{')
+	for _, member := range members {
+		prefix.Write(member)
+		prefix.WriteByte(',')
+	}
+	prefix.WriteString(`"id":`)
+	prefix.Write(s.id)
+	prefix.WriteString(`,"result":`)
+	if _, err := s.body.Write(prefix.Bytes()); err != nil {
+		return err
+	}
+	if err := sc.copyValue(s.body); err != nil {
+		return err
+	}
+
+	for {
+		c, err := sc.next()
+		if err != nil {
+			return err
+		}
+		if c == '}' {
+			_, err := s.body.Write([]byte("}\n"))
+			return err
+		}
+		if c != ',' {
+			return errStreamUnexpectedJSON
+		}
+		key, name, err := sc.readKey()
+		if err != nil {
+			return err
+		}
+		// the id of the backend was replaced by the one of the client
+		if name == "id" {
+			if err := sc.copyValue(io.Discard); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := s.body.Write(append(append([]byte{','}, key...), ':')); err != nil {
+			return err
+		}
+		if err := sc.copyValue(s.body); err != nil {
+			return err
+		}
+	}
+}
+
+// headRecorder records the bytes read from the backend until the response is committed,
+// to replay them if the response isn't streamed
+type headRecorder struct {
+	buf     bytes.Buffer
+	stopped bool
+}
+
+func (h *headRecorder) Write(p []byte) (int, error) {
+	if !h.stopped {
+		h.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+func (h *headRecorder) stop() {
+	h.stopped = true
+	h.buf = bytes.Buffer{}
+}
+
+// jsonScanner reads a JSON-RPC response object member by member, copying the values as raw bytes
+type jsonScanner struct {
+	r *bufio.Reader
+}
+
+// readHead reads the members of the object up to its result, which is left unread. It returns false
+// if the object has no result or is an error, or isn't a valid JSON-RPC response
+func (sc *jsonScanner) readHead() ([][]byte, bool) {
+	if c, err := sc.next(); err != nil || c != '{' {
+		return nil, false
+	}
+	var members [][]byte
+	for {
+		key, name, err := sc.readKey()
+		if err != nil {
+			return nil, false
+		}
+		switch name {
+		case "result":
+			return members, true
+		case "error":
+			return nil, false
+		}
+		var value bytes.Buffer
+		if err := sc.copyValue(&value); err != nil || value.Len() == 0 {
+			return nil, false
+		}
+		if name != "id" {
+			members = append(members, append(append(key, ':'), value.Bytes()...))
+		}
+		if c, err := sc.next(); err != nil || c != ',' {
+			return nil, false
+		}
+	}
+}
+
+// readKey reads the key of a member and its colon, and returns the raw and the decoded key
+func (sc *jsonScanner) readKey() ([]byte, string, error) {
+	if c, err := sc.peek(); err != nil {
+		return nil, "", err
+	} else if c != '"' {
+		return nil, "", errStreamUnexpectedJSON
+	}
+	var key bytes.Buffer
+	if err := sc.copyValue(&key); err != nil {
+		return nil, "", err
+	}
+	var name string
+	if err := json.Unmarshal(key.Bytes(), &name); err != nil {
+		return nil, "", errStreamUnexpectedJSON
+	}
+	if c, err := sc.next(); err != nil {
+		return nil, "", err
+	} else if c != ':' {
+		return nil, "", errStreamUnexpectedJSON
+	}
+	return key.Bytes(), name, nil
+}
+
+// peek skips the whitespace and returns the next byte without consuming it
+func (sc *jsonScanner) peek() (byte, error) {
+	for {
+		c, err := sc.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch c {
+		case ' ', '\t', '\n', '\r':
+			continue
+		}
+		return c, sc.r.UnreadByte()
+	}
+}
+
+// next skips the whitespace and consumes the next byte
+func (sc *jsonScanner) next() (byte, error) {
+	if _, err := sc.peek(); err != nil {
+		return 0, err
+	}
+	return sc.r.ReadByte()
+}
+
+// copyValue copies the next value to dst, scanning the buffered bytes at once rather than one by one
+func (sc *jsonScanner) copyValue(dst io.Writer) error {
+	if _, err := sc.peek(); err != nil {
+		return err
+	}
+	depth := 0
+	inString, escaped := false, false
+	for {
+		buf, err := sc.r.Peek(max(sc.r.Buffered(), 1))
+		if len(buf) == 0 {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+
+		end := -1
+	scan:
+		for i, c := range buf {
+			if inString {
+				switch {
+				case escaped:
+					escaped = false
+				case c == '\\':
+					escaped = true
+				case c == '"':
+					inString = false
+					if depth == 0 {
+						end = i + 1
+						break scan
+					}
+				}
+				continue
+			}
+			switch c {
+			case '"':
+				inString = true
+			case '{', '[':
+				depth++
+			case '}', ']':
+				// the end of the enclosing object terminates a number or a literal
+				if depth == 0 {
+					end = i
+					break scan
+				}
+				depth--
+				if depth == 0 {
+					end = i + 1
+					break scan
+				}
+			case ',', ' ', '\t', '\n', '\r':
+				if depth == 0 {
+					end = i
+					break scan
+				}
+			}
+		}
+
+		n := len(buf)
+		if end >= 0 {
+			n = end
+		}
+		if _, err := dst.Write(buf[:n]); err != nil {
+			return err
+		}
+		if _, err := sc.r.Discard(n); err != nil {
+			return err
+		}
+		if end >= 0 {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package proxyd
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseStream(t *testing.T) {
+	copyResponse := func(ctx context.Context, body string, contentLength int64, maxSize int64) (*responseStream, *httptest.ResponseRecorder, []byte, error) {
+		w := httptest.NewRecorder()
+		stream := &responseStream{w: w, servedByHeader: true}
+		stream.enable([]byte(`"abc"`))
+		stream.setServedBy("main/node")
+		httpRes := &http.Response{
+			StatusCode:    200,
+			Body:          io.NopCloser(strings.NewReader(body)),
+			ContentLength: contentLength,
+		}
+		resB, err := stream.copy(ctx, httpRes, maxSize)
+		return stream, w, resB, err
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{
+			name:     "object result",
+			body:     `{"jsonrpc":"2.0","id":7,"result":{"logs":[{"data":"0x1"},"}\"]"],"n":null}}`,
+			expected: `{"jsonrpc":"2.0","id":"abc","result":{"logs":[{"data":"0x1"},"}\"]"],"n":null}}`,
+		},
+		{
+			name:     "id after result",
+			body:     " {\n \"result\" : \"0x1\" ,\"id\": 7, \"jsonrpc\":\"2.0\"}\n",
+			expected: `{"id":"abc","result":"0x1","jsonrpc":"2.0"}`,
+		},
+		{
+			name:     "scalar result",
+			body:     `{"jsonrpc":"2.0","id":7,"result":12}`,
+			expected: `{"jsonrpc":"2.0","id":"abc","result":12}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream, w, resB, err := copyResponse(context.Background(), tt.body, -1, 1024)
+			require.NoError(t, err)
+			require.Nil(t, resB)
+			require.True(t, stream.Committed())
+			require.Equal(t, tt.expected+"\n", w.Body.String())
+			require.Equal(t, "main/node", w.Header().Get("x-served-by"))
+			require.Equal(t, "MISS", w.Header().Get(cacheStatusHdr))
+		})
+	}
+
+	// error responses are returned to be handled as buffered ones
+	errorBody := `{"jsonrpc":"2.0","id":7,"error":{"code":-32000,"message":"header not found"}}`
+	stream, w, resB, err := copyResponse(context.Background(), errorBody, -1, 1024)
+	require.NoError(t, err)
+	require.Equal(t, errorBody, string(resB))
+	require.False(t, stream.Committed())
+	require.Zero(t, w.Body.Len())
+
+	invalidBody := `{"jsonrpc":"2.0","id":7}`
+	_, _, resB, err = copyResponse(context.Background(), invalidBody, -1, 1024)
+	require.NoError(t, err)
+	require.Equal(t, invalidBody, string(resB))
+
+	// the size limit is enforced before the response is committed if the size is known
+	largeBody := `{"jsonrpc":"2.0","id":7,"result":"` + strings.Repeat("ff", 1024) + `"}`
+	stream, _, _, err = copyResponse(context.Background(), largeBody, int64(len(largeBody)), 1024)
+	require.ErrorIs(t, err, ErrBackendResponseTooLarge)
+	require.False(t, stream.Committed())
+
+	// and interrupts the response otherwise
+	stream, _, _, err = copyResponse(context.Background(), largeBody, -1, 1024)
+	require.ErrorIs(t, err, ErrResponseStreamInterrupted)
+	require.True(t, stream.Committed())
+	require.True(t, stream.interrupted)
+
+	// the response is compressed as negotiated with the client
+	ctx := withResponseEncoding(context.Background(), EncodingGzip, 0)
+	_, w, _, err = copyResponse(ctx, largeBody, -1, 4096)
+	require.NoError(t, err)
+	require.Equal(t, EncodingGzip, w.Header().Get("Content-Encoding"))
+	decompressed, err := decompress(EncodingGzip, w.Body.Bytes())
+	require.NoError(t, err)
+	require.Equal(t, strings.Replace(largeBody, `"id":7`, `"id":"abc"`, 1)+"\n", string(decompressed))
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ethereum-optimism/optimism/proxyd/tools/replay/replayer"
+)
+
+func main() {
+	input := flag.String("input", "", "capture file written by proxyd")
+	target := flag.String("target", "", "URL of the proxyd or backend to replay the traffic against")
+	speed := flag.Float64("speed", 1, "replay speed relative to the capture, 0 replays as fast as possible")
+	concurrency := flag.Int("concurrency", 64, "maximum number of in-flight requests")
+	timeout := flag.Duration("timeout", 10*time.Second, "timeout of each replayed request")
+	headers := flag.Bool("headers", true, "replay the captured request headers")
+	mismatches := flag.Int("mismatches", 10, "number of response mismatches to print")
+	flag.Parse()
+
+	if *input == "" || *target == "" {
+		fmt.Printf("replay traffic captured by proxyd against a target URL\n")
+		fmt.Printf("usage: replay -input <capture file> -target <url> [-speed 1]\n")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	reqs, err := replayer.Load(*input)
+	if err != nil {
+		fmt.Printf("error loading capture: %v\n", err)
+		os.Exit(1)
+	}
+
+	r := &replayer.Replayer{
+		Target:        *target,
+		Speed:         *speed,
+		Concurrency:   *concurrency,
+		Timeout:       *timeout,
+		ReplayHeaders: *headers,
+		MaxMismatches: *mismatches,
+	}
+	report := r.Run(reqs)
+	report.Print(os.Stdout)
+}
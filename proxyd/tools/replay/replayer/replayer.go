@@ -0,0 +1,184 @@
+package replayer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum-optimism/optimism/proxyd"
+)
+
+// headers that must not be copied from the captured request
+var skippedHeaders = map[string]bool{
+	"Accept-Encoding":   true,
+	"Connection":        true,
+	"Content-Length":    true,
+	"Keep-Alive":        true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+}
+
+const redactedValue = "[REDACTED]"
+
+// Load reads a capture file, ordered by the time the requests were received
+func Load(path string) ([]*proxyd.CapturedRequest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var reqs []*proxyd.CapturedRequest
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		req := new(proxyd.CapturedRequest)
+		if err := json.Unmarshal(line, req); err != nil {
+			return nil, fmt.Errorf("error parsing captured request: %w", err)
+		}
+		reqs = append(reqs, req)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(reqs, func(i, j int) bool {
+		return reqs[i].Timestamp.Before(reqs[j].Timestamp)
+	})
+	return reqs, nil
+}
+
+type Replayer struct {
+	Target        string
+	Speed         float64
+	Concurrency   int
+	Timeout       time.Duration
+	ReplayHeaders bool
+	MaxMismatches int
+}
+
+type result struct {
+	req          *proxyd.CapturedRequest
+	latency      time.Duration
+	statusCode   int
+	response     []byte
+	transportErr error
+}
+
+// Run sends the captured requests to the target, preserving their original spacing scaled by Speed
+func (r *Replayer) Run(reqs []*proxyd.CapturedRequest) *Report {
+	concurrency := r.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	client := &http.Client{Timeout: r.Timeout}
+	sem := make(chan struct{}, concurrency)
+	results := make([]*result, len(reqs))
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i, req := range reqs {
+		if r.Speed > 0 {
+			offset := req.Timestamp.Sub(reqs[0].Timestamp)
+			wait := time.Until(start.Add(time.Duration(float64(offset) / r.Speed)))
+			if wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, req *proxyd.CapturedRequest) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i] = r.send(client, req)
+		}(i, req)
+	}
+	wg.Wait()
+
+	return newReport(results, time.Since(start), r.MaxMismatches)
+}
+
+func (r *Replayer) send(client *http.Client, req *proxyd.CapturedRequest) *result {
+	res := &result{req: req}
+
+	httpReq, err := http.NewRequest(http.MethodPost, r.Target, strings.NewReader(req.Body))
+	if err != nil {
+		res.transportErr = err
+		return res
+	}
+	if r.ReplayHeaders {
+		for name, value := range req.Headers {
+			if skippedHeaders[http.CanonicalHeaderKey(name)] || value == redactedValue {
+				continue
+			}
+			httpReq.Header.Set(name, value)
+		}
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	start := time.Now()
+	httpRes, err := client.Do(httpReq)
+	if err != nil {
+		res.transportErr = err
+		res.latency = time.Since(start)
+		return res
+	}
+	defer httpRes.Body.Close()
+	res.response, res.transportErr = io.ReadAll(httpRes.Body)
+	res.latency = time.Since(start)
+	res.statusCode = httpRes.StatusCode
+	return res
+}
+
+// isError returns true if the HTTP status is not 200 or if any of the JSON-RPC responses is an error
+func isError(statusCode int, body []byte) bool {
+	if statusCode != http.StatusOK {
+		return true
+	}
+	body = bytes.TrimSpace(body)
+	if proxyd.IsBatch(body) {
+		var batch []*proxyd.RPCRes
+		if err := json.Unmarshal(body, &batch); err != nil {
+			return true
+		}
+		for _, res := range batch {
+			if res.IsError() {
+				return true
+			}
+		}
+		return false
+	}
+	res := new(proxyd.RPCRes)
+	if err := json.Unmarshal(body, res); err != nil {
+		return true
+	}
+	return res.IsError()
+}
+
+// normalize re-encodes a JSON document so that key order and whitespace do not cause mismatches
+func normalize(body []byte) string {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return string(bytes.TrimSpace(body))
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		return string(bytes.TrimSpace(body))
+	}
+	return string(out)
+}
@@ -0,0 +1,146 @@
+package replayer
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const maxPrintedResponseLen = 500
+
+type Mismatch struct {
+	ReqID    string
+	Request  string
+	Expected string
+	Actual   string
+}
+
+type Report struct {
+	Total           int
+	TransportErrors int
+	Duration        time.Duration
+	OriginalLatency Percentiles
+	ReplayLatency   Percentiles
+	OriginalErrors  int
+	ReplayErrors    int
+	Compared        int
+	MismatchCount   int
+	Mismatches      []*Mismatch
+}
+
+type Percentiles struct {
+	P50 time.Duration
+	P90 time.Duration
+	P99 time.Duration
+	Max time.Duration
+}
+
+func newReport(results []*result, duration time.Duration, maxMismatches int) *Report {
+	report := &Report{
+		Total:    len(results),
+		Duration: duration,
+	}
+
+	var original, replayed []time.Duration
+	for _, res := range results {
+		original = append(original, time.Duration(res.req.DurationMs*float64(time.Millisecond)))
+		// without a captured response, only the status code tells whether the original request failed
+		if res.req.StatusCode != http.StatusOK || (res.req.Response != "" && isError(res.req.StatusCode, []byte(res.req.Response))) {
+			report.OriginalErrors++
+		}
+
+		if res.transportErr != nil {
+			report.TransportErrors++
+			report.ReplayErrors++
+			continue
+		}
+		replayed = append(replayed, res.latency)
+		if isError(res.statusCode, res.response) {
+			report.ReplayErrors++
+		}
+
+		if res.req.Response == "" {
+			continue
+		}
+		report.Compared++
+		expected := normalize([]byte(res.req.Response))
+		actual := normalize(res.response)
+		if expected != actual {
+			report.MismatchCount++
+			if len(report.Mismatches) < maxMismatches {
+				report.Mismatches = append(report.Mismatches, &Mismatch{
+					ReqID:    res.req.ReqID,
+					Request:  res.req.Body,
+					Expected: expected,
+					Actual:   actual,
+				})
+			}
+		}
+	}
+
+	report.OriginalLatency = percentiles(original)
+	report.ReplayLatency = percentiles(replayed)
+	return report
+}
+
+func percentiles(durations []time.Duration) Percentiles {
+	if len(durations) == 0 {
+		return Percentiles{}
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	at := func(p float64) time.Duration {
+		idx := int(p * float64(len(durations)-1))
+		return durations[idx]
+	}
+	return Percentiles{
+		P50: at(0.5),
+		P90: at(0.9),
+		P99: at(0.99),
+		Max: durations[len(durations)-1],
+	}
+}
+
+func rate(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total) * 100
+}
+
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "replayed %d requests in %s (%d transport errors)\n\n", r.Total, r.Duration.Round(time.Millisecond), r.TransportErrors)
+
+	fmt.Fprintf(w, "%-10s %12s %12s %12s %12s\n", "latency", "p50", "p90", "p99", "max")
+	for _, row := range []struct {
+		name string
+		p    Percentiles
+	}{{"original", r.OriginalLatency}, {"replay", r.ReplayLatency}} {
+		fmt.Fprintf(w, "%-10s %12s %12s %12s %12s\n", row.name,
+			row.p.P50.Round(time.Microsecond), row.p.P90.Round(time.Microsecond),
+			row.p.P99.Round(time.Microsecond), row.p.Max.Round(time.Microsecond))
+	}
+
+	originalRate := rate(r.OriginalErrors, r.Total)
+	replayRate := rate(r.ReplayErrors, r.Total)
+	fmt.Fprintf(w, "\nerror rate: original %.2f%% (%d), replay %.2f%% (%d), diff %+.2f%%\n",
+		originalRate, r.OriginalErrors, replayRate, r.ReplayErrors, replayRate-originalRate)
+
+	if r.Compared == 0 {
+		fmt.Fprintf(w, "responses: not compared, capture with include_responses = true\n")
+		return
+	}
+	fmt.Fprintf(w, "responses: %d mismatches out of %d compared\n", r.MismatchCount, r.Compared)
+	for _, m := range r.Mismatches {
+		fmt.Fprintf(w, "\nreq_id %s\n  request:  %s\n  expected: %s\n  actual:   %s\n",
+			m.ReqID, truncate(m.Request), truncate(m.Expected), truncate(m.Actual))
+	}
+}
+
+func truncate(s string) string {
+	if len(s) <= maxPrintedResponseLen {
+		return s
+	}
+	return s[:maxPrintedResponseLen] + "..."
+}
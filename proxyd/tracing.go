@@ -0,0 +1,112 @@
+package proxyd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	TracingExporterOTLP   = "otlp"
+	TracingExporterStdout = "stdout"
+
+	defaultTracingServiceName = "proxyd"
+	defaultTracingEndpoint    = "localhost:4318"
+)
+
+const (
+	AttrMethod       = attribute.Key("rpc.method")
+	AttrBackend      = attribute.Key("proxyd.backend")
+	AttrBackendGroup = attribute.Key("proxyd.backend_group")
+	AttrCacheHit     = attribute.Key("proxyd.cache_hit")
+	AttrBatchSize    = attribute.Key("proxyd.batch_size")
+	AttrAttempt      = attribute.Key("proxyd.attempt")
+	AttrReqID        = attribute.Key("proxyd.req_id")
+	AttrRewritten    = attribute.Key("proxyd.rewritten")
+	AttrOverridden   = attribute.Key("proxyd.overridden")
+)
+
+// tracer is resolved through the global provider, so spans are no-ops until InitTracing is called
+var tracer = otel.Tracer("github.com/ethereum-optimism/optimism/proxyd")
+
+// InitTracing installs the global tracer provider and the W3C trace context propagator.
+// It returns a function that flushes and stops the exporter.
+func InitTracing(ctx context.Context, cfg TracingConfig) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case "", TracingExporterOTLP:
+		endpoint := cfg.Endpoint
+		if endpoint == "" {
+			endpoint = defaultTracingEndpoint
+		}
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint)}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	case TracingExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter: %s", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, wrapErr(err, "error creating tracing exporter")
+	}
+
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = defaultTracingServiceName
+	}
+
+	sampler := sdktrace.ParentBased(sdktrace.AlwaysSample())
+	if cfg.SampleRate > 0 && cfg.SampleRate < 1 {
+		sampler = sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRate))
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sampler),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return tp.Shutdown, nil
+}
+
+// startSpan is a convenient wrapper to start a span with the given attributes
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if reqID := GetReqID(ctx); reqID != "" {
+		attrs = append(attrs, AttrReqID.String(reqID))
+	}
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records the error, if any, and ends the span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// methodAttr returns the method attribute of a set of requests
+func methodAttr(reqs []*RPCReq, isBatch bool) attribute.KeyValue {
+	if isBatch || len(reqs) != 1 {
+		return AttrMethod.String("<batch>")
+	}
+	return AttrMethod.String(reqs[0].Method)
+}
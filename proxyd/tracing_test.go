@@ -0,0 +1,63 @@
+package proxyd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"golang.org/x/sync/semaphore"
+)
+
+func TestTracingPropagation(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider())
+	}()
+
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","result":"0x1","id":1}`))
+	}))
+	defer server.Close()
+
+	be := NewBackend("node", server.URL, "", semaphore.NewWeighted(1))
+	bg := &BackendGroup{
+		Name:             "main",
+		Backends:         []*Backend{be},
+		FallbackBackends: map[string]bool{"node": false},
+	}
+
+	// simulate an incoming request carrying a W3C trace context
+	incoming := make(http.Header)
+	incoming.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.HeaderCarrier(incoming))
+
+	req := &RPCReq{JSONRPC: JSONRPCVersion, Method: "eth_chainId", ID: []byte("1")}
+	res, servedBy, err := bg.Forward(ctx, []*RPCReq{req}, false)
+	require.NoError(t, err)
+	require.Equal(t, "main/node", servedBy)
+	require.Equal(t, "0x1", res[0].Result)
+
+	require.Contains(t, traceparent, "4bf92f3577b34da6a3ce929d0e0e4736")
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	require.Equal(t, "Backend.doForward", spans[0].Name())
+	require.Equal(t, "BackendGroup.Forward", spans[1].Name())
+	for _, span := range spans {
+		require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID().String())
+	}
+	require.Contains(t, spans[0].Attributes(), AttrBackend.String("node"))
+	require.Contains(t, spans[1].Attributes(), AttrBackendGroup.String("main"))
+	require.Contains(t, spans[1].Attributes(), AttrMethod.String("eth_chainId"))
+}